@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"net"
 	"os"
@@ -12,14 +14,22 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/auth"
+	"github.com/vkeeps/agera-logs/internal/config"
 	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/gelf"
 	"github.com/vkeeps/agera-logs/internal/grpc"
+	"github.com/vkeeps/agera-logs/internal/health"
 	"github.com/vkeeps/agera-logs/internal/http"
 	"github.com/vkeeps/agera-logs/internal/logger"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/spool"
+	"github.com/vkeeps/agera-logs/internal/syslog"
 	"github.com/vkeeps/agera-logs/internal/tcp"
 	"github.com/vkeeps/agera-logs/internal/udp"
 	"github.com/vkeeps/agera-logs/proto"
 	gg "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func getAvailablePort(basePort int, protocol string, log *logrus.Logger) (net.Listener, int, error) {
@@ -44,16 +54,117 @@ func getAvailablePort(basePort int, protocol string, log *logrus.Logger) (net.Li
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "配置文件路径，默认尝试 "+config.DefaultPath)
+	upgradeTables := flag.Bool("upgrade-tables", false, "把旧结构的日志表升级到当前的分区、排序键和索引后退出")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "列出日志表待执行的结构变更后退出，不做修改")
+	flag.Parse()
+
+	// 加载配置
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "配置加载失败: %v\n", err)
+		os.Exit(1)
+	}
+
 	// 初始化日志
-	log, err := logger.InitLogger("debug", "agera.log")
+	log, err := logger.InitLogger(cfg.Log.Level, cfg.Log.File)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "日志初始化失败: %v\n", err)
 		os.Exit(1)
 	}
+	if err := logger.SetLevel(log, cfg.Log.Level); err != nil {
+		fmt.Fprintf(os.Stderr, "日志初始化失败: %v\n", err)
+		os.Exit(1)
+	}
 
 	// 初始化数据库
-	db.InitBolt(log)
-	db.InitClickHouse(log)
+	db.InitBolt(cfg.Bolt.Path, log)
+	db.InitClickHouse(cfg.ClickHouse, log)
+
+	if *migrateDryRun {
+		pending, err := db.MigrateTables(true, log)
+		for _, m := range pending {
+			fmt.Printf("%s.%s -> 版本 %d: %s\n", m.Schema, m.Module, m.Version, m.Description)
+			for _, stmt := range m.Statements {
+				fmt.Printf("    %s\n", stmt)
+			}
+		}
+		fmt.Printf("共 %d 项待执行的结构变更，当前版本 %d\n", len(pending), db.CurrentTableVersion)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("检查日志表结构失败: %v", err))
+		}
+		return
+	}
+	if *upgradeTables {
+		n, err := db.UpgradeTables(log)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("升级日志表失败: %v", err))
+		}
+		log.Info(fmt.Sprintf("日志表升级完成，共升级 %d 张表", n))
+		return
+	}
+
+	// 启动时执行日志表的结构变更，失败的表会在首次写入时重试
+	if _, err := db.MigrateTables(false, log); err != nil {
+		log.Error(fmt.Sprintf("日志表结构变更未全部完成: %v", err))
+	}
+
+	// 写前日志：ClickHouse 不可用时日志先落在本地，恢复后按顺序重放
+	sp, err := spool.Open(db.NewClickHouseStorage(log), cfg.Spool, log)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("spool 初始化失败: %v", err))
+	}
+	// ClickHouse 不可用导致的写入失败不计入重试次数，只有写得进去其他表时才把失败的记录移入死信文件
+	sp.SetProbe(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		return db.PingClickHouse(ctx)
+	})
+	var store db.Storage = sp
+
+	// 所有传输方式共用的接收流水线
+	p := pipeline.New(store, cfg.Pipeline, log)
+	certs, err := auth.NewCerts(cfg.Auth.ClientCerts)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("客户端证书授权配置加载失败: %v", err))
+	}
+	p.SetClientCerts(certs)
+	if !cfg.Pipeline.RequireAPIKey {
+		log.Warn("日志推送未要求 ingest token，任何人都可以用 schema 名称 / schema_id 向任意 schema 写入日志")
+	}
+	// 日志先追加到 spool 再确认接收，缓冲区中未写入的日志在进程退出后由 spool 重放
+	p.SetSpool(sp)
+	p.Start()
+
+	// 就绪检查：ClickHouse、BoltDB、缓冲区和 spool 积压，供 /readyz 和 gRPC 健康服务使用
+	checker := health.NewChecker(health.DefaultTimeout)
+	checker.Register("clickhouse", db.PingClickHouse)
+	checker.Register("boltdb", func(context.Context) error { return db.PingBolt() })
+	checker.Register("pipeline", health.BufferCheck(p, health.DefaultSaturation))
+	checker.Register("spool", health.SpoolCheck(sp, health.DefaultSaturation))
+
+	// SIGHUP 重新加载配置，只应用日志级别和 pipeline 参数
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		current := cfg
+		for range hupChan {
+			next, err := config.Load(*configPath)
+			if err != nil {
+				log.Error(fmt.Sprintf("重新加载配置失败，继续使用当前配置: %v", err))
+				continue
+			}
+			if err := logger.SetLevel(log, next.Log.Level); err != nil {
+				log.Error(fmt.Sprintf("更新日志级别失败: %v", err))
+			}
+			p.Reconfigure(next.Pipeline)
+			if changed := current.RestartRequired(next); len(changed) > 0 {
+				log.Warn(fmt.Sprintf("以下配置修改后需要重启才能生效: %v", changed))
+			}
+			current = next
+			log.Info("配置已重新加载")
+		}
+	}()
 
 	// 上下文和信号处理
 	ctx, cancel := context.WithCancel(context.Background())
@@ -65,31 +176,41 @@ func main() {
 	var wg sync.WaitGroup
 
 	// gRPC 服务
-	grpcBasePort := 50051
-	grpcLis, grpcPort, err := getAvailablePort(grpcBasePort, "tcp", log)
+	grpcLis, grpcPort, err := getAvailablePort(cfg.GRPC.Port, "tcp", log)
 	if err != nil {
 		log.Fatal(fmt.Sprintf("获取 gRPC 端口失败: %v", err))
 	}
 	os.Setenv("GRPC_PORT", strconv.Itoa(grpcPort))
-	grpcServer := gg.NewServer()
-	proto.RegisterLogServiceServer(grpcServer, &grpc.LogServer{Logger: log})
+	grpcOpts := []gg.ServerOption{
+		gg.ChainUnaryInterceptor(grpc.UnaryServerInterceptor()),
+		gg.ChainStreamInterceptor(grpc.StreamServerInterceptor()),
+	}
+	grpcTLS, err := cfg.GRPC.TLS.Server()
+	if err != nil {
+		log.Fatal(fmt.Sprintf("gRPC TLS 配置加载失败: %v", err))
+	}
+	if grpcTLS != nil {
+		grpcOpts = append(grpcOpts, gg.Creds(credentials.NewTLS(grpcTLS)))
+	}
+	grpcServer := gg.NewServer(grpcOpts...)
+	proto.RegisterLogServiceServer(grpcServer, &grpc.LogServer{Logger: log, Pipeline: p})
+	grpc.RegisterHealth(grpcServer, checker, grpc.DefaultHealthInterval, ctx.Done(), log)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Info(fmt.Sprintf("gRPC 服务跑起来了，端口: %d", grpcPort))
+		log.Info(fmt.Sprintf("gRPC 服务跑起来了，端口: %d，TLS: %v", grpcPort, grpcTLS != nil))
 		if err := grpcServer.Serve(grpcLis); err != nil && err != gg.ErrServerStopped {
 			log.Error(fmt.Sprintf("gRPC 服务挂了: %v", err))
 		}
 	}()
 
 	// UDP 服务
-	udpBasePort := 50052
 	udpStopChan := make(chan struct{})
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Info(fmt.Sprintf("启动 UDP 服务，基础端口: %d", udpBasePort))
-		udp.StartUDPServer(udpBasePort, udpStopChan, log)
+		log.Info(fmt.Sprintf("启动 UDP 服务，基础端口: %d", cfg.UDP.Port))
+		udp.StartUDPServer(cfg.UDP, udpStopChan, p, log)
 	}()
 	wg.Add(1)
 	go func() {
@@ -101,13 +222,12 @@ func main() {
 	}()
 
 	// TCP 服务
-	tcpBasePort := 50053
 	tcpStopChan := make(chan struct{})
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Info(fmt.Sprintf("启动 TCP 服务，基础端口: %d", tcpBasePort))
-		tcp.StartTCPServer(tcpBasePort, tcpStopChan, log)
+		log.Info(fmt.Sprintf("启动 TCP 服务，基础端口: %d", cfg.TCP.Port))
+		tcp.StartTCPServer(cfg.TCP, tcpStopChan, p, log)
 	}()
 	wg.Add(1)
 	go func() {
@@ -118,23 +238,63 @@ func main() {
 		}
 	}()
 
-	// HTTP 服务（用 Gin）
-	httpPort := 9302
-	if port := os.Getenv("HTTP_PORT"); port != "" {
-		if p, err := strconv.Atoi(port); err == nil {
-			httpPort = p
-		}
+	// syslog 服务，每个监听写入配置的 schema / module
+	syslogStopChan := make(chan struct{})
+	for _, l := range cfg.Syslog.Listeners {
+		wg.Add(1)
+		go func(l syslog.Listener) {
+			defer wg.Done()
+			syslog.StartSyslogServer(l, syslogStopChan, p, log)
+		}(l)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		close(syslogStopChan)
+	}()
+
+	// GELF 服务，schema / module 取消息中的 _schema / _module，没有时使用监听配置的默认值
+	gelfStopChan := make(chan struct{})
+	for _, l := range cfg.GELF.Listeners {
+		wg.Add(1)
+		go func(l gelf.Listener) {
+			defer wg.Done()
+			gelf.StartGELFServer(l, gelfStopChan, p, log)
+		}(l)
 	}
-	httpLis, httpPort, err := getAvailablePort(httpPort, "tcp", log)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		close(gelfStopChan)
+	}()
+
+	// HTTP 服务（用 Gin）
+	httpLis, httpPort, err := getAvailablePort(cfg.HTTP.Port, "tcp", log)
 	if err != nil {
 		log.Fatal(fmt.Sprintf("获取 HTTP 端口失败: %v", err))
 	}
 	defer httpLis.Close() // Ensure listener is closed if RunListener fails
-	r := http.SetupRouter(log)
+	httpTLS, err := cfg.HTTP.TLS.Server()
+	if err != nil {
+		log.Fatal(fmt.Sprintf("HTTP TLS 配置加载失败: %v", err))
+	}
+	if httpTLS != nil {
+		httpLis = tls.NewListener(httpLis, httpTLS)
+	}
+	authn, err := auth.New(cfg.Auth)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("认证配置加载失败: %v", err))
+	}
+	if authn == nil {
+		log.Warn("HTTP 接口未启用认证，任何人都可以查询日志和创建 schema")
+	}
+	r := http.SetupRouter(store, p, sp, checker, authn, log)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Info(fmt.Sprintf("HTTP 服务启动，尝试绑定端口: %d", httpPort))
+		log.Info(fmt.Sprintf("HTTP 服务启动，尝试绑定端口: %d，TLS: %v", httpPort, httpTLS != nil))
 		if err := r.RunListener(httpLis); err != nil {
 			log.Error(fmt.Sprintf("HTTP 服务挂了: %v", err))
 		} else {
@@ -168,6 +328,10 @@ func main() {
 		case <-shutdownCtx.Done():
 			log.Error("服务关闭超时，强制退出")
 		}
+		p.Stop()
+		if err := sp.Close(); err != nil {
+			log.Error(fmt.Sprintf("关闭 spool 失败: %v", err))
+		}
 		os.Exit(0)
 	}()
 
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Role 对 schema 的访问级别，数值越大权限越高，高级别包含低级别的权限
+type Role int
+
+const (
+	RoleNone   Role = iota
+	RoleReader      // 查询日志、实时 tail
+	RoleWriter      // 在 reader 基础上推送日志
+	RoleAdmin       // 在 writer 基础上创建 schema、管理 ingest token
+)
+
+// AllSchemas 对所有 schema 生效的角色键
+const AllSchemas = "*"
+
+var roleNames = map[Role]string{RoleReader: "reader", RoleWriter: "writer", RoleAdmin: "admin"}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+// ParseRole 解析角色名
+func ParseRole(s string) (Role, error) {
+	for role, name := range roleNames {
+		if strings.EqualFold(s, name) {
+			return role, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("未知的角色: %s", s)
+}
+
+// ErrInvalidToken token 无法识别或校验失败
+var ErrInvalidToken = errors.New("token 无效")
+
+// Principal 认证后的调用方，Roles 的 key 为 schema 名称或 AllSchemas
+type Principal struct {
+	Subject string
+	Roles   map[string]Role
+}
+
+// Role 返回调用方对 schema 的角色，取 schema 自身和 AllSchemas 中较高的一个
+func (p *Principal) Role(schema string) Role {
+	role := p.Roles[AllSchemas]
+	if r := p.Roles[schema]; schema != "" && r > role {
+		role = r
+	}
+	return role
+}
+
+// Can 调用方对 schema 是否至少拥有 role
+func (p *Principal) Can(schema string, role Role) bool {
+	return p.Role(schema) >= role
+}
+
+// Authenticator 根据 bearer token 识别调用方，无法识别时返回 ErrInvalidToken
+type Authenticator interface {
+	Authenticate(token string) (*Principal, error)
+}
+
+// parseRoles 解析 schema -> 角色名 的映射
+func parseRoles(roles map[string]string) (map[string]Role, error) {
+	parsed := make(map[string]Role, len(roles))
+	for schema, name := range roles {
+		role, err := ParseRole(name)
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %v", schema, err)
+		}
+		parsed[schema] = role
+	}
+	return parsed, nil
+}
+
+// StaticToken 配置文件中的固定 token
+type StaticToken struct {
+	Token   string            `yaml:"token"`
+	Subject string            `yaml:"subject"`
+	Roles   map[string]string `yaml:"roles"` // schema 名称（或 "*"）-> reader / writer / admin
+}
+
+type staticAuthenticator struct {
+	tokens []staticEntry
+}
+
+type staticEntry struct {
+	token     []byte
+	principal *Principal
+}
+
+// NewStatic 创建固定 token 认证
+func NewStatic(tokens []StaticToken) (Authenticator, error) {
+	a := &staticAuthenticator{}
+	for i, t := range tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("auth.tokens[%d] 缺少 token", i)
+		}
+		roles, err := parseRoles(t.Roles)
+		if err != nil {
+			return nil, fmt.Errorf("auth.tokens[%d] %v", i, err)
+		}
+		subject := t.Subject
+		if subject == "" {
+			subject = fmt.Sprintf("token-%d", i)
+		}
+		a.tokens = append(a.tokens, staticEntry{token: []byte(t.Token), principal: &Principal{Subject: subject, Roles: roles}})
+	}
+	return a, nil
+}
+
+func (a *staticAuthenticator) Authenticate(token string) (*Principal, error) {
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(t.token, []byte(token)) == 1 {
+			return t.principal, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+// Chain 依次尝试多个认证方式，返回第一个识别成功的结果
+type Chain []Authenticator
+
+func (c Chain) Authenticate(token string) (*Principal, error) {
+	err := ErrInvalidToken
+	for _, a := range c {
+		p, e := a.Authenticate(token)
+		if e == nil {
+			return p, nil
+		}
+		if !errors.Is(e, ErrInvalidToken) {
+			err = e
+		}
+	}
+	return nil, err
+}
+
+// Config 认证配置，Enabled 为 false 时 HTTP 接口不做认证；
+// ClientCerts 在 Enabled 为 false 时仍用于限制日志推送可写入的 schema
+type Config struct {
+	Enabled     bool          `yaml:"enabled"`
+	Tokens      []StaticToken `yaml:"tokens"`
+	JWT         JWTConfig     `yaml:"jwt"`
+	ClientCerts []ClientCert  `yaml:"client_certs"`
+}
+
+// Validate 校验配置
+func (c Config) Validate() error {
+	if _, err := NewCerts(c.ClientCerts); err != nil {
+		return err
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Tokens) == 0 && c.JWT.JWKSFile == "" && len(c.ClientCerts) == 0 {
+		return fmt.Errorf("auth 已启用但没有配置 tokens、jwt.jwks_file 或 client_certs")
+	}
+	_, err := NewStatic(c.Tokens)
+	return err
+}
+
+// New 按配置创建认证链，未启用时返回 nil
+func New(cfg Config) (Authenticator, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var chain Chain
+	if len(cfg.Tokens) > 0 {
+		static, err := NewStatic(cfg.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, static)
+	}
+	if cfg.JWT.JWKSFile != "" {
+		jwt, err := NewJWT(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, jwt)
+	}
+	certs, err := NewCerts(cfg.ClientCerts)
+	if err != nil {
+		return nil, err
+	}
+	if certs != nil {
+		chain = append(chain, certs.(Authenticator))
+	}
+	return chain, nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+	case *ecdsa.PrivateKey:
+		h := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, h[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("签名失败: %v", err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewJWT(JWTConfig{JWKSFile: path, Issuer: "idp", Audience: "agera"})
+	if err != nil {
+		t.Fatalf("创建 JWT 认证失败: %v", err)
+	}
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "iss": "idp", "aud": []string{"agera"}, "exp": exp, "roles": []string{"shop:writer", "*:reader"}}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		alg, kid string
+		key      crypto.Signer
+	}{
+		{"RS256", "rsa", rsaKey},
+		{"ES256", "ec", ecKey},
+		{"EdDSA", "ed", edKey},
+	} {
+		p, err := a.Authenticate(signJWT(t, tc.alg, tc.kid, tc.key, claims(nil)))
+		if err != nil {
+			t.Fatalf("%s 校验失败: %v", tc.alg, err)
+		}
+		if p.Subject != "alice" || !p.Can("shop", RoleWriter) || p.Can("shop", RoleAdmin) || !p.Can("pay", RoleReader) || p.Can("pay", RoleWriter) {
+			t.Errorf("%s 角色不符合预期: %+v", tc.alg, p)
+		}
+	}
+
+	objectRoles, err := a.Authenticate(signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]any{"roles": map[string]string{"pay": "admin"}})))
+	if err != nil || !objectRoles.Can("pay", RoleAdmin) || objectRoles.Can("shop", RoleReader) {
+		t.Errorf("对象形式的角色解析不符合预期: %+v, %v", objectRoles, err)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for name, token := range map[string]string{
+		"签名不匹配":   signJWT(t, "RS256", "rsa", otherKey, claims(nil)),
+		"算法与公钥不符": signJWT(t, "ES256", "rsa", ecKey, claims(nil)),
+		"已过期":     signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]any{"exp": float64(time.Now().Add(-time.Hour).Unix())})),
+		"iss 不匹配": signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]any{"iss": "other"})),
+		"aud 不匹配": signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]any{"aud": "other"})),
+		"格式有误":    "not-a-jwt",
+	} {
+		if _, err := a.Authenticate(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s 应被拒绝，实际 %v", name, err)
+		}
+	}
+}
+
+func TestStaticAndChain(t *testing.T) {
+	static, err := NewStatic([]StaticToken{{Token: "secret", Subject: "ops", Roles: map[string]string{"*": "admin"}}})
+	if err != nil {
+		t.Fatalf("创建固定 token 认证失败: %v", err)
+	}
+	chain := Chain{static}
+	p, err := chain.Authenticate("secret")
+	if err != nil || p.Subject != "ops" || !p.Can("anything", RoleAdmin) {
+		t.Errorf("固定 token 认证不符合预期: %+v, %v", p, err)
+	}
+	if _, err := chain.Authenticate("wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("错误的 token 应返回 ErrInvalidToken，实际 %v", err)
+	}
+	if _, err := NewStatic([]StaticToken{{Token: "x", Roles: map[string]string{"shop": "owner"}}}); err == nil {
+		t.Error("未知角色应报错")
+	}
+}
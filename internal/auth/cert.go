@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownIdentity 客户端证书的身份没有配置角色
+var ErrUnknownIdentity = errors.New("客户端证书身份未授权")
+
+// ClientCert 按 TLS 客户端证书授权，Identity 为证书的 CN（没有 CN 时为第一个 SAN）
+type ClientCert struct {
+	Identity string            `yaml:"identity"`
+	Roles    map[string]string `yaml:"roles"` // schema 名称（或 "*"）-> reader / writer / admin
+}
+
+// CertAuthenticator 根据已校验的客户端证书身份识别调用方，未配置的身份返回 ErrUnknownIdentity
+type CertAuthenticator interface {
+	AuthenticateCert(identity string) (*Principal, error)
+}
+
+// certAuthenticator 同时实现 Authenticator，便于和其他认证方式一起放入 Chain
+type certAuthenticator struct {
+	principals map[string]*Principal
+}
+
+// NewCerts 创建客户端证书授权，没有配置时返回 nil
+func NewCerts(certs []ClientCert) (CertAuthenticator, error) {
+	if len(certs) == 0 {
+		return nil, nil
+	}
+	a := &certAuthenticator{principals: make(map[string]*Principal, len(certs))}
+	for i, c := range certs {
+		if c.Identity == "" {
+			return nil, fmt.Errorf("auth.client_certs[%d] 缺少 identity", i)
+		}
+		if _, ok := a.principals[c.Identity]; ok {
+			return nil, fmt.Errorf("auth.client_certs[%d] identity %s 重复", i, c.Identity)
+		}
+		roles, err := parseRoles(c.Roles)
+		if err != nil {
+			return nil, fmt.Errorf("auth.client_certs[%d] %v", i, err)
+		}
+		a.principals[c.Identity] = &Principal{Subject: "cert:" + c.Identity, Roles: roles}
+	}
+	return a, nil
+}
+
+// Authenticate 客户端证书授权不识别 bearer token，放在 Chain 中时由其他认证方式处理 token
+func (a *certAuthenticator) Authenticate(string) (*Principal, error) {
+	return nil, ErrInvalidToken
+}
+
+func (a *certAuthenticator) AuthenticateCert(identity string) (*Principal, error) {
+	if p, ok := a.principals[identity]; ok && identity != "" {
+		return p, nil
+	}
+	return nil, ErrUnknownIdentity
+}
+
+// AuthenticateCert 依次尝试链中支持客户端证书的认证方式
+func (c Chain) AuthenticateCert(identity string) (*Principal, error) {
+	for _, a := range c {
+		if ca, ok := a.(CertAuthenticator); ok {
+			if p, err := ca.AuthenticateCert(identity); err == nil {
+				return p, nil
+			}
+		}
+	}
+	return nil, ErrUnknownIdentity
+}
@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksCheckInterval 检查 JWKS 文件是否更新的最短间隔，文件修改后自动重新加载以支持密钥轮换
+const jwksCheckInterval = 5 * time.Second
+
+// JWTConfig JWT 校验配置，签名公钥从本地 JWKS 文件读取
+type JWTConfig struct {
+	JWKSFile     string        `yaml:"jwks_file"`
+	Issuer       string        `yaml:"issuer"`        // 非空时校验 iss
+	Audience     string        `yaml:"audience"`      // 非空时校验 aud
+	RolesClaim   string        `yaml:"roles_claim"`   // 角色所在的 claim，默认 roles
+	SubjectClaim string        `yaml:"subject_claim"` // 调用方标识所在的 claim，默认 sub
+	Leeway       time.Duration `yaml:"leeway"`        // exp / nbf 允许的时钟偏差
+}
+
+// JWTAuthenticator 校验 RS256/384/512、ES256/384/512 和 EdDSA 签名的 JWT。
+// 角色 claim 可以是 {"schema": "role"} 对象，也可以是 ["schema:role", ...] 数组，schema 为 "*" 时对所有 schema 生效。
+type JWTAuthenticator struct {
+	cfg JWTConfig
+
+	mu      sync.RWMutex
+	keys    map[string]crypto.PublicKey // kid -> 公钥
+	modTime time.Time
+	checked time.Time
+}
+
+// NewJWT 读取 JWKS 文件并创建 JWT 认证
+func NewJWT(cfg JWTConfig) (*JWTAuthenticator, error) {
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.SubjectClaim == "" {
+		cfg.SubjectClaim = "sub"
+	}
+	a := &JWTAuthenticator{cfg: cfg}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *JWTAuthenticator) load() error {
+	info, err := os.Stat(a.cfg.JWKSFile)
+	if err != nil {
+		return fmt.Errorf("读取 JWKS 文件失败: %v", err)
+	}
+	data, err := os.ReadFile(a.cfg.JWKSFile)
+	if err != nil {
+		return fmt.Errorf("读取 JWKS 文件失败: %v", err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.keys, a.modTime, a.checked = keys, info.ModTime(), time.Now()
+	a.mu.Unlock()
+	return nil
+}
+
+// refresh JWKS 文件修改后重新加载，加载失败时继续使用旧的密钥
+func (a *JWTAuthenticator) refresh() {
+	a.mu.RLock()
+	due := time.Since(a.checked) >= jwksCheckInterval
+	modTime := a.modTime
+	a.mu.RUnlock()
+	if !due {
+		return
+	}
+	a.mu.Lock()
+	a.checked = time.Now()
+	a.mu.Unlock()
+	if info, err := os.Stat(a.cfg.JWKSFile); err == nil && !info.ModTime().Equal(modTime) {
+		a.load()
+	}
+}
+
+// ParseJWKS 解析 JWKS，返回 kid -> 公钥
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("JWKS 格式有误: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		switch k.Kty {
+		case "RSA":
+			n, err1 := decodeBigInt(k.N)
+			e, err2 := decodeBigInt(k.E)
+			if err1 != nil || err2 != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("JWKS 第 %d 个 RSA 公钥格式有误", i)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("JWKS 第 %d 个公钥的曲线不支持: %s", i, k.Crv)
+			}
+			x, err1 := decodeBigInt(k.X)
+			y, err2 := decodeBigInt(k.Y)
+			if err1 != nil || err2 != nil || !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("JWKS 第 %d 个 EC 公钥格式有误", i)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "OKP":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("JWKS 第 %d 个 OKP 公钥格式有误", i)
+			}
+			key = ed25519.PublicKey(x)
+		default:
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS 中没有可用的签名公钥")
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("无效的整数: %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (a *JWTAuthenticator) Authenticate(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	a.refresh()
+	a.mu.RLock()
+	var candidates []crypto.PublicKey
+	if key, ok := a.keys[header.Kid]; ok {
+		candidates = append(candidates, key)
+	} else if header.Kid == "" {
+		for _, key := range a.keys {
+			candidates = append(candidates, key)
+		}
+	}
+	a.mu.RUnlock()
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range candidates {
+		if verifySignature(header.Alg, key, signed, signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: 签名校验失败", ErrInvalidToken)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims 格式有误", ErrInvalidToken)
+	}
+	if err := a.validateClaims(claims, time.Now()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	roles, err := parseRolesClaim(claims[a.cfg.RolesClaim])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	subject, _ := claims[a.cfg.SubjectClaim].(string)
+	return &Principal{Subject: subject, Roles: roles}, nil
+}
+
+func decodeSegment(s string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature 按 alg 校验签名，alg 与公钥类型不匹配时失败，防止算法混淆
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return fmt.Errorf("不支持的签名算法: %s", alg)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("算法 %s 与 RSA 公钥不匹配", alg)
+		}
+		h := hash.New()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(k, hash, h.Sum(nil), signature)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("算法 %s 与 EC 公钥不匹配", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("ECDSA 签名长度有误")
+		}
+		h := hash.New()
+		h.Write(signed)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, h.Sum(nil), r, s) {
+			return fmt.Errorf("ECDSA 签名无效")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("算法 %s 与 Ed25519 公钥不匹配", alg)
+		}
+		if !ed25519.Verify(k, signed, signature) {
+			return fmt.Errorf("Ed25519 签名无效")
+		}
+		return nil
+	}
+	return fmt.Errorf("不支持的公钥类型 %T", key)
+}
+
+func (a *JWTAuthenticator) validateClaims(claims map[string]any, now time.Time) error {
+	leeway := a.cfg.Leeway
+	if exp, ok := claims["exp"].(float64); !ok {
+		return fmt.Errorf("缺少 exp")
+	} else if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return fmt.Errorf("token 已过期")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token 尚未生效")
+	}
+	if a.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.cfg.Issuer {
+			return fmt.Errorf("iss 不匹配: %s", iss)
+		}
+	}
+	if a.cfg.Audience != "" && !hasAudience(claims["aud"], a.cfg.Audience) {
+		return fmt.Errorf("aud 不匹配")
+	}
+	return nil
+}
+
+func hasAudience(aud any, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []any:
+		for _, item := range v {
+			if s, _ := item.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseRolesClaim 解析 {"schema": "role"} 或 ["schema:role", ...]
+func parseRolesClaim(claim any) (map[string]Role, error) {
+	roles := make(map[string]string)
+	switch v := claim.(type) {
+	case nil:
+	case map[string]any:
+		for schema, role := range v {
+			name, ok := role.(string)
+			if !ok {
+				return nil, fmt.Errorf("schema %s 的角色不是字符串", schema)
+			}
+			roles[schema] = name
+		}
+	case []any:
+		for _, item := range v {
+			s, _ := item.(string)
+			schema, name, ok := strings.Cut(s, ":")
+			if !ok {
+				return nil, fmt.Errorf("角色格式应为 schema:role: %v", item)
+			}
+			// 同一 schema 出现多次时取最高的角色
+			if prev, err := ParseRole(roles[schema]); err == nil {
+				if next, err := ParseRole(name); err == nil && next < prev {
+					continue
+				}
+			}
+			roles[schema] = name
+		}
+	default:
+		return nil, fmt.Errorf("角色 claim 格式有误")
+	}
+	return parseRoles(roles)
+}
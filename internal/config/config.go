@@ -0,0 +1,297 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/auth"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/gelf"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/spool"
+	"github.com/vkeeps/agera-logs/internal/syslog"
+	"github.com/vkeeps/agera-logs/internal/tcp"
+	"github.com/vkeeps/agera-logs/internal/tlsconfig"
+	"github.com/vkeeps/agera-logs/internal/udp"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath 未指定配置文件时尝试加载的路径，文件不存在则只使用默认值和环境变量
+const DefaultPath = "config.yaml"
+
+// Config 服务配置，来源优先级：环境变量 > 配置文件 > 默认值
+type Config struct {
+	Log        LogConfig           `yaml:"log"`
+	ClickHouse db.ClickHouseConfig `yaml:"clickhouse"`
+	Bolt       BoltConfig          `yaml:"bolt"`
+	Pipeline   pipeline.Config     `yaml:"pipeline"`
+	Spool      spool.Options       `yaml:"spool"`
+	GRPC       GRPCConfig          `yaml:"grpc"`
+	HTTP       HTTPConfig          `yaml:"http"`
+	TCP        tcp.Config          `yaml:"tcp"`
+	UDP        udp.Config          `yaml:"udp"`
+	Syslog     SyslogConfig        `yaml:"syslog"`
+	GELF       GELFConfig          `yaml:"gelf"`
+	Auth       auth.Config         `yaml:"auth"`
+}
+
+// LogConfig 服务自身日志配置，Level 可热加载
+type LogConfig struct {
+	Level string `yaml:"level"`
+	File  string `yaml:"file"`
+}
+
+// BoltConfig BoltDB 配置
+type BoltConfig struct {
+	Path string `yaml:"path"`
+}
+
+// GRPCConfig gRPC 服务配置
+type GRPCConfig struct {
+	Port int              `yaml:"port"`
+	TLS  tlsconfig.Config `yaml:"tls"`
+}
+
+// HTTPConfig HTTP 服务配置
+type HTTPConfig struct {
+	Port int              `yaml:"port"`
+	TLS  tlsconfig.Config `yaml:"tls"`
+}
+
+// SyslogConfig syslog 服务配置，没有监听时不启动
+type SyslogConfig struct {
+	Listeners []syslog.Listener `yaml:"listeners"`
+}
+
+// GELFConfig GELF 服务配置，没有监听时不启动
+type GELFConfig struct {
+	Listeners []gelf.Listener `yaml:"listeners"`
+}
+
+// Default 默认配置
+func Default() *Config {
+	return &Config{
+		Log:        LogConfig{Level: "info", File: "agera.log"},
+		ClickHouse: db.DefaultClickHouseConfig(),
+		Bolt:       BoltConfig{Path: db.DefaultBoltPath},
+		Pipeline:   pipeline.DefaultConfig(),
+		Spool:      spool.DefaultOptions(),
+		GRPC:       GRPCConfig{Port: 50051},
+		HTTP:       HTTPConfig{Port: 9302},
+		TCP:        tcp.DefaultConfig(),
+		UDP:        udp.DefaultConfig(),
+	}
+}
+
+// Load 读取配置文件（path 为空时尝试 DefaultPath），叠加环境变量后校验
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		if _, err := os.Stat(DefaultPath); err == nil {
+			path = DefaultPath
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件 %s 失败: %v", path, err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv 用环境变量覆盖配置，变量名与旧版本保持一致
+func (c *Config) applyEnv() error {
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*dst = v
+		}
+	}
+	var errs []error
+	num := func(name string, set func(v string) error) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			if err := set(v); err != nil {
+				errs = append(errs, fmt.Errorf("环境变量 %s=%s 无效: %v", name, v, err))
+			}
+		}
+	}
+	intVar := func(dst *int) func(string) error {
+		return func(v string) (err error) { *dst, err = strconv.Atoi(v); return }
+	}
+	int64Var := func(dst *int64) func(string) error {
+		return func(v string) (err error) { *dst, err = strconv.ParseInt(v, 10, 64); return }
+	}
+	floatVar := func(dst *float64) func(string) error {
+		return func(v string) (err error) { *dst, err = strconv.ParseFloat(v, 64); return }
+	}
+	boolVar := func(dst *bool) func(string) error {
+		return func(v string) (err error) { *dst, err = strconv.ParseBool(v); return }
+	}
+	durationVar := func(dst ...*time.Duration) func(string) error {
+		return func(v string) error {
+			d, err := time.ParseDuration(v)
+			for _, p := range dst {
+				*p = d
+			}
+			return err
+		}
+	}
+
+	str("LOG_LEVEL", &c.Log.Level)
+	str("CLICKHOUSE_ADDR", &c.ClickHouse.Addr)
+	str("CLICKHOUSE_USER", &c.ClickHouse.User)
+	str("CLICKHOUSE_PASS", &c.ClickHouse.Password)
+	str("CLICKHOUSE_DB", &c.ClickHouse.Database)
+	str("CLICKHOUSE_PARTITION", &c.ClickHouse.Partition)
+	str("BOLT_PATH", &c.Bolt.Path)
+
+	num("BATCH_SIZE", intVar(&c.Pipeline.BatchSize))
+	num("BATCH_TIMEOUT", durationVar(&c.Pipeline.BatchTimeout))
+	num("BUFFER_CAPACITY", intVar(&c.Pipeline.BufferCapacity))
+	num("RATE_LIMIT", floatVar(&c.Pipeline.RateLimit))
+	num("RATE_BURST", intVar(&c.Pipeline.RateBurst))
+	num("REQUIRE_API_KEY", boolVar(&c.Pipeline.RequireAPIKey))
+
+	str("SPOOL_DIR", &c.Spool.Dir)
+	num("SPOOL_MAX_BYTES", int64Var(&c.Spool.MaxBytes))
+	num("SPOOL_SEGMENT_BYTES", int64Var(&c.Spool.SegmentBytes))
+	if v := os.Getenv("SPOOL_FSYNC"); v != "" {
+		c.Spool.Sync = spool.SyncPolicy(v)
+	}
+	num("SPOOL_FSYNC_INTERVAL", durationVar(&c.Spool.SyncInterval))
+	num("SPOOL_RETRY_INTERVAL", durationVar(&c.Spool.RetryInterval))
+	num("SPOOL_MAX_RETRIES", intVar(&c.Spool.MaxRetries))
+
+	num("HTTP_PORT", intVar(&c.HTTP.Port))
+	num("READ_TIMEOUT", durationVar(&c.TCP.ReadTimeout, &c.UDP.ReadTimeout))
+
+	if v := os.Getenv("SYSLOG_LISTENERS"); v != "" {
+		listeners, err := syslog.ParseListeners(v)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		c.Syslog.Listeners = listeners
+	}
+	return errors.Join(errs...)
+}
+
+// Validate 校验配置
+func (c *Config) Validate() error {
+	var errs []error
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level 无效: %s", c.Log.Level))
+	}
+	if c.ClickHouse.Addr == "" {
+		errs = append(errs, fmt.Errorf("clickhouse.addr 不能为空"))
+	}
+	if err := db.ValidatePartition(c.ClickHouse.Partition); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Bolt.Path == "" {
+		errs = append(errs, fmt.Errorf("bolt.path 不能为空"))
+	}
+	for name, port := range map[string]int{
+		"grpc.port":    c.GRPC.Port,
+		"http.port":    c.HTTP.Port,
+		"tcp.port":     c.TCP.Port,
+		"udp.port":     c.UDP.Port,
+		"udp.ack_port": c.UDP.AckPort,
+	} {
+		if port <= 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s 超出范围: %d", name, port))
+		}
+	}
+	p := c.Pipeline
+	if p.BatchSize <= 0 || p.BufferCapacity <= 0 || p.BatchTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("pipeline.batch_size、batch_timeout、buffer_capacity 必须大于 0"))
+	} else if p.BatchSize > p.BufferCapacity {
+		errs = append(errs, fmt.Errorf("pipeline.batch_size (%d) 不能大于 buffer_capacity (%d)", p.BatchSize, p.BufferCapacity))
+	}
+	if p.RateLimit < 0 || p.RateBurst < 0 {
+		errs = append(errs, fmt.Errorf("pipeline.rate_limit 和 rate_burst 不能为负数"))
+	}
+	if p.MaxFutureSkew < 0 || p.MaxPastSkew < 0 {
+		errs = append(errs, fmt.Errorf("pipeline.max_future_skew 和 max_past_skew 不能为负数"))
+	}
+	if p.SkewPolicy != "" && p.SkewPolicy != pipeline.SkewClamp && p.SkewPolicy != pipeline.SkewReject {
+		errs = append(errs, fmt.Errorf("pipeline.skew_policy 只能是 %s 或 %s: %s", pipeline.SkewClamp, pipeline.SkewReject, p.SkewPolicy))
+	}
+	for name, t := range map[string]tlsconfig.Config{
+		"grpc.tls": c.GRPC.TLS,
+		"http.tls": c.HTTP.TLS,
+	} {
+		if err := t.Validate(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.UDP.AckWindow < 0 || c.UDP.ClientTTL < 0 || c.UDP.MaxClients < 0 {
+		errs = append(errs, fmt.Errorf("udp.ack_window、client_ttl 和 max_clients 不能为负数"))
+	}
+	if c.UDP.MaxMessageSize < 0 || c.UDP.ChunkTimeout < 0 || c.UDP.MaxPendingBytes < 0 {
+		errs = append(errs, fmt.Errorf("udp.max_message_size、chunk_timeout 和 max_pending_bytes 不能为负数"))
+	}
+	if err := c.TCP.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Spool.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, l := range c.Syslog.Listeners {
+		if err := l.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, l := range c.GELF.Listeners {
+		if err := l.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.Auth.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// RestartRequired 返回 next 中修改了但不支持热加载的配置项，热加载只应用日志级别和 pipeline
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	for _, f := range []struct {
+		name      string
+		cur, next any
+	}{
+		{"log.file", c.Log.File, next.Log.File},
+		{"clickhouse", c.ClickHouse, next.ClickHouse},
+		{"bolt", c.Bolt, next.Bolt},
+		{"spool", c.Spool, next.Spool},
+		{"grpc", c.GRPC, next.GRPC},
+		{"http", c.HTTP, next.HTTP},
+		{"tcp", c.TCP, next.TCP},
+		{"udp", c.UDP, next.UDP},
+		{"syslog", c.Syslog, next.Syslog},
+		{"gelf", c.GELF, next.GELF},
+		{"auth", c.Auth, next.Auth},
+	} {
+		if !reflect.DeepEqual(f.cur, f.next) {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
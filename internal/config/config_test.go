@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	return path
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	path := writeConfig(t, `
+log:
+  level: warn
+pipeline:
+  batch_size: 50
+  batch_timeout: 20ms
+tcp:
+  port: 6000
+syslog:
+  listeners:
+    - network: udp
+      addr: ":5514"
+      schema: infra
+      module: network
+`)
+	t.Setenv("CLICKHOUSE_PASS", "secret")
+	t.Setenv("BATCH_SIZE", "80")
+	t.Setenv("READ_TIMEOUT", "3s")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.Log.Level != "warn" || cfg.TCP.Port != 6000 || cfg.Pipeline.BatchTimeout != 20*time.Millisecond {
+		t.Errorf("配置文件中的值未生效: %+v", cfg)
+	}
+	if cfg.ClickHouse.Password != "secret" || cfg.Pipeline.BatchSize != 80 {
+		t.Errorf("环境变量未覆盖配置文件: %+v", cfg)
+	}
+	if cfg.TCP.ReadTimeout != 3*time.Second || cfg.UDP.ReadTimeout != 3*time.Second {
+		t.Errorf("READ_TIMEOUT 应同时作用于 TCP 和 UDP: %v %v", cfg.TCP.ReadTimeout, cfg.UDP.ReadTimeout)
+	}
+	if cfg.HTTP.Port != 9302 || cfg.UDP.AckPort != 50054 || !cfg.Pipeline.RequireAPIKey {
+		t.Errorf("未配置的项应使用默认值: %+v", cfg)
+	}
+	if len(cfg.Syslog.Listeners) != 1 || cfg.Syslog.Listeners[0].Module != "network" {
+		t.Errorf("syslog 监听未生效: %+v", cfg.Syslog)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		env     map[string]string
+		want    string
+	}{
+		"未知字段":   {content: "pipeline:\n  batchsize: 10\n", want: "batchsize"},
+		"日志级别":   {content: "log:\n  level: loud\n", want: "log.level"},
+		"端口范围":   {content: "grpc:\n  port: 70000\n", want: "grpc.port"},
+		"批量大小":   {content: "pipeline:\n  batch_size: 1000\n  buffer_capacity: 10\n", want: "batch_size"},
+		"落盘策略":   {content: "spool:\n  fsync: sometimes\n", want: "落盘策略"},
+		"syslog": {content: "syslog:\n  listeners:\n    - network: http\n      addr: \":1\"\n", want: "syslog"},
+		"gelf":   {content: "gelf:\n  listeners:\n    - network: amqp\n      addr: \":1\"\n", want: "GELF"},
+		"环境变量格式": {env: map[string]string{"BATCH_TIMEOUT": "soon"}, want: "BATCH_TIMEOUT"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(writeConfig(t, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("预期错误包含 %q，实际 %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	cur := Default()
+	next := Default()
+	next.Log.Level = "debug"
+	next.Pipeline.BatchSize = 100
+	if changed := cur.RestartRequired(next); len(changed) != 0 {
+		t.Errorf("日志级别和 pipeline 应支持热加载，实际 %v", changed)
+	}
+	next.HTTP.Port = 9000
+	next.ClickHouse.Password = "changed"
+	if changed := cur.RestartRequired(next); !reflect.DeepEqual(changed, []string{"clickhouse", "http"}) {
+		t.Errorf("需要重启的配置项不符合预期: %v", changed)
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyPrefix ingest token 的固定前缀，便于在日志和配置中识别
+const APIKeyPrefix = "agk_"
+
+// apiKeysBucket BoltDB 中保存 ingest token 的桶，key 为 token 的 SHA-256，value 为 APIKey 的 JSON
+var apiKeysBucket = []byte("api_keys")
+
+// ErrAPIKeyNotFound token 不存在或不属于指定 schema
+var ErrAPIKeyNotFound = errors.New("api key 不存在")
+
+// APIKey schema 的 ingest token，只保存 token 的哈希，明文只在创建时返回一次
+type APIKey struct {
+	ID         string     `json:"id"`
+	Schema     string     `json:"schema"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // token 开头几位，便于区分同一 schema 的多个 token
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active token 未被吊销
+func (k *APIKey) Active() bool {
+	return k.RevokedAt == nil
+}
+
+// HashAPIKey 计算 token 的 SHA-256，存储和查找都使用哈希
+func HashAPIKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// newAPIKey 生成随机 token 及其元数据
+func newAPIKey(schemaName, name string) (*APIKey, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("生成 token 失败: %v", err)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", fmt.Errorf("生成 token ID 失败: %v", err)
+	}
+	token := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return &APIKey{
+		ID:        hex.EncodeToString(id),
+		Schema:    schemaName,
+		Name:      name,
+		Prefix:    token[:len(APIKeyPrefix)+6],
+		CreatedAt: time.Now().UTC(),
+	}, token, nil
+}
+
+func sortAPIKeys(keys []APIKey) {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+}
+
+// CreateAPIKey 为已注册的 schema 生成新的 token，同一 schema 可同时存在多个有效 token 以便轮换
+func CreateAPIKey(schemaName, name string, log *logrus.Logger) (APIKey, string, error) {
+	schemaID, err := GetSchemaIDByName(schemaName, log)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if schemaID == "" {
+		return APIKey{}, "", fmt.Errorf("schema %s 未注册", schemaName)
+	}
+	key, token, err := newAPIKey(schemaName, name)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	err = BoltDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(apiKeysBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(HashAPIKey(token)), data)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("保存 schema %s 的 api key 失败: %v", schemaName, err))
+		return APIKey{}, "", fmt.Errorf("保存 api key 失败: %v", err)
+	}
+	log.Info(fmt.Sprintf("为 schema %s 创建了 api key %s (%s)", schemaName, key.ID, key.Name))
+	return *key, token, nil
+}
+
+// ListAPIKeys 列出 schema 的所有 token，包括已吊销的，按创建时间排序
+func ListAPIKeys(schemaName string, log *logrus.Logger) ([]APIKey, error) {
+	var keys []APIKey
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(apiKeysBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var key APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			if key.Schema == schemaName {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取 api key 失败: %v", err)
+	}
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+// RevokeAPIKey 吊销 schema 下的 token，已吊销的 token 保留记录以便审计
+func RevokeAPIKey(schemaName, id string, log *logrus.Logger) error {
+	err := BoltDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(apiKeysBucket)
+		if b == nil {
+			return ErrAPIKeyNotFound
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var key APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			if key.ID != id || key.Schema != schemaName {
+				continue
+			}
+			if key.RevokedAt == nil {
+				now := time.Now().UTC()
+				key.RevokedAt = &now
+			}
+			data, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			return b.Put(k, data)
+		}
+		return ErrAPIKeyNotFound
+	})
+	if err == nil {
+		log.Info(fmt.Sprintf("已吊销 schema %s 的 api key %s", schemaName, id))
+	}
+	return err
+}
+
+// LookupAPIKey 根据 token 的哈希查找，不存在时返回 nil
+func LookupAPIKey(hash string, log *logrus.Logger) (*APIKey, error) {
+	var key *APIKey
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(apiKeysBucket)
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(hash))
+		if v == nil {
+			return nil
+		}
+		key = &APIKey{}
+		return json.Unmarshal(v, key)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取 api key 失败: %v", err)
+	}
+	return key, nil
+}
+
+// TouchAPIKeys 批量更新最后使用时间，key 为 token 的哈希
+func TouchAPIKeys(lastUsed map[string]time.Time, log *logrus.Logger) error {
+	return BoltDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(apiKeysBucket)
+		if b == nil {
+			return nil
+		}
+		for hash, t := range lastUsed {
+			v := b.Get([]byte(hash))
+			if v == nil {
+				continue
+			}
+			var key APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			if key.LastUsedAt != nil && !t.After(*key.LastUsedAt) {
+				continue
+			}
+			t := t.UTC()
+			key.LastUsedAt = &t
+			data, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(hash), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// 单条日志 attributes 的限制
+const (
+	MaxAttributes           = 64
+	MaxAttributeKeyLength   = 128
+	MaxAttributeValueLength = 8192
+)
+
+// 分组统计默认和最多返回的分组数
+const (
+	DefaultGroupLimit = 100
+	MaxGroupLimit     = 10000
+)
+
+// attributeFieldPrefix 分组字段以此开头时表示按 attributes 的键分组
+const attributeFieldPrefix = "attributes."
+
+// attributeKeyPattern attributes 键允许的格式
+var attributeKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+
+// ErrInvalidAttributes attributes 不合法，可用 errors.Is 判断
+var ErrInvalidAttributes = errors.New("attributes 不合法")
+
+func attributeError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAttributes, fmt.Sprintf(format, args...))
+}
+
+// ValidateAttributeKey 校验 attributes 的键，查询和分组时同样适用
+func ValidateAttributeKey(key string) error {
+	switch {
+	case key == "":
+		return attributeError("键不能为空")
+	case len(key) > MaxAttributeKeyLength:
+		return attributeError("键 %q 长度不能超过 %d", key, MaxAttributeKeyLength)
+	case !attributeKeyPattern.MatchString(key):
+		return attributeError("键 %q 只能包含字母、数字、下划线、点和中划线，且以字母或下划线开头", key)
+	}
+	return nil
+}
+
+// ValidateAttributes 校验一条日志的 attributes
+func ValidateAttributes(attrs map[string]string) error {
+	if len(attrs) > MaxAttributes {
+		return attributeError("数量不能超过 %d，实际 %d", MaxAttributes, len(attrs))
+	}
+	for key, value := range attrs {
+		if err := ValidateAttributeKey(key); err != nil {
+			return err
+		}
+		if len(value) > MaxAttributeValueLength {
+			return attributeError("%s 的值长度不能超过 %d", key, MaxAttributeValueLength)
+		}
+	}
+	return nil
+}
+
+// groupColumns 可用于分组统计的列，attributes 的键通过 attributes.<key> 指定
+var groupColumns = map[string]bool{
+	"service":          true,
+	"log_level":        true,
+	"operator_id":      true,
+	"operator_company": true,
+	"operator_project": true,
+	"push_type":        true,
+	"client_identity":  true,
+}
+
+// GroupCount 分组统计的一项
+type GroupCount struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// ParseGroupBy 校验分组字段，返回列名或 attributes 的键（isAttribute 为 true）
+func ParseGroupBy(by string) (field string, isAttribute bool, err error) {
+	if key, ok := strings.CutPrefix(by, attributeFieldPrefix); ok {
+		if err := ValidateAttributeKey(key); err != nil {
+			return "", false, err
+		}
+		return key, true, nil
+	}
+	if !groupColumns[by] {
+		return "", false, fmt.Errorf("不支持按 %q 分组，可选 service、log_level、operator_id、operator_company、operator_project、push_type、client_identity 或 attributes.<key>", by)
+	}
+	return by, false, nil
+}
+
+// groupExpr 分组字段对应的 SQL 表达式和参数
+func groupExpr(by string) (string, []any, error) {
+	field, isAttribute, err := ParseGroupBy(by)
+	if err != nil {
+		return "", nil, err
+	}
+	if isAttribute {
+		return "attributes[?]", []any{field}, nil
+	}
+	return field, nil, nil
+}
+
+// groupValue 从记录中取分组字段的值，第二个返回值为 false 表示记录没有该 attributes 键，语义与 groupQuery 一致
+func groupValue(r model.LogRecord, by string) (string, bool) {
+	if key, ok := strings.CutPrefix(by, attributeFieldPrefix); ok {
+		value, ok := r.Attributes[key]
+		return value, ok
+	}
+	switch by {
+	case "service":
+		return r.Service, true
+	case "log_level":
+		return r.LogLevel, true
+	case "operator_id":
+		return r.OperatorID, true
+	case "operator_company":
+		return r.OperatorCompany, true
+	case "operator_project":
+		return r.OperatorProject, true
+	case "push_type":
+		return r.PushType, true
+	case "client_identity":
+		return r.ClientIdentity, true
+	}
+	return "", false
+}
+
+// sortGroups 按数量倒序、值正序排列并截取前 limit 项
+func sortGroups(counts map[string]uint64, limit int) []GroupCount {
+	groups := make([]GroupCount, 0, len(counts))
+	for value, n := range counts {
+		groups = append(groups, GroupCount{Value: value, Count: n})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Value < groups[j].Value
+	})
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+	return groups
+}
+
+func groupLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultGroupLimit
+	}
+	if limit > MaxGroupLimit {
+		return MaxGroupLimit
+	}
+	return limit
+}
+
+// groupQuery 生成分组统计语句，多个模块时用 UNION ALL 合并后再分组；
+// 按 attributes 分组时只统计带有该键的日志
+func groupQuery(schemaName string, modules []string, q LogQuery, by string, limit int) (string, []any, error) {
+	expr, exprArgs, err := groupExpr(by)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(exprArgs) > 0 {
+		q.HasAttributes = append(append([]string(nil), q.HasAttributes...), exprArgs[0].(string))
+	}
+	where, whereArgs := q.whereClause()
+	if where != "" {
+		where = " WHERE " + where
+	}
+	parts := make([]string, len(modules))
+	var args []any
+	for i, module := range modules {
+		parts[i] = fmt.Sprintf("SELECT %s AS value FROM %s%s", expr, tableIdent(schemaName, module), where)
+		args = append(args, exprArgs...)
+		args = append(args, whereArgs...)
+	}
+	query := fmt.Sprintf("SELECT value, count() AS count FROM (%s) GROUP BY value ORDER BY count DESC, value LIMIT %d",
+		strings.Join(parts, " UNION ALL "), groupLimit(limit))
+	return query, args, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAttributeQueries(t *testing.T) {
+	q := LogQuery{Attributes: map[string]string{"region": "eu", "env": "prod"}, HasAttributes: []string{"request_id"}}
+	where, args := q.whereClause()
+	if want := "attributes[?] = ? AND attributes[?] = ? AND mapContains(attributes, ?)"; where != want {
+		t.Errorf("WHERE 子句不符合预期: %s", where)
+	}
+	if want := []any{"env", "prod", "region", "eu", "request_id"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("参数不符合预期: %v", args)
+	}
+
+	query, args, err := groupQuery("shop", []string{"order", "pay"}, LogQuery{Service: "svc"}, "attributes.region", 10)
+	if err != nil {
+		t.Fatalf("生成分组语句失败: %v", err)
+	}
+	for _, part := range []string{
+		"SELECT attributes[?] AS value FROM `shop`.`log_shop_order` WHERE service = ? AND mapContains(attributes, ?)",
+		"GROUP BY value ORDER BY count DESC, value LIMIT 10",
+	} {
+		if !strings.Contains(query, part) {
+			t.Errorf("分组语句缺少 %q: %s", part, query)
+		}
+	}
+	if want := []any{"region", "svc", "region", "region", "svc", "region"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("分组参数不符合预期: %v", args)
+	}
+	if _, _, err := groupQuery("shop", []string{"order"}, LogQuery{}, "output", 10); err == nil {
+		t.Errorf("不应允许按 output 分组")
+	}
+}
+
+func TestValidateAttributes(t *testing.T) {
+	if err := ValidateAttributes(map[string]string{"request_id": "r1", "http.status-code": "200", "_tag": ""}); err != nil {
+		t.Errorf("合法的 attributes 校验失败: %v", err)
+	}
+	too := make(map[string]string)
+	for i := 0; i <= MaxAttributes; i++ {
+		too[strings.Repeat("k", i+1)] = "v"
+	}
+	for _, attrs := range []map[string]string{
+		{"": "v"},
+		{"a b": "v"},
+		{"1abc": "v"},
+		{"k": strings.Repeat("v", MaxAttributeValueLength+1)},
+		too,
+	} {
+		if err := ValidateAttributes(attrs); !errors.Is(err, ErrInvalidAttributes) {
+			t.Errorf("非法的 attributes 应返回 ErrInvalidAttributes，实际 %v", err)
+		}
+	}
+}
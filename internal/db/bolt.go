@@ -10,23 +10,48 @@ import (
 
 var BoltDB *bolt.DB
 
+// DefaultBoltPath BoltDB 文件默认路径
+const DefaultBoltPath = "logsvc_config.db"
+
 // InitBolt 初始化 BoltDB
-func InitBolt(log *logrus.Logger) {
+func InitBolt(path string, log *logrus.Logger) {
 	var err error
-	BoltDB, err = bolt.Open("logsvc_config.db", 0600, nil)
+	BoltDB, err = bolt.Open(path, 0600, nil)
 	if err != nil {
 		log.Fatal(fmt.Sprintf("BoltDB 打不开: %v", err))
 	}
 	err = BoltDB.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("schemas"))
+		if _, err := tx.CreateBucketIfNotExists([]byte("schemas")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(apiKeysBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(settingsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(versionsBucket)
 		return err
 	})
 	if err != nil {
-		log.Fatal(fmt.Sprintf("schemas 桶创建失败: %v", err))
+		log.Fatal(fmt.Sprintf("BoltDB 桶创建失败: %v", err))
 	}
 	log.Info("BoltDB 初始化成功")
 }
 
+// PingBolt 检查 BoltDB 是否可读且 schemas 桶存在
+func PingBolt() error {
+	if BoltDB == nil {
+		return fmt.Errorf("BoltDB 未初始化")
+	}
+	return BoltDB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("schemas")) == nil {
+			return fmt.Errorf("schemas 桶不存在")
+		}
+		return nil
+	})
+}
+
 // CacheSchema 将 schema_name 和 schema_id 的映射存入 BoltDB
 func CacheSchema(schemaID, schemaName string, log *logrus.Logger) error {
 	return BoltDB.Update(func(tx *bolt.Tx) error {
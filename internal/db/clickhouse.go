@@ -1,15 +1,17 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/metrics"
 	"github.com/vkeeps/agera-logs/internal/model"
-	"os"
 )
 
 var (
@@ -20,89 +22,193 @@ var (
 
 const TablePrefix = "log_"
 
-// InitClickHouse 初始化 ClickHouse 连接
-func InitClickHouse(log *logrus.Logger) {
-	addr := os.Getenv("CLICKHOUSE_ADDR")
-	if addr == "" {
-		addr = "localhost:29000"
-	}
-	user := os.Getenv("CLICKHOUSE_USER")
-	if user == "" {
-		user = "default"
-	}
-	pass := os.Getenv("CLICKHOUSE_PASS")
-	if pass == "" {
-		pass = "crane"
-	}
-	dbName := os.Getenv("CLICKHOUSE_DB")
-	if dbName == "" {
-		dbName = "default"
-	}
+// insertDuration 每个分组写入 ClickHouse 的耗时
+var insertDuration = metrics.NewHistogramVec("agera_clickhouse_insert_duration_seconds",
+	"单个 schema.module 分组写入 ClickHouse 的耗时，result 为 ok 或 error", metrics.DefBuckets, "result")
 
+func init() {
+	metrics.Default.MustRegister(insertDuration)
+}
+
+// ClickHouseConfig ClickHouse 连接配置
+type ClickHouseConfig struct {
+	Addr      string `yaml:"addr"`
+	User      string `yaml:"user"`
+	Password  string `yaml:"password"`
+	Database  string `yaml:"database"`
+	Partition string `yaml:"partition"` // 新建日志表的分区粒度：month 或 day
+}
+
+// DefaultClickHouseConfig 默认连接配置，密码需通过配置文件或 CLICKHOUSE_PASS 提供
+func DefaultClickHouseConfig() ClickHouseConfig {
+	return ClickHouseConfig{Addr: "localhost:29000", User: "default", Database: "default", Partition: PartitionMonth}
+}
+
+// InitClickHouse 初始化 ClickHouse 连接
+func InitClickHouse(cfg ClickHouseConfig, log *logrus.Logger) {
 	conn := clickhouse.OpenDB(&clickhouse.Options{
-		Addr: []string{addr},
+		Addr: []string{cfg.Addr},
 		Auth: clickhouse.Auth{
-			Database: dbName,
-			Username: user,
-			Password: pass,
+			Database: cfg.Database,
+			Username: cfg.User,
+			Password: cfg.Password,
 		},
 	})
 	ClickHouseDB = conn
+	if cfg.Partition != "" {
+		partition = cfg.Partition
+	}
 	if err := conn.Ping(); err != nil {
 		log.Fatal(fmt.Sprintf("ClickHouse 连不上: %v", err))
 	}
-	log.Info(fmt.Sprintf("ClickHouse 连接成功，地址: %s", addr))
+	log.Info(fmt.Sprintf("ClickHouse 连接成功，地址: %s", cfg.Addr))
 }
 
-// EnsureTable 在指定 schema（数据库）中创建表，添加字段约束
+// PingClickHouse 检查 ClickHouse 连接是否可用
+func PingClickHouse(ctx context.Context) error {
+	if ClickHouseDB == nil {
+		return fmt.Errorf("ClickHouse 未初始化")
+	}
+	if err := ClickHouseDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("ClickHouse ping 失败: %v", err)
+	}
+	return nil
+}
+
+// EnsureTable 在指定 schema（数据库）中创建表，表结构见 createTableQuery
 func EnsureTable(schemaName, moduleName string, log *logrus.Logger) error {
+	if err := ValidateNames(schemaName, moduleName); err != nil {
+		return err
+	}
 	tablesMu.Lock()
 	defer tablesMu.Unlock()
 
-	tableName := fmt.Sprintf("%s.%s%s_%s", schemaName, TablePrefix, schemaName, moduleName)
+	tableName := tableIdent(schemaName, moduleName)
 	if tables[tableName] {
 		return nil
 	}
 
-	query := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s (
-			output String NOT NULL,
-			detail String,
-			error_info String,
-			service String NOT NULL,
-			client_ip String NOT NULL,
-			client_addr String NOT NULL,
-			log_level String NOT NULL,
-			operator_id String NOT NULL,
-			operator String NOT NULL,
-			operator_ip String NOT NULL,
-			operator_equipment String NOT NULL,
-			operator_company String NOT NULL,
-			operator_project String NOT NULL,
-			operation_time DateTime NOT NULL,
-			push_type String NOT NULL
-		) ENGINE = MergeTree()
-		ORDER BY (operation_time)
-	`, tableName)
-	_, err := ClickHouseDB.Exec(query)
+	exists, err := tableExists(schemaName, moduleName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		// 已有的表在首次写入前补齐结构变更，避免 INSERT 的列与表结构不一致
+		if err := migrateTable(schemaName, moduleName, log); err != nil {
+			return err
+		}
+		tables[tableName] = true
+		return nil
+	}
+
+	settings, err := GetSchemaSettings(schemaName, log)
+	if err != nil {
+		return err
+	}
+
+	query := createTableQuery(tableName, settings.RetentionFor(moduleName))
+	_, err = ClickHouseDB.Exec(query)
 	if err != nil {
 		log.Error(fmt.Sprintf("创建表 %s 失败: %v", tableName, err))
 		return fmt.Errorf("创建表 %s 失败: %v", tableName, err)
 	}
+	if err := setTableVersion(schemaName, moduleName, CurrentTableVersion); err != nil {
+		return err
+	}
 	tables[tableName] = true
 	log.Info(fmt.Sprintf("表 %s 创建成功", tableName))
 	return nil
 }
 
-// InsertLogs 批量插入日志
+// TableExists 检查 schema.module 对应的表是否存在，供只读接口使用，不会建表或迁移表结构
+func TableExists(schemaName, moduleName string) (bool, error) {
+	if err := ValidateNames(schemaName, moduleName); err != nil {
+		return false, err
+	}
+	tablesMu.Lock()
+	known := tables[tableIdent(schemaName, moduleName)]
+	tablesMu.Unlock()
+	if known {
+		return true, nil
+	}
+	return tableExists(schemaName, moduleName)
+}
+
+// GroupFailure 某个 (schema, module) 分组写入失败的信息
+type GroupFailure struct {
+	Schema  string
+	Module  string
+	Entries []*model.Log
+	Err     error
+}
+
+// InsertError InsertLogs 部分或全部分组写入失败时返回，未列出的分组均已写入成功
+type InsertError struct {
+	Total    int
+	Failures []GroupFailure
+}
+
+func (e *InsertError) Error() string {
+	failed := 0
+	parts := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		failed += len(f.Entries)
+		parts = append(parts, fmt.Sprintf("%s.%s(%d 条): %v", f.Schema, f.Module, len(f.Entries), f.Err))
+	}
+	return fmt.Sprintf("%d 条日志中 %d 条写入失败: %s", e.Total, failed, strings.Join(parts, "; "))
+}
+
+// logGroup 同一张表的日志
+type logGroup struct {
+	schema  string
+	module  string
+	entries []*model.Log
+}
+
+// groupLogs 按 (schema, module) 分组，分组顺序和组内顺序均保持首次出现的顺序
+func groupLogs(entries []*model.Log) []*logGroup {
+	var groups []*logGroup
+	index := make(map[[2]string]*logGroup)
+	for _, entry := range entries {
+		key := [2]string{string(entry.Schema), string(entry.Module)}
+		g, ok := index[key]
+		if !ok {
+			g = &logGroup{schema: key[0], module: key[1]}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.entries = append(g.entries, entry)
+	}
+	return groups
+}
+
+// InsertLogs 批量插入日志，按 (schema, module) 分组写入各自的表
+// 某个分组失败不影响其它分组，失败的分组通过 *InsertError 返回
 func InsertLogs(entries []*model.Log, log *logrus.Logger) error {
 	if len(entries) == 0 {
 		return nil
 	}
 
-	// 确保表存在（使用第一个日志的 schema 和 module）
-	schemaName := string(entries[0].Schema)
-	moduleName := string(entries[0].Module)
+	var failures []GroupFailure
+	for _, g := range groupLogs(entries) {
+		start := time.Now()
+		err := insertGroup(g.schema, g.module, g.entries, log)
+		result := "ok"
+		if err != nil {
+			result = "error"
+			failures = append(failures, GroupFailure{Schema: g.schema, Module: g.module, Entries: g.entries, Err: err})
+		}
+		insertDuration.With(result).Observe(time.Since(start).Seconds())
+	}
+	if len(failures) > 0 {
+		return &InsertError{Total: len(entries), Failures: failures}
+	}
+	return nil
+}
+
+// insertGroup 在一个事务中将同一张表的日志写入
+func insertGroup(schemaName, moduleName string, entries []*model.Log, log *logrus.Logger) error {
+	// 确保表存在
 	if err := EnsureTable(schemaName, moduleName, log); err != nil {
 		return err
 	}
@@ -115,10 +221,10 @@ func InsertLogs(entries []*model.Log, log *logrus.Logger) error {
 	}
 
 	// 准备批量插入语句
-	tableName := fmt.Sprintf("%s.%s%s_%s", schemaName, TablePrefix, schemaName, moduleName)
+	tableName := tableIdent(schemaName, moduleName)
 	query := fmt.Sprintf(`
-		INSERT INTO %s (output, detail, error_info, service, client_ip, client_addr, log_level, operator_id, operator, operator_ip, operator_equipment, operator_company, operator_project, operation_time, push_type)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO %s (output, detail, error_info, service, client_ip, client_addr, log_level, operator_id, operator, operator_ip, operator_equipment, operator_company, operator_project, operation_time, push_type, attributes, receive_time, client_identity)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, tableName)
 	stmt, err := tx.Prepare(query)
 	if err != nil {
@@ -153,6 +259,9 @@ func InsertLogs(entries []*model.Log, log *logrus.Logger) error {
 			nonEmpty(entry.OperatorProject, "unknown"),
 			entry.Timestamp,
 			string(entry.PushType),
+			attributesOrEmpty(entry.Attributes),
+			receiveTime(entry),
+			entry.ClientIdentity,
 		)
 		if err != nil {
 			tx.Rollback()
@@ -174,6 +283,22 @@ func InsertLog(entry *model.Log, log *logrus.Logger) error {
 	return InsertLogs([]*model.Log{entry}, log)
 }
 
+// receiveTime 升级前写入 spool 的日志没有接收时间，使用事件时间
+func receiveTime(entry *model.Log) time.Time {
+	if entry.ReceiveTime.IsZero() {
+		return entry.Timestamp
+	}
+	return entry.ReceiveTime
+}
+
+// attributesOrEmpty 没有 attributes 时写入空 Map
+func attributesOrEmpty(attrs map[string]string) map[string]string {
+	if attrs == nil {
+		return map[string]string{}
+	}
+	return attrs
+}
+
 // nonEmpty 返回非空值，若输入为空则使用默认值
 func nonEmpty(value, defaultValue string) string {
 	if value == "" {
@@ -184,7 +309,10 @@ func nonEmpty(value, defaultValue string) string {
 
 // DatabaseExists 检查 ClickHouse 中是否存在指定数据库
 func DatabaseExists(dbName string, log *logrus.Logger) (bool, error) {
-	query := fmt.Sprintf("EXISTS DATABASE %s", dbName)
+	if err := ValidateSchemaName(dbName); err != nil {
+		return false, err
+	}
+	query := fmt.Sprintf("EXISTS DATABASE %s", quoteIdent(dbName))
 	var exists uint8
 	err := ClickHouseDB.QueryRow(query).Scan(&exists)
 	if err != nil {
@@ -196,7 +324,10 @@ func DatabaseExists(dbName string, log *logrus.Logger) (bool, error) {
 
 // CreateDatabase 创建 ClickHouse 数据库
 func CreateDatabase(dbName string, log *logrus.Logger) error {
-	query := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbName)
+	if err := ValidateSchemaName(dbName); err != nil {
+		return err
+	}
+	query := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", quoteIdent(dbName))
 	_, err := ClickHouseDB.Exec(query)
 	if err != nil {
 		log.Error(fmt.Sprintf("创建数据库 %s 失败: %v", dbName, err))
@@ -207,7 +338,7 @@ func CreateDatabase(dbName string, log *logrus.Logger) error {
 }
 
 // GetAllSchemas 获取所有 schema（数据库）列表
-func GetAllSchemas(log *logrus.Logger) ([]map[string]interface{}, error) {
+func GetAllSchemas(log *logrus.Logger) ([]SchemaInfo, error) {
 	rows, err := ClickHouseDB.Query("SHOW DATABASES")
 	if err != nil {
 		log.Error(fmt.Sprintf("查询所有数据库失败: %v", err))
@@ -215,25 +346,22 @@ func GetAllSchemas(log *logrus.Logger) ([]map[string]interface{}, error) {
 	}
 	defer rows.Close()
 
-	var schemas []map[string]interface{}
+	var schemas []SchemaInfo
 	for rows.Next() {
 		var dbName string
 		if err := rows.Scan(&dbName); err != nil {
 			log.Error(fmt.Sprintf("解析数据库名称失败: %v", err))
 			return nil, fmt.Errorf("解析数据库名称失败: %v", err)
 		}
-		if dbName == "system" || dbName == "default" {
-			continue // 跳过系统数据库
+		if ValidateSchemaName(dbName) != nil {
+			continue // 跳过系统数据库和不符合命名规则的数据库
 		}
 		schemaID, err := GetOrCreateSchema(dbName, log)
 		if err != nil {
 			log.Error(fmt.Sprintf("获取 schema %s 的 ID 失败: %v", dbName, err))
 			continue
 		}
-		schemas = append(schemas, map[string]interface{}{
-			"name": dbName,
-			"id":   schemaID,
-		})
+		schemas = append(schemas, SchemaInfo{Name: dbName, ID: schemaID})
 	}
 	return schemas, nil
 }
@@ -287,8 +415,13 @@ func GetModulesBySchemaId(schemaId string, log *logrus.Logger) ([]string, error)
 		return nil, fmt.Errorf("未找到 schema_id %s 对应的数据库名", schemaId)
 	}
 
+	return listModules(schemaName, log)
+}
+
+// listModules 列出 schema 数据库中的日志模块，按名称排序
+func listModules(schemaName string, log *logrus.Logger) ([]string, error) {
 	// 直接查询数据库表，而不是通过GetTablesBySchemaId
-	rows, err := ClickHouseDB.Query("SELECT name FROM system.tables WHERE database = ?", schemaName)
+	rows, err := ClickHouseDB.Query("SELECT name FROM system.tables WHERE database = ? ORDER BY name", schemaName)
 	if err != nil {
 		log.Error(fmt.Sprintf("查询 schema %s 的表失败: %v", schemaName, err))
 		return nil, fmt.Errorf("查询 schema %s 的表失败: %v", schemaName, err)
@@ -296,18 +429,16 @@ func GetModulesBySchemaId(schemaId string, log *logrus.Logger) ([]string, error)
 	defer rows.Close()
 
 	var modules []string
+	prefix := TablePrefix + schemaName + "_"
 	for rows.Next() {
 		var tableName string
 		if err := rows.Scan(&tableName); err != nil {
 			log.Error(fmt.Sprintf("解析表名称失败: %v", err))
 			return nil, fmt.Errorf("解析表名称失败: %v", err)
 		}
-
-		prefix := TablePrefix + schemaName + "_"
 		if strings.HasPrefix(tableName, prefix) {
-			module := strings.TrimPrefix(tableName, prefix)
-			modules = append(modules, module)
+			modules = append(modules, strings.TrimPrefix(tableName, prefix))
 		}
 	}
-	return modules, nil
+	return modules, rows.Err()
 }
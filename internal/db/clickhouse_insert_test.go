@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+func TestGroupLogs(t *testing.T) {
+	newLog := func(schema, module, output string) *model.Log {
+		return &model.Log{
+			LogBase: model.LogBase{Output: output},
+			Schema:  model.LogSchema(schema),
+			Module:  model.LogModule(module),
+		}
+	}
+	entries := []*model.Log{
+		newLog("shop", "order", "1"),
+		newLog("crm", "login", "2"),
+		newLog("shop", "order", "3"),
+		newLog("shop", "pay", "4"),
+		newLog("crm", "login", "5"),
+	}
+
+	groups := groupLogs(entries)
+	want := []struct {
+		schema, module string
+		outputs        []string
+	}{
+		{"shop", "order", []string{"1", "3"}},
+		{"crm", "login", []string{"2", "5"}},
+		{"shop", "pay", []string{"4"}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("分组数不匹配，预期 %d，实际 %d", len(want), len(groups))
+	}
+	for i, w := range want {
+		g := groups[i]
+		if g.schema != w.schema || g.module != w.module {
+			t.Errorf("第 %d 组预期 %s.%s，实际 %s.%s", i, w.schema, w.module, g.schema, g.module)
+			continue
+		}
+		if len(g.entries) != len(w.outputs) {
+			t.Errorf("%s.%s 条数预期 %d，实际 %d", g.schema, g.module, len(w.outputs), len(g.entries))
+			continue
+		}
+		for j, entry := range g.entries {
+			if entry.Output != w.outputs[j] {
+				t.Errorf("%s.%s 第 %d 条预期 %s，实际 %s", g.schema, g.module, j, w.outputs[j], entry.Output)
+			}
+		}
+	}
+}
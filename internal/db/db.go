@@ -7,6 +7,9 @@ import (
 
 // GetOrCreateSchema 获取或创建 schema（数据库），返回固定加密的 schema_id
 func GetOrCreateSchema(schemaName string, log *logrus.Logger) (string, error) {
+	if err := ValidateSchemaName(schemaName); err != nil {
+		return "", err
+	}
 	// 生成固定的 schema_id（基于 SHA-256 哈希）
 	schemaID := GenerateSchemaID(schemaName)
 
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxNameLength schema 和 module 名称的最大长度
+const MaxNameLength = 64
+
+// namePattern schema 和 module 名称允许的格式：字母开头，只包含字母、数字和下划线
+var namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// reservedSchemas ClickHouse 自带的数据库，不能作为 schema 使用
+var reservedSchemas = map[string]bool{
+	"system":             true,
+	"default":            true,
+	"information_schema": true,
+}
+
+// ErrInvalidName schema 或 module 名称不合法，可用 errors.Is 判断
+var ErrInvalidName = errors.New("名称不合法")
+
+// NameError 名称校验失败的详情
+type NameError struct {
+	Kind   string // schema 或 module
+	Name   string
+	Reason string
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("%s 名称 %q 不合法: %s", e.Kind, e.Name, e.Reason)
+}
+
+func (e *NameError) Is(target error) bool {
+	return target == ErrInvalidName
+}
+
+func validateName(kind, name string) error {
+	switch {
+	case name == "":
+		return &NameError{Kind: kind, Name: name, Reason: "不能为空"}
+	case len(name) > MaxNameLength:
+		return &NameError{Kind: kind, Name: name, Reason: fmt.Sprintf("长度不能超过 %d", MaxNameLength)}
+	case !namePattern.MatchString(name):
+		return &NameError{Kind: kind, Name: name, Reason: "只能包含字母、数字和下划线，且以字母开头"}
+	}
+	return nil
+}
+
+// ValidateSchemaName 校验 schema 名称，schema 会作为 ClickHouse 数据库名
+func ValidateSchemaName(name string) error {
+	if err := validateName("schema", name); err != nil {
+		return err
+	}
+	if reservedSchemas[strings.ToLower(name)] {
+		return &NameError{Kind: "schema", Name: name, Reason: "与 ClickHouse 系统数据库重名"}
+	}
+	return nil
+}
+
+// ValidateModuleName 校验 module 名称，module 是表名的一部分
+func ValidateModuleName(name string) error {
+	return validateName("module", name)
+}
+
+// ValidateNames 同时校验 schema 和 module 名称
+func ValidateNames(schemaName, moduleName string) error {
+	if err := ValidateSchemaName(schemaName); err != nil {
+		return err
+	}
+	return ValidateModuleName(moduleName)
+}
+
+// quoteIdent 用反引号引用标识符，名称校验之外再做一层防护
+func quoteIdent(name string) string {
+	return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(name) + "`"
+}
+
+// tableName 返回 schema.module 对应的表名（不含数据库），格式为 log_<schema>_<module>
+func tableName(schemaName, moduleName string) string {
+	return TablePrefix + schemaName + "_" + moduleName
+}
+
+// tableIdent 返回可直接拼入 SQL 的完整表名 `schema`.`log_schema_module`
+func tableIdent(schemaName, moduleName string) string {
+	return quoteIdent(schemaName) + "." + quoteIdent(tableName(schemaName, moduleName))
+}
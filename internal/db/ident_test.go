@@ -0,0 +1,43 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateNames(t *testing.T) {
+	for _, tc := range []struct {
+		schema, module string
+		ok             bool
+	}{
+		{"shop", "order", true},
+		{"Shop_2", "order_v2", true},
+		{"shop.x", "order", false},
+		{"shop", "order; DROP TABLE x", false},
+		{"shop", "or`der", false},
+		{"shop", "order pay", false},
+		{"1shop", "order", false},
+		{"_shop", "order", false},
+		{"system", "order", false},
+		{"INFORMATION_SCHEMA", "order", false},
+		{"", "order", false},
+		{"shop", "", false},
+	} {
+		err := ValidateNames(tc.schema, tc.module)
+		if (err == nil) != tc.ok {
+			t.Errorf("ValidateNames(%q, %q) = %v", tc.schema, tc.module, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidName) {
+			t.Errorf("错误应能用 errors.Is 识别为 ErrInvalidName: %v", err)
+		}
+	}
+}
+
+func TestTableIdent(t *testing.T) {
+	if got := tableIdent("shop", "order"); got != "`shop`.`log_shop_order`" {
+		t.Errorf("表名不符合预期: %s", got)
+	}
+	if got := quoteIdent("a`b\\c"); got != "`a\\`b\\\\c`" {
+		t.Errorf("转义不符合预期: %s", got)
+	}
+}
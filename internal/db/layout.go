@@ -0,0 +1,242 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 日志表的分区粒度
+const (
+	PartitionMonth = "month"
+	PartitionDay   = "day"
+)
+
+// partition 新建表使用的分区粒度，由 InitClickHouse 根据配置设置
+var partition = PartitionMonth
+
+// sortingKey 日志表的排序键，按 service、log_level 过滤时可以跳过无关的数据块
+const sortingKey = "service, log_level, operation_time"
+
+// indexGranularity 跳数索引的粒度（以 index_granularity 为单位）
+const indexGranularity = 4
+
+// column 日志表的一列
+type column struct {
+	name, typ string
+}
+
+// tableColumns 日志表的列，取值有限的枚举类列使用 LowCardinality
+var tableColumns = []column{
+	{"output", "String"},
+	{"detail", "String"},
+	{"error_info", "String"},
+	{"service", "LowCardinality(String)"},
+	{"client_ip", "String"},
+	{"client_addr", "String"},
+	{"log_level", "LowCardinality(String)"},
+	{"operator_id", "String"},
+	{"operator", "String"},
+	{"operator_ip", "String"},
+	{"operator_equipment", "LowCardinality(String)"},
+	{"operator_company", "LowCardinality(String)"},
+	{"operator_project", "LowCardinality(String)"},
+	{"operation_time", timeType},
+	{"push_type", "LowCardinality(String)"},
+	{"attributes", "Map(String, String)"},
+	{"receive_time", timeType},
+	{"client_identity", "LowCardinality(String)"},
+}
+
+// timeType operation_time（事件时间）和 receive_time（接收时间）的类型，精确到微秒
+const timeType = "DateTime64(6)"
+
+// skipIndex 跳数索引
+type skipIndex struct {
+	name, expr, typ string
+}
+
+// tableIndexes 日志表的跳数索引：文本列按小写建 token 和 ngram 布隆过滤器，分别用于分词搜索和子串搜索，
+// 表达式需与 LogQuery.whereClause 中的写法一致才能命中
+var tableIndexes = []skipIndex{
+	{"idx_output_token", "lowerUTF8(output)", "tokenbf_v1(10240, 3, 0)"},
+	{"idx_output_ngram", "lowerUTF8(output)", "ngrambf_v1(3, 10240, 3, 0)"},
+	{"idx_detail_token", "lowerUTF8(detail)", "tokenbf_v1(10240, 3, 0)"},
+	{"idx_detail_ngram", "lowerUTF8(detail)", "ngrambf_v1(3, 10240, 3, 0)"},
+	{"idx_error_info_token", "lowerUTF8(error_info)", "tokenbf_v1(10240, 3, 0)"},
+	{"idx_error_info_ngram", "lowerUTF8(error_info)", "ngrambf_v1(3, 10240, 3, 0)"},
+	{"idx_operator_id", "operator_id", "bloom_filter(0.01)"},
+	{"idx_attribute_keys", "mapKeys(attributes)", "bloom_filter(0.01)"},
+	{"idx_attribute_values", "mapValues(attributes)", "bloom_filter(0.01)"},
+}
+
+// partitionKey 分区粒度对应的分区表达式
+func partitionKey(p string) string {
+	if p == PartitionDay {
+		return "toYYYYMMDD(operation_time)"
+	}
+	return "toYYYYMM(operation_time)"
+}
+
+// ValidatePartition 校验分区粒度
+func ValidatePartition(p string) error {
+	if p != PartitionMonth && p != PartitionDay {
+		return fmt.Errorf("clickhouse.partition 只能是 %s 或 %s: %s", PartitionMonth, PartitionDay, p)
+	}
+	return nil
+}
+
+// createTableQuery 建表语句，days 为保留天数，0 表示永久保留
+func createTableQuery(table string, days int) string {
+	defs := make([]string, 0, len(tableColumns)+len(tableIndexes))
+	for _, c := range tableColumns {
+		defs = append(defs, fmt.Sprintf("%s %s NOT NULL", c.name, c.typ))
+	}
+	for _, idx := range tableIndexes {
+		defs = append(defs, idx.definition())
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n) ENGINE = MergeTree()\nPARTITION BY %s\nORDER BY (%s)\n%s",
+		table, strings.Join(defs, ",\n\t"), partitionKey(partition), sortingKey, ttlClause(days))
+}
+
+func (idx skipIndex) definition() string {
+	return fmt.Sprintf("INDEX %s %s TYPE %s GRANULARITY %d", idx.name, idx.expr, idx.typ, indexGranularity)
+}
+
+// columnNames 逗号分隔的列名，迁移时用于在新旧表之间复制数据
+func columnNames() string {
+	names := make([]string, len(tableColumns))
+	for i, c := range tableColumns {
+		names[i] = c.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// tableLayout 表当前的分区键、排序键、列类型和已有的跳数索引
+type tableLayout struct {
+	partitionKey string
+	sortingKey   string
+	columns      map[string]string
+	indexes      map[string]bool
+}
+
+func describeTable(schemaName, moduleName string) (tableLayout, error) {
+	layout := tableLayout{columns: make(map[string]string), indexes: make(map[string]bool)}
+	name := tableName(schemaName, moduleName)
+	err := ClickHouseDB.QueryRow("SELECT partition_key, sorting_key FROM system.tables WHERE database = ? AND name = ?",
+		schemaName, name).Scan(&layout.partitionKey, &layout.sortingKey)
+	if err != nil {
+		return layout, fmt.Errorf("查询表 %s.%s 结构失败: %v", schemaName, moduleName, err)
+	}
+	for _, q := range []struct {
+		query string
+		scan  func(rows *sql.Rows) error
+	}{
+		{"SELECT name, type FROM system.columns WHERE database = ? AND table = ?", func(rows *sql.Rows) error {
+			var name, typ string
+			err := rows.Scan(&name, &typ)
+			layout.columns[name] = typ
+			return err
+		}},
+		{"SELECT name FROM system.data_skipping_indices WHERE database = ? AND table = ?", func(rows *sql.Rows) error {
+			var name string
+			err := rows.Scan(&name)
+			layout.indexes[name] = true
+			return err
+		}},
+	} {
+		if err := scanAll(q.scan, q.query, schemaName, name); err != nil {
+			return layout, fmt.Errorf("查询表 %s.%s 结构失败: %v", schemaName, moduleName, err)
+		}
+	}
+	return layout, nil
+}
+
+func scanAll(scan func(rows *sql.Rows) error, query string, args ...any) error {
+	rows, err := ClickHouseDB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// tableExists 表是否已存在
+func tableExists(schemaName, moduleName string) (bool, error) {
+	var n uint64
+	err := ClickHouseDB.QueryRow("SELECT count() FROM system.tables WHERE database = ? AND name = ?",
+		schemaName, tableName(schemaName, moduleName)).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("查询表 %s.%s 是否存在失败: %v", schemaName, moduleName, err)
+	}
+	return n > 0, nil
+}
+
+// UpgradeTables 把所有 schema 下按旧结构创建的表升级到当前的分区、排序键和索引，返回重建的表数量
+func UpgradeTables(log *logrus.Logger) (int, error) {
+	upgraded := 0
+	err := forEachTable(log, func(schemaName, moduleName string) error {
+		rebuilt, err := UpgradeTable(schemaName, moduleName, log)
+		if rebuilt {
+			upgraded++
+		}
+		return err
+	})
+	return upgraded, err
+}
+
+// UpgradeTable 先执行待执行的结构变更，再按需重建表。分区键、排序键以及排序键中 operation_time 的类型无法原地修改，需要重建：
+// 先建好新结构的空表并与旧表原子交换，使新写入直接进入新表，再把旧数据复制过去，跳数索引随写入生成；
+// 旧表改名为 bak_ 开头保留，确认无误后可手动删除
+func UpgradeTable(schemaName, moduleName string, log *logrus.Logger) (bool, error) {
+	if err := migrateTable(schemaName, moduleName, log); err != nil {
+		return false, err
+	}
+	layout, err := describeTable(schemaName, moduleName)
+	if err != nil {
+		return false, err
+	}
+	if layout.partitionKey == partitionKey(partition) && layout.sortingKey == sortingKey && layout.columns["operation_time"] == timeType {
+		return false, nil
+	}
+	return true, rebuildTable(schemaName, moduleName, log)
+}
+
+func rebuildTable(schemaName, moduleName string, log *logrus.Logger) error {
+	settings, err := GetSchemaSettings(schemaName, log)
+	if err != nil {
+		return err
+	}
+	name := tableName(schemaName, moduleName)
+	table := tableIdent(schemaName, moduleName)
+	staging := quoteIdent(schemaName) + "." + quoteIdent("migrating_"+name)
+	backup := quoteIdent(schemaName) + "." + quoteIdent(fmt.Sprintf("bak_%s_%s", name, time.Now().Format("20060102150405")))
+
+	for _, query := range []string{
+		"DROP TABLE IF EXISTS " + staging,
+		createTableQuery(staging, settings.RetentionFor(moduleName)),
+		fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", table, backup, staging, table),
+	} {
+		if _, err := ClickHouseDB.Exec(query); err != nil {
+			log.Error(fmt.Sprintf("重建表 %s 失败: %v", table, err))
+			return fmt.Errorf("重建表 %s 失败: %v", table, err)
+		}
+	}
+	log.Info(fmt.Sprintf("表 %s 已切换到新结构，开始从 %s 复制数据", table, backup))
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", table, columnNames(), columnNames(), backup)
+	if _, err := ClickHouseDB.Exec(query); err != nil {
+		log.Error(fmt.Sprintf("从 %s 复制数据到 %s 失败: %v", backup, table, err))
+		return fmt.Errorf("从 %s 复制数据到 %s 失败，旧数据仍保留在 %s: %v", backup, table, backup, err)
+	}
+	log.Info(fmt.Sprintf("表 %s 升级完成，旧表保留为 %s", table, backup))
+	return nil
+}
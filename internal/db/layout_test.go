@@ -0,0 +1,35 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateTableQuery(t *testing.T) {
+	query := createTableQuery("`shop`.`log_shop_order`", 7)
+	for _, part := range []string{
+		"CREATE TABLE IF NOT EXISTS `shop`.`log_shop_order`",
+		"service LowCardinality(String) NOT NULL",
+		"INDEX idx_output_ngram lowerUTF8(output) TYPE ngrambf_v1(3, 10240, 3, 0) GRANULARITY 4",
+		"PARTITION BY toYYYYMM(operation_time)",
+		"ORDER BY (service, log_level, operation_time)",
+		"TTL toDateTime(operation_time) + INTERVAL 7 DAY",
+	} {
+		if !strings.Contains(query, part) {
+			t.Errorf("建表语句缺少 %q:\n%s", part, query)
+		}
+	}
+	if got := partitionKey(PartitionDay); got != "toYYYYMMDD(operation_time)" {
+		t.Errorf("按天分区表达式不符合预期: %s", got)
+	}
+
+	// 搜索条件必须使用与索引相同的表达式
+	for _, match := range []MatchMode{MatchSubstring, MatchToken} {
+		where, _ := LogQuery{Search: "x", Match: match}.whereClause()
+		for _, idx := range tableIndexes {
+			if strings.HasPrefix(idx.expr, "lowerUTF8(") && !strings.Contains(where, idx.expr) {
+				t.Errorf("%s 搜索条件未使用索引表达式 %s: %s", match, idx.expr, where)
+			}
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// MemoryStorage 内存版 Storage，供单元测试和集成测试使用，不依赖 ClickHouse 和 BoltDB
+type MemoryStorage struct {
+	mu       sync.RWMutex
+	schemas  map[string]string // schema_id -> schema 名称
+	tables   map[string]map[string][]model.LogRecord
+	keys     map[string]*APIKey // token 哈希 -> APIKey
+	settings map[string]SchemaSettings
+}
+
+// NewMemoryStorage 创建空的内存存储
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		schemas:  make(map[string]string),
+		tables:   make(map[string]map[string][]model.LogRecord),
+		keys:     make(map[string]*APIKey),
+		settings: make(map[string]SchemaSettings),
+	}
+}
+
+func (s *MemoryStorage) EnsureTable(schemaName, moduleName string) error {
+	if err := ValidateNames(schemaName, moduleName); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ensureTableLocked(schemaName, moduleName)
+	return nil
+}
+
+func (s *MemoryStorage) ensureTableLocked(schemaName, moduleName string) {
+	modules, ok := s.tables[schemaName]
+	if !ok {
+		modules = make(map[string][]model.LogRecord)
+		s.tables[schemaName] = modules
+	}
+	if _, ok := modules[moduleName]; !ok {
+		modules[moduleName] = nil
+	}
+}
+
+func (s *MemoryStorage) TableExists(schemaName, moduleName string) (bool, error) {
+	if err := ValidateNames(schemaName, moduleName); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.tables[schemaName][moduleName]
+	return ok, nil
+}
+
+func (s *MemoryStorage) InsertLogs(entries []*model.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range entries {
+		schemaName, moduleName := string(entry.Schema), string(entry.Module)
+		s.ensureTableLocked(schemaName, moduleName)
+		s.tables[schemaName][moduleName] = append(s.tables[schemaName][moduleName], ToRecord(entry))
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetOrCreateSchema(schemaName string) (string, error) {
+	if err := ValidateSchemaName(schemaName); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schemaID := GenerateSchemaID(schemaName)
+	s.schemas[schemaID] = schemaName
+	if _, ok := s.tables[schemaName]; !ok {
+		s.tables[schemaName] = make(map[string][]model.LogRecord)
+	}
+	return schemaID, nil
+}
+
+func (s *MemoryStorage) SchemaExists(schemaName string) (bool, error) {
+	if err := ValidateSchemaName(schemaName); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.tables[schemaName]
+	return ok, nil
+}
+
+func (s *MemoryStorage) GetSchemaNameByID(schemaID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schemas[schemaID], nil
+}
+
+// RebuildSchemaCache 内存存储的 schema 映射始终完整，无需重建
+func (s *MemoryStorage) RebuildSchemaCache(schemaID string) {}
+
+func (s *MemoryStorage) ListSchemas() ([]SchemaInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var schemas []SchemaInfo
+	for id, name := range s.schemas {
+		schemas = append(schemas, SchemaInfo{Name: name, ID: id})
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+	return schemas, nil
+}
+
+func (s *MemoryStorage) ListModules(schemaID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schemaName, ok := s.schemas[schemaID]
+	if !ok {
+		return nil, fmt.Errorf("未找到 schema_id %s 对应的数据库名", schemaID)
+	}
+	var modules []string
+	for module := range s.tables[schemaName] {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	return modules, nil
+}
+
+func (s *MemoryStorage) CreateAPIKey(schemaName, name string) (APIKey, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.schemas[GenerateSchemaID(schemaName)]; !ok {
+		return APIKey{}, "", fmt.Errorf("schema %s 未注册", schemaName)
+	}
+	key, token, err := newAPIKey(schemaName, name)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	s.keys[HashAPIKey(token)] = key
+	return *key, token, nil
+}
+
+func (s *MemoryStorage) ListAPIKeys(schemaName string) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []APIKey
+	for _, key := range s.keys {
+		if key.Schema == schemaName {
+			keys = append(keys, *key)
+		}
+	}
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *MemoryStorage) RevokeAPIKey(schemaName, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys {
+		if key.ID == id && key.Schema == schemaName {
+			if key.RevokedAt == nil {
+				now := time.Now().UTC()
+				key.RevokedAt = &now
+			}
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+func (s *MemoryStorage) LookupAPIKey(hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[hash]
+	if !ok {
+		return nil, nil
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (s *MemoryStorage) TouchAPIKeys(lastUsed map[string]time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range lastUsed {
+		if key, ok := s.keys[hash]; ok && (key.LastUsedAt == nil || t.After(*key.LastUsedAt)) {
+			t := t.UTC()
+			key.LastUsedAt = &t
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetSchemaSettings(schemaName string) (SchemaSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings[schemaName], nil
+}
+
+// UpdateSchemaSettings 内存存储只保存配置，不做过期清理
+func (s *MemoryStorage) UpdateSchemaSettings(schemaName string, settings SchemaSettings) error {
+	if err := ValidateSchemaName(schemaName); err != nil {
+		return err
+	}
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[schemaName] = settings
+	return nil
+}
+
+func (s *MemoryStorage) QueryLogs(q LogQuery) (LogPage, error) {
+	if err := ValidateSchemaName(q.Schema); err != nil {
+		return LogPage{}, err
+	}
+	if q.Module != "" {
+		if err := ValidateModuleName(q.Module); err != nil {
+			return LogPage{}, err
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	modules := make([]string, 0, len(s.tables[q.Schema]))
+	for module := range s.tables[q.Schema] {
+		if q.Module == "" || module == q.Module {
+			modules = append(modules, module)
+		}
+	}
+	sort.Strings(modules)
+
+	var logs []model.LogRecord
+	for _, module := range modules {
+		for _, record := range s.tables[q.Schema][module] {
+			if !q.Matches(record) || (q.Cursor != nil && record.OperationTime.After(q.Cursor.Time)) {
+				continue
+			}
+			if q.Module == "" {
+				record.Module = module
+			}
+			logs = append(logs, record)
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].OperationTime.After(logs[j].OperationTime) })
+	if skip := q.skip(); skip < len(logs) {
+		logs = logs[skip:]
+	} else {
+		logs = nil
+	}
+	if fetch := q.limit() + 1; len(logs) > fetch {
+		logs = logs[:fetch]
+	}
+	return q.paginate(logs), nil
+}
+
+func (s *MemoryStorage) GroupLogs(q LogQuery, by string, limit int) ([]GroupCount, error) {
+	if err := ValidateSchemaName(q.Schema); err != nil {
+		return nil, err
+	}
+	if q.Module != "" {
+		if err := ValidateModuleName(q.Module); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := ParseGroupBy(by); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]uint64)
+	for module, records := range s.tables[q.Schema] {
+		if q.Module != "" && module != q.Module {
+			continue
+		}
+		for _, record := range records {
+			if !q.Matches(record) {
+				continue
+			}
+			if value, ok := groupValue(record, by); ok {
+				counts[value]++
+			}
+		}
+	}
+	return sortGroups(counts, groupLimit(limit)), nil
+}
+
+// ToRecord 将写入模型转换为查询返回的记录，默认值处理与 ClickHouse 写入保持一致
+func ToRecord(entry *model.Log) model.LogRecord {
+	logLevel := entry.LogLevel
+	if logLevel == "" {
+		logLevel = "INFO"
+	} else {
+		logLevel = strings.ToUpper(logLevel)
+	}
+	return model.LogRecord{
+		Output:            entry.Output,
+		Detail:            entry.Detail,
+		ErrorInfo:         entry.ErrorInfo,
+		Service:           nonEmpty(entry.Service, "unknown"),
+		ClientIP:          nonEmpty(entry.ClientIP, "0.0.0.0"),
+		ClientAddr:        nonEmpty(entry.ClientAddr, "unknown"),
+		LogLevel:          logLevel,
+		OperatorID:        nonEmpty(entry.OperatorID, "unknown"),
+		Operator:          nonEmpty(entry.Operator, "unknown"),
+		OperatorIP:        nonEmpty(entry.OperatorIP, "unknown"),
+		OperatorEquipment: nonEmpty(entry.OperatorEquipment, "unknown"),
+		OperatorCompany:   nonEmpty(entry.OperatorCompany, "unknown"),
+		OperatorProject:   nonEmpty(entry.OperatorProject, "unknown"),
+		OperationTime:     entry.Timestamp.Truncate(time.Microsecond),
+		ReceiveTime:       receiveTime(entry).Truncate(time.Microsecond),
+		PushType:          string(entry.PushType),
+		Attributes:        entry.Attributes,
+		ClientIdentity:    entry.ClientIdentity,
+	}
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+// versionsBucket BoltDB 中记录日志表结构版本的桶，key 为 schema.module
+var versionsBucket = []byte("table_versions")
+
+// Migration 日志表的一次结构变更。Plan 根据表的当前结构生成需要执行的语句，
+// 语句必须可以重复执行（ADD COLUMN IF NOT EXISTS 等）：版本记录在各节点本地的 BoltDB 中，
+// 多个节点共用一个 ClickHouse 时每个节点都会执行一次
+type Migration struct {
+	Version     int
+	Description string
+	Plan        func(table string, layout tableLayout) []string
+}
+
+// migrations 按版本顺序排列的结构变更，新增列时在末尾追加一项，同时修改 tableColumns。
+// 新建的表直接使用最新结构，记为 CurrentTableVersion
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "枚举类列改为 LowCardinality，添加文本和 operator_id 跳数索引",
+		Plan: func(table string, layout tableLayout) []string {
+			var stmts []string
+			for _, c := range tableColumns {
+				if typ, ok := layout.columns[c.name]; ok && typ != c.typ && strings.HasPrefix(c.typ, "LowCardinality(") {
+					stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, c.name, c.typ))
+				}
+			}
+			return append(stmts, addIndexes(table, layout, "idx_output_token", "idx_output_ngram", "idx_detail_token",
+				"idx_detail_ngram", "idx_error_info_token", "idx_error_info_ngram", "idx_operator_id")...)
+		},
+	},
+	{
+		Version:     2,
+		Description: "添加 attributes 列及其键、值索引",
+		Plan: func(table string, layout tableLayout) []string {
+			var stmts []string
+			if _, ok := layout.columns["attributes"]; !ok {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS attributes Map(String, String)", table))
+			}
+			return append(stmts, addIndexes(table, layout, "idx_attribute_keys", "idx_attribute_values")...)
+		},
+	},
+	{
+		Version:     3,
+		Description: "添加 receive_time 列，已有数据取 operation_time；operation_time 改为 DateTime64 需通过 -upgrade-tables 重建",
+		Plan: func(table string, layout tableLayout) []string {
+			if _, ok := layout.columns["receive_time"]; ok {
+				return nil
+			}
+			return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS receive_time %s DEFAULT operation_time", table, timeType)}
+		},
+	},
+	{
+		Version:     4,
+		Description: "添加 client_identity 列，记录 TLS 客户端证书的身份",
+		Plan: func(table string, layout tableLayout) []string {
+			if _, ok := layout.columns["client_identity"]; ok {
+				return nil
+			}
+			return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS client_identity LowCardinality(String)", table)}
+		},
+	},
+}
+
+// CurrentTableVersion 日志表的最新结构版本
+var CurrentTableVersion = len(migrations)
+
+// addIndexes 补建 tableIndexes 中指定名称且表中缺少的跳数索引，只对新写入的数据生效，
+// 旧结构的表在 -upgrade-tables 重建时为历史数据生成索引
+func addIndexes(table string, layout tableLayout, names ...string) []string {
+	var stmts []string
+	for _, idx := range tableIndexes {
+		if slices.Contains(names, idx.name) && !layout.indexes[idx.name] {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD %s", table, strings.Replace(idx.definition(), "INDEX", "INDEX IF NOT EXISTS", 1)))
+		}
+	}
+	return stmts
+}
+
+// PendingMigration 某张表待执行的一次结构变更
+type PendingMigration struct {
+	Schema      string
+	Module      string
+	Version     int
+	Description string
+	Statements  []string
+}
+
+func versionKey(schemaName, moduleName string) []byte {
+	return []byte(schemaName + "." + moduleName)
+}
+
+// TableVersion 读取表的结构版本，没有记录时返回 0
+func TableVersion(schemaName, moduleName string) (int, error) {
+	var version int
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(versionsBucket)
+		if b == nil {
+			return nil
+		}
+		if v := b.Get(versionKey(schemaName, moduleName)); v != nil {
+			n, err := strconv.Atoi(string(v))
+			if err != nil {
+				return err
+			}
+			version = n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("读取表 %s.%s 的结构版本失败: %v", schemaName, moduleName, err)
+	}
+	return version, nil
+}
+
+func setTableVersion(schemaName, moduleName string, version int) error {
+	err := BoltDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(versionsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(versionKey(schemaName, moduleName), []byte(strconv.Itoa(version)))
+	})
+	if err != nil {
+		return fmt.Errorf("记录表 %s.%s 的结构版本失败: %v", schemaName, moduleName, err)
+	}
+	return nil
+}
+
+// pendingMigrations 列出表尚未执行的结构变更，没有需要执行的语句的版本也会列出，执行时只更新版本号
+func pendingMigrations(schemaName, moduleName string) ([]PendingMigration, error) {
+	version, err := TableVersion(schemaName, moduleName)
+	if err != nil || version >= CurrentTableVersion {
+		return nil, err
+	}
+	layout, err := describeTable(schemaName, moduleName)
+	if err != nil {
+		return nil, err
+	}
+	table := tableIdent(schemaName, moduleName)
+	var pending []PendingMigration
+	for _, m := range migrations[version:] {
+		pending = append(pending, PendingMigration{
+			Schema:      schemaName,
+			Module:      moduleName,
+			Version:     m.Version,
+			Description: m.Description,
+			Statements:  m.Plan(table, layout),
+		})
+	}
+	return pending, nil
+}
+
+// migrateTable 按顺序执行表的待执行变更，每完成一个版本记录一次，失败时下次从该版本重试
+func migrateTable(schemaName, moduleName string, log *logrus.Logger) error {
+	pending, err := pendingMigrations(schemaName, moduleName)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		for _, stmt := range m.Statements {
+			if _, err := ClickHouseDB.Exec(stmt); err != nil {
+				log.Error(fmt.Sprintf("表 %s.%s 升级到版本 %d 失败: %v", schemaName, moduleName, m.Version, err))
+				return fmt.Errorf("表 %s.%s 升级到版本 %d 失败: %v", schemaName, moduleName, m.Version, err)
+			}
+		}
+		if err := setTableVersion(schemaName, moduleName, m.Version); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("表 %s.%s 已升级到版本 %d: %s", schemaName, moduleName, m.Version, m.Description))
+	}
+	return nil
+}
+
+// MigrateTables 对所有日志表执行待执行的结构变更；dryRun 为 true 时只列出不执行。
+// 单张表失败不影响其他表，错误汇总后返回，该表会在首次写入时再次尝试
+func MigrateTables(dryRun bool, log *logrus.Logger) ([]PendingMigration, error) {
+	var all []PendingMigration
+	var errs []string
+	err := forEachTable(log, func(schemaName, moduleName string) error {
+		pending, err := pendingMigrations(schemaName, moduleName)
+		if err == nil && !dryRun {
+			err = migrateTable(schemaName, moduleName, log)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			return nil
+		}
+		all = append(all, pending...)
+		return nil
+	})
+	if err != nil {
+		return all, err
+	}
+	if len(errs) > 0 {
+		return all, fmt.Errorf("%d 张表升级失败: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return all, nil
+}
+
+// forEachTable 遍历所有 schema 下的日志表
+func forEachTable(log *logrus.Logger, fn func(schemaName, moduleName string) error) error {
+	schemas, err := GetAllSchemas(log)
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		modules, err := listModules(schema.Name, log)
+		if err != nil {
+			return err
+		}
+		for _, module := range modules {
+			if ValidateModuleName(module) != nil {
+				continue
+			}
+			if err := fn(schema.Name, module); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
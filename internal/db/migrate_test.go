@@ -0,0 +1,64 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("第 %d 项迁移的版本号应为 %d，实际 %d", i, i+1, m.Version)
+		}
+	}
+	if CurrentTableVersion != len(migrations) {
+		t.Errorf("CurrentTableVersion 应等于迁移数量")
+	}
+
+	// 旧结构：所有列都是 String，没有跳数索引
+	legacy := tableLayout{columns: make(map[string]string), indexes: make(map[string]bool)}
+	for _, c := range tableColumns {
+		legacy.columns[c.name] = "String"
+	}
+	legacy.columns["operation_time"] = "DateTime"
+	delete(legacy.columns, "attributes")
+	stmts := migrations[0].Plan("`shop`.`log_shop_order`", legacy)
+	joined := strings.Join(stmts, "\n")
+	for _, part := range []string{
+		"ALTER TABLE `shop`.`log_shop_order` MODIFY COLUMN service LowCardinality(String)",
+		"ALTER TABLE `shop`.`log_shop_order` ADD INDEX IF NOT EXISTS idx_output_token lowerUTF8(output) TYPE tokenbf_v1(10240, 3, 0) GRANULARITY 4",
+	} {
+		if !strings.Contains(joined, part) {
+			t.Errorf("迁移语句缺少 %q:\n%s", part, joined)
+		}
+	}
+	// 依次执行所有版本后应补齐全部列和索引
+	for _, m := range migrations[1:] {
+		joined += "\n" + strings.Join(m.Plan("t", legacy), "\n")
+	}
+	for _, idx := range tableIndexes {
+		if !strings.Contains(joined, "ADD INDEX IF NOT EXISTS "+idx.name+" ") {
+			t.Errorf("没有迁移添加索引 %s", idx.name)
+		}
+	}
+	if !strings.Contains(joined, "ADD COLUMN IF NOT EXISTS attributes Map(String, String)") {
+		t.Errorf("没有迁移添加 attributes 列")
+	}
+	if strings.Contains(joined, "MODIFY COLUMN output") {
+		t.Errorf("不应修改非枚举列:\n%s", joined)
+	}
+
+	// 已是最新结构的表不需要执行任何语句
+	current := tableLayout{columns: make(map[string]string), indexes: make(map[string]bool)}
+	for _, c := range tableColumns {
+		current.columns[c.name] = c.typ
+	}
+	for _, idx := range tableIndexes {
+		current.indexes[idx.name] = true
+	}
+	for _, m := range migrations {
+		if stmts := m.Plan("t", current); len(stmts) != 0 {
+			t.Errorf("最新结构的表执行版本 %d 不应有语句: %v", m.Version, stmts)
+		}
+	}
+}
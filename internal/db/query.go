@@ -0,0 +1,279 @@
+package db
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// DefaultQueryLimit 查询日志时默认返回的最大条数
+const DefaultQueryLimit = 1000
+
+// MaxQueryLimit 单页允许的最大条数
+const MaxQueryLimit = 10000
+
+// recordColumns 查询返回的列，顺序与 scanRecords 中的 Scan 一致
+const recordColumns = "output, detail, error_info, service, client_ip, client_addr, log_level, operator_id, operator, operator_ip, operator_equipment, operator_company, operator_project, operation_time, push_type, attributes, receive_time, client_identity"
+
+// recordOrder 同一秒内的日志按内容哈希排序，保证分页时顺序稳定
+const recordOrder = "operation_time DESC, cityHash64(output, detail, error_info, client_addr) DESC"
+
+// MatchMode 文本搜索方式
+type MatchMode string
+
+const (
+	MatchSubstring MatchMode = "substring" // 子串匹配，不区分大小写
+	MatchToken     MatchMode = "token"     // 分词匹配，每个词都需作为完整 token 出现
+)
+
+// LogQuery 日志查询条件，Module 为空时查询 schema 下所有模块
+type LogQuery struct {
+	Schema          string
+	Module          string
+	From            time.Time // operation_time >= From，零值表示不限
+	To              time.Time // operation_time < To，零值表示不限
+	Levels          []string  // 大写，命中任意一个即可
+	Service         string
+	OperatorID      string
+	OperatorCompany string
+	OperatorProject string
+	PushType        string
+	ClientIdentity  string            // TLS 客户端证书的身份
+	Search          string            // 在 output / detail / error_info 中搜索
+	Attributes      map[string]string // attributes 中键的值需相等
+	HasAttributes   []string          // attributes 中需包含的键
+	Match           MatchMode
+	Cursor          *Cursor
+	Limit           int
+}
+
+// LogPage 一页查询结果，NextCursor 为空表示没有更多数据
+type LogPage struct {
+	Logs       []model.LogRecord
+	NextCursor string
+}
+
+// Cursor 基于 operation_time 的分页游标：下一页从 Time（含）开始，并跳过 Time 时刻已返回的 Skip 条
+type Cursor struct {
+	Time time.Time
+	Skip int
+}
+
+// Encode 编码为 URL 安全的字符串
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.Time.UnixNano(), c.Skip)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor 解析 Encode 生成的游标
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("无效的游标: %s", s)
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("无效的游标: %s", s)
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("无效的游标: %s", s)
+	}
+	skip, err := strconv.Atoi(parts[1])
+	if err != nil || skip < 0 {
+		return nil, fmt.Errorf("无效的游标: %s", s)
+	}
+	return &Cursor{Time: time.Unix(0, nanos), Skip: skip}, nil
+}
+
+func (q LogQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		return MaxQueryLimit
+	}
+	return q.Limit
+}
+
+func (q LogQuery) skip() int {
+	if q.Cursor == nil {
+		return 0
+	}
+	return q.Cursor.Skip
+}
+
+// whereClause 把过滤条件翻译为参数化的 WHERE 子句（不含 WHERE 关键字），没有条件时返回空字符串
+func (q LogQuery) whereClause() (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, values ...any) {
+		conds = append(conds, cond)
+		args = append(args, values...)
+	}
+
+	if !q.From.IsZero() {
+		add("operation_time >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		add("operation_time < ?", q.To)
+	}
+	if q.Cursor != nil {
+		add("operation_time <= ?", q.Cursor.Time)
+	}
+	if len(q.Levels) > 0 {
+		placeholders := make([]string, len(q.Levels))
+		values := make([]any, len(q.Levels))
+		for i, level := range q.Levels {
+			placeholders[i] = "?"
+			values[i] = level
+		}
+		add(fmt.Sprintf("log_level IN (%s)", strings.Join(placeholders, ", ")), values...)
+	}
+	for _, f := range []struct {
+		column, value string
+	}{
+		{"service", q.Service},
+		{"operator_id", q.OperatorID},
+		{"operator_company", q.OperatorCompany},
+		{"operator_project", q.OperatorProject},
+		{"push_type", q.PushType},
+		{"client_identity", q.ClientIdentity},
+	} {
+		if f.value != "" {
+			add(f.column+" = ?", f.value)
+		}
+	}
+	// 文本搜索统一在 lowerUTF8 后的列上进行，以命中 tableIndexes 中的 token / ngram 索引
+	keys := make([]string, 0, len(q.Attributes))
+	for key := range q.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add("attributes[?] = ?", key, q.Attributes[key])
+	}
+	for _, key := range q.HasAttributes {
+		add("mapContains(attributes, ?)", key)
+	}
+	if q.Search != "" {
+		if q.Match == MatchToken {
+			for _, token := range tokenize(q.Search) {
+				token = strings.ToLower(token)
+				add("(hasToken(lowerUTF8(output), ?) OR hasToken(lowerUTF8(detail), ?) OR hasToken(lowerUTF8(error_info), ?))", token, token, token)
+			}
+		} else {
+			pattern := "%" + likeEscaper.Replace(strings.ToLower(q.Search)) + "%"
+			add("(lowerUTF8(output) LIKE ? OR lowerUTF8(detail) LIKE ? OR lowerUTF8(error_info) LIKE ?)", pattern, pattern, pattern)
+		}
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Matches 判断记录是否满足过滤条件（不含游标），供内存存储使用，语义与 whereClause 一致
+func (q LogQuery) Matches(r model.LogRecord) bool {
+	if !q.From.IsZero() && r.OperationTime.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !r.OperationTime.Before(q.To) {
+		return false
+	}
+	if len(q.Levels) > 0 {
+		found := false
+		for _, level := range q.Levels {
+			if r.LogLevel == level {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if (q.Service != "" && r.Service != q.Service) ||
+		(q.OperatorID != "" && r.OperatorID != q.OperatorID) ||
+		(q.OperatorCompany != "" && r.OperatorCompany != q.OperatorCompany) ||
+		(q.OperatorProject != "" && r.OperatorProject != q.OperatorProject) ||
+		(q.PushType != "" && r.PushType != q.PushType) ||
+		(q.ClientIdentity != "" && r.ClientIdentity != q.ClientIdentity) {
+		return false
+	}
+	for key, value := range q.Attributes {
+		if actual, ok := r.Attributes[key]; !ok || actual != value {
+			return false
+		}
+	}
+	for _, key := range q.HasAttributes {
+		if _, ok := r.Attributes[key]; !ok {
+			return false
+		}
+	}
+	if q.Search != "" {
+		fields := []string{r.Output, r.Detail, r.ErrorInfo}
+		if q.Match == MatchToken {
+			for _, token := range tokenize(q.Search) {
+				if !containsToken(fields, token) {
+					return false
+				}
+			}
+		} else {
+			needle := strings.ToLower(q.Search)
+			found := false
+			for _, f := range fields {
+				if strings.Contains(strings.ToLower(f), needle) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// paginate 从按 operation_time 倒序、多取了一条的结果中截取一页并生成下一页游标
+func (q LogQuery) paginate(rows []model.LogRecord) LogPage {
+	limit := q.limit()
+	if len(rows) <= limit {
+		return LogPage{Logs: rows}
+	}
+	page := rows[:limit]
+	last := page[len(page)-1].OperationTime
+	skip := 0
+	for i := len(page) - 1; i >= 0 && page[i].OperationTime.Equal(last); i-- {
+		skip++
+	}
+	if q.Cursor != nil && q.Cursor.Time.Equal(last) {
+		skip += q.Cursor.Skip
+	}
+	return LogPage{Logs: page, NextCursor: Cursor{Time: last, Skip: skip}.Encode()}
+}
+
+// likeEscaper 转义 LIKE 模式中的通配符，使搜索内容按字面匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// tokenize 按非字母数字字符切分，与 ClickHouse hasToken 的分词规则一致
+func tokenize(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r < unicode.MaxASCII
+	})
+}
+
+func containsToken(fields []string, token string) bool {
+	for _, f := range fields {
+		for _, t := range tokenize(f) {
+			if strings.EqualFold(t, token) {
+				return true
+			}
+		}
+	}
+	return false
+}
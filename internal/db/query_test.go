@@ -0,0 +1,121 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+func TestWhereClause(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := LogQuery{
+		From:    from,
+		Levels:  []string{"ERROR", "WARN"},
+		Service: "order-svc",
+		Search:  "Timeout, db",
+		Match:   MatchToken,
+	}
+	where, args := q.whereClause()
+	want := "operation_time >= ? AND log_level IN (?, ?) AND service = ? AND " +
+		"(hasToken(lowerUTF8(output), ?) OR hasToken(lowerUTF8(detail), ?) OR hasToken(lowerUTF8(error_info), ?)) AND " +
+		"(hasToken(lowerUTF8(output), ?) OR hasToken(lowerUTF8(detail), ?) OR hasToken(lowerUTF8(error_info), ?))"
+	if where != want {
+		t.Errorf("WHERE 子句不符合预期:\n%s\n%s", where, want)
+	}
+	wantArgs := []any{from, "ERROR", "WARN", "order-svc", "timeout", "timeout", "timeout", "db", "db", "db"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("参数不符合预期: %v", args)
+	}
+
+	_, args = LogQuery{Search: "50%_Done"}.whereClause()
+	if args[0] != `%50\%\_done%` {
+		t.Errorf("子串搜索应转义通配符并转为小写，实际 %v", args[0])
+	}
+
+	if where, args := (LogQuery{}).whereClause(); where != "" || len(args) != 0 {
+		t.Errorf("没有条件时应返回空子句，实际 %q %v", where, args)
+	}
+}
+
+func TestMemoryQueryPagination(t *testing.T) {
+	store := NewMemoryStorage()
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var entries []*model.Log
+	// 5 秒内每秒 3 条，其中每秒第一条为 ERROR，保证同一秒内的日志会跨页
+	for i := 0; i < 15; i++ {
+		level := "INFO"
+		if i%3 == 0 {
+			level = "ERROR"
+		}
+		entries = append(entries, &model.Log{
+			LogBase:   model.LogBase{Output: string(rune('a' + i)), Service: "svc", LogLevel: level},
+			Schema:    "shop",
+			Module:    "order",
+			Timestamp: base.Add(time.Duration(i/3) * time.Second),
+		})
+	}
+	if err := store.InsertLogs(entries); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+
+	q := LogQuery{Schema: "shop", Module: "order", Limit: 4}
+	seen := make(map[string]bool)
+	var last time.Time
+	pages := 0
+	for {
+		page, err := store.QueryLogs(q)
+		if err != nil {
+			t.Fatalf("查询失败: %v", err)
+		}
+		pages++
+		for _, record := range page.Logs {
+			if seen[record.Output] {
+				t.Fatalf("日志 %s 重复返回", record.Output)
+			}
+			if !last.IsZero() && record.OperationTime.After(last) {
+				t.Fatalf("结果未按时间倒序")
+			}
+			seen[record.Output] = true
+			last = record.OperationTime
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		if q.Cursor, err = DecodeCursor(page.NextCursor); err != nil {
+			t.Fatalf("解析游标失败: %v", err)
+		}
+	}
+	if len(seen) != 15 || pages != 4 {
+		t.Errorf("预期分 4 页返回 15 条，实际 %d 页 %d 条", pages, len(seen))
+	}
+
+	page, _ := store.QueryLogs(LogQuery{Schema: "shop", Module: "order", Levels: []string{"ERROR"}, From: base.Add(time.Second)})
+	if len(page.Logs) != 4 || page.NextCursor != "" {
+		t.Errorf("按级别和时间过滤后预期 4 条，实际 %d 条", len(page.Logs))
+	}
+}
+
+func TestModulesQuery(t *testing.T) {
+	q := LogQuery{Service: "svc", Cursor: &Cursor{Time: time.Unix(100, 0), Skip: 2}, Limit: 10}
+	query, args := modulesQuery("shop", []string{"order", "pay"}, q, 11)
+	for _, part := range []string{
+		"FROM `shop`.`log_shop_order` WHERE operation_time <= ? AND service = ?",
+		" UNION ALL ",
+		"FROM `shop`.`log_shop_pay` WHERE",
+		"LIMIT 11 OFFSET 2",
+	} {
+		if !strings.Contains(query, part) {
+			t.Errorf("查询语句缺少 %q: %s", part, query)
+		}
+	}
+	want := []any{"order", time.Unix(100, 0), "svc", "pay", time.Unix(100, 0), "svc"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("参数不符合预期: %v", args)
+	}
+	if strings.Count(query, "?") != len(args) {
+		t.Errorf("占位符数量 %d 与参数数量 %d 不一致", strings.Count(query, "?"), len(args))
+	}
+}
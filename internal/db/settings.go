@@ -0,0 +1,147 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+// settingsBucket BoltDB 中保存 schema 配置的桶，key 为 schema 名称
+var settingsBucket = []byte("schema_settings")
+
+// MaxRetentionDays 保留期上限（约 100 年），防止 INTERVAL 溢出
+const MaxRetentionDays = 36500
+
+// SchemaSettings schema 级别的配置
+type SchemaSettings struct {
+	RetentionDays       int            `json:"retention_days"`                  // 日志保留天数，0 表示永久保留
+	ModuleRetentionDays map[string]int `json:"module_retention_days,omitempty"` // 按 module 覆盖保留天数，0 表示该 module 永久保留
+}
+
+// RetentionFor 返回 module 实际使用的保留天数
+func (s SchemaSettings) RetentionFor(module string) int {
+	if days, ok := s.ModuleRetentionDays[module]; ok {
+		return days
+	}
+	return s.RetentionDays
+}
+
+// Validate 校验保留天数和 module 名称
+func (s SchemaSettings) Validate() error {
+	var errs []error
+	check := func(name string, days int) {
+		if days < 0 || days > MaxRetentionDays {
+			errs = append(errs, fmt.Errorf("%s 的保留天数应在 0-%d 之间: %d", name, MaxRetentionDays, days))
+		}
+	}
+	check("schema", s.RetentionDays)
+	for module, days := range s.ModuleRetentionDays {
+		if err := ValidateModuleName(module); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		check("module "+module, days)
+	}
+	return errors.Join(errs...)
+}
+
+// ttlClause 建表时使用的 TTL 子句，永久保留时为空
+func ttlClause(days int) string {
+	if days <= 0 {
+		return ""
+	}
+	// TTL 表达式只能是 Date 或 DateTime，operation_time 为 DateTime64 时需要转换
+	return fmt.Sprintf("TTL toDateTime(operation_time) + INTERVAL %d DAY", days)
+}
+
+// GetSchemaSettings 读取 schema 配置，未设置时返回零值（永久保留）
+func GetSchemaSettings(schemaName string, log *logrus.Logger) (SchemaSettings, error) {
+	var settings SchemaSettings
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(settingsBucket)
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(schemaName)); v != nil {
+			return json.Unmarshal(v, &settings)
+		}
+		return nil
+	})
+	if err != nil {
+		return SchemaSettings{}, fmt.Errorf("读取 schema %s 配置失败: %v", schemaName, err)
+	}
+	return settings, nil
+}
+
+// UpdateSchemaSettings 保存 schema 配置，并对已存在的表执行 ALTER TABLE ... MODIFY TTL / REMOVE TTL。
+// 配置先写入 BoltDB，之后新建的表直接使用新的保留期；修改已有表失败时返回错误，重新提交即可重试。
+func UpdateSchemaSettings(schemaName string, settings SchemaSettings, log *logrus.Logger) error {
+	if err := ValidateSchemaName(schemaName); err != nil {
+		return err
+	}
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	err = BoltDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(settingsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(schemaName), data)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("保存 schema %s 配置失败: %v", schemaName, err))
+		return fmt.Errorf("保存 schema %s 配置失败: %v", schemaName, err)
+	}
+
+	modules, err := listModules(schemaName, log)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, module := range modules {
+		if err := applyRetention(schemaName, module, settings.RetentionFor(module), log); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyRetention 修改已有表的 TTL，days 为 0 时移除 TTL
+func applyRetention(schemaName, moduleName string, days int, log *logrus.Logger) error {
+	table := tableIdent(schemaName, moduleName)
+	query := fmt.Sprintf("ALTER TABLE %s MODIFY %s", table, ttlClause(days))
+	if days <= 0 {
+		// 没有 TTL 的表执行 REMOVE TTL 会报错，先确认
+		has, err := hasTableTTL(schemaName, moduleName)
+		if err != nil || !has {
+			return err
+		}
+		query = fmt.Sprintf("ALTER TABLE %s REMOVE TTL", table)
+	}
+	if _, err := ClickHouseDB.Exec(query); err != nil {
+		log.Error(fmt.Sprintf("修改表 %s.%s 的保留期失败: %v", schemaName, moduleName, err))
+		return fmt.Errorf("修改表 %s.%s 的保留期失败: %v", schemaName, moduleName, err)
+	}
+	log.Info(fmt.Sprintf("表 %s.%s 的保留期已更新为 %d 天", schemaName, moduleName, days))
+	return nil
+}
+
+// hasTableTTL 表是否设置了表级 TTL
+func hasTableTTL(schemaName, moduleName string) (bool, error) {
+	var createQuery string
+	err := ClickHouseDB.QueryRow("SELECT create_table_query FROM system.tables WHERE database = ? AND name = ?",
+		schemaName, tableName(schemaName, moduleName)).Scan(&createQuery)
+	if err != nil {
+		return false, fmt.Errorf("查询表 %s.%s 结构失败: %v", schemaName, moduleName, err)
+	}
+	return strings.Contains(createQuery, " TTL "), nil
+}
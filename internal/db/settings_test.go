@@ -0,0 +1,13 @@
+package db
+
+import "testing"
+
+func TestTTLClause(t *testing.T) {
+	settings := SchemaSettings{RetentionDays: 30, ModuleRetentionDays: map[string]int{"audit": 0}}
+	if got := ttlClause(settings.RetentionFor("order")); got != "TTL toDateTime(operation_time) + INTERVAL 30 DAY" {
+		t.Errorf("TTL 子句不符合预期: %s", got)
+	}
+	if got := ttlClause(settings.RetentionFor("audit")); got != "" {
+		t.Errorf("module 永久保留时不应有 TTL: %s", got)
+	}
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// SchemaInfo schema 名称及其 ID
+type SchemaInfo struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// Storage 日志存储，ingestion 服务和 HTTP 接口都通过它读写，便于替换实现
+type Storage interface {
+	// EnsureTable 确保 schema.module 对应的表存在
+	EnsureTable(schemaName, moduleName string) error
+	// TableExists 检查 schema.module 对应的表是否存在，不会创建
+	TableExists(schemaName, moduleName string) (bool, error)
+	// InsertLogs 批量写入日志，部分分组失败时返回 *InsertError
+	InsertLogs(entries []*model.Log) error
+	// GetOrCreateSchema 获取或创建 schema，返回 schema_id
+	GetOrCreateSchema(schemaName string) (string, error)
+	// SchemaExists 检查 schema 是否存在，不会创建
+	SchemaExists(schemaName string) (bool, error)
+	// GetSchemaNameByID 根据 schema_id 获取 schema 名称，未注册时返回空字符串
+	GetSchemaNameByID(schemaID string) (string, error)
+	// RebuildSchemaCache 异步重建 schema_id 的缓存
+	RebuildSchemaCache(schemaID string)
+	// ListSchemas 列出所有 schema
+	ListSchemas() ([]SchemaInfo, error)
+	// ListModules 列出 schema 下的所有模块
+	ListModules(schemaID string) ([]string, error)
+	// QueryLogs 按条件查询一页日志，按 operation_time 倒序
+	QueryLogs(q LogQuery) (LogPage, error)
+	// CreateAPIKey 为 schema 生成新的 ingest token，返回元数据和 token 明文
+	CreateAPIKey(schemaName, name string) (APIKey, string, error)
+	// ListAPIKeys 列出 schema 的所有 ingest token
+	ListAPIKeys(schemaName string) ([]APIKey, error)
+	// RevokeAPIKey 吊销 ingest token，不存在时返回 ErrAPIKeyNotFound
+	RevokeAPIKey(schemaName, id string) error
+	// LookupAPIKey 根据 token 的哈希查找，不存在时返回 nil
+	LookupAPIKey(hash string) (*APIKey, error)
+	// TouchAPIKeys 批量更新 token 的最后使用时间，key 为 token 的哈希
+	TouchAPIKeys(lastUsed map[string]time.Time) error
+	// GroupLogs 按字段统计满足条件的日志数量，by 为列名或 attributes.<key>，按数量倒序
+	GroupLogs(q LogQuery, by string, limit int) ([]GroupCount, error)
+	// GetSchemaSettings 读取 schema 配置，未设置时返回零值
+	GetSchemaSettings(schemaName string) (SchemaSettings, error)
+	// UpdateSchemaSettings 保存 schema 配置并应用到已存在的表
+	UpdateSchemaSettings(schemaName string, settings SchemaSettings) error
+}
+
+// ClickHouseStorage 基于 ClickHouse（日志）和 BoltDB（schema 缓存）的 Storage 实现
+type ClickHouseStorage struct {
+	log *logrus.Logger
+}
+
+// NewClickHouseStorage 创建 ClickHouse 存储，调用前需先执行 InitClickHouse 和 InitBolt
+func NewClickHouseStorage(log *logrus.Logger) *ClickHouseStorage {
+	return &ClickHouseStorage{log: log}
+}
+
+func (s *ClickHouseStorage) EnsureTable(schemaName, moduleName string) error {
+	return EnsureTable(schemaName, moduleName, s.log)
+}
+
+func (s *ClickHouseStorage) TableExists(schemaName, moduleName string) (bool, error) {
+	return TableExists(schemaName, moduleName)
+}
+
+func (s *ClickHouseStorage) InsertLogs(entries []*model.Log) error {
+	return InsertLogs(entries, s.log)
+}
+
+func (s *ClickHouseStorage) GetOrCreateSchema(schemaName string) (string, error) {
+	return GetOrCreateSchema(schemaName, s.log)
+}
+
+func (s *ClickHouseStorage) SchemaExists(schemaName string) (bool, error) {
+	return DatabaseExists(schemaName, s.log)
+}
+
+func (s *ClickHouseStorage) GetSchemaNameByID(schemaID string) (string, error) {
+	return GetSchemaNameByID(schemaID, s.log)
+}
+
+func (s *ClickHouseStorage) RebuildSchemaCache(schemaID string) {
+	RebuildSchemaCache(schemaID, s.log)
+}
+
+func (s *ClickHouseStorage) ListSchemas() ([]SchemaInfo, error) {
+	return GetAllSchemas(s.log)
+}
+
+func (s *ClickHouseStorage) ListModules(schemaID string) ([]string, error) {
+	return GetModulesBySchemaId(schemaID, s.log)
+}
+
+func (s *ClickHouseStorage) CreateAPIKey(schemaName, name string) (APIKey, string, error) {
+	return CreateAPIKey(schemaName, name, s.log)
+}
+
+func (s *ClickHouseStorage) ListAPIKeys(schemaName string) ([]APIKey, error) {
+	return ListAPIKeys(schemaName, s.log)
+}
+
+func (s *ClickHouseStorage) RevokeAPIKey(schemaName, id string) error {
+	return RevokeAPIKey(schemaName, id, s.log)
+}
+
+func (s *ClickHouseStorage) LookupAPIKey(hash string) (*APIKey, error) {
+	return LookupAPIKey(hash, s.log)
+}
+
+func (s *ClickHouseStorage) TouchAPIKeys(lastUsed map[string]time.Time) error {
+	return TouchAPIKeys(lastUsed, s.log)
+}
+
+func (s *ClickHouseStorage) GetSchemaSettings(schemaName string) (SchemaSettings, error) {
+	return GetSchemaSettings(schemaName, s.log)
+}
+
+func (s *ClickHouseStorage) UpdateSchemaSettings(schemaName string, settings SchemaSettings) error {
+	return UpdateSchemaSettings(schemaName, settings, s.log)
+}
+
+func (s *ClickHouseStorage) QueryLogs(q LogQuery) (LogPage, error) {
+	// 多取一条用于判断是否还有下一页
+	fetch := q.limit() + 1
+	if err := ValidateSchemaName(q.Schema); err != nil {
+		return LogPage{}, err
+	}
+	if q.Module != "" {
+		if err := ValidateModuleName(q.Module); err != nil {
+			return LogPage{}, err
+		}
+		logs, err := queryTable(tableIdent(q.Schema, q.Module), q, fetch, s.log)
+		if err != nil {
+			return LogPage{}, err
+		}
+		return q.paginate(logs), nil
+	}
+
+	modules, err := listModules(q.Schema, s.log)
+	if err != nil {
+		return LogPage{}, err
+	}
+	if len(modules) == 0 {
+		return LogPage{}, nil
+	}
+	logs, err := queryModules(q.Schema, modules, q, fetch, s.log)
+	if err != nil {
+		return LogPage{}, err
+	}
+	return q.paginate(logs), nil
+}
+
+func (s *ClickHouseStorage) GroupLogs(q LogQuery, by string, limit int) ([]GroupCount, error) {
+	if err := ValidateSchemaName(q.Schema); err != nil {
+		return nil, err
+	}
+	modules := []string{q.Module}
+	if q.Module != "" {
+		if err := ValidateModuleName(q.Module); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if modules, err = listModules(q.Schema, s.log); err != nil {
+			return nil, err
+		}
+		if len(modules) == 0 {
+			return []GroupCount{}, nil
+		}
+	}
+
+	query, args, err := groupQuery(q.Schema, modules, q, by, limit)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := ClickHouseDB.Query(query, args...)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("分组统计 schema %s 日志失败: %v", q.Schema, err))
+		return nil, fmt.Errorf("分组统计日志失败: %v", err)
+	}
+	defer rows.Close()
+	groups := []GroupCount{}
+	for rows.Next() {
+		var g GroupCount
+		if err := rows.Scan(&g.Value, &g.Count); err != nil {
+			return nil, fmt.Errorf("解析分组统计结果失败: %v", err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// queryTable 按条件查询单张表的日志
+func queryTable(tableName string, q LogQuery, limit int, log *logrus.Logger) ([]model.LogRecord, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s", recordColumns, tableName)
+	where, args := q.whereClause()
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += fmt.Sprintf(" ORDER BY %s LIMIT %d OFFSET %d", recordOrder, limit, q.skip())
+	rows, err := ClickHouseDB.Query(query, args...)
+	if err != nil {
+		log.Error(fmt.Sprintf("查询表 %s 日志失败: %v", tableName, err))
+		return nil, fmt.Errorf("查询日志失败: %v", err)
+	}
+	defer rows.Close()
+	return scanRecords(rows, false, log)
+}
+
+// queryModules 用一条 UNION ALL 查询 schema 下多个模块的日志，排序和分页都在 ClickHouse 中完成
+func queryModules(schemaName string, modules []string, q LogQuery, limit int, log *logrus.Logger) ([]model.LogRecord, error) {
+	query, args := modulesQuery(schemaName, modules, q, limit)
+	rows, err := ClickHouseDB.Query(query, args...)
+	if err != nil {
+		log.Error(fmt.Sprintf("查询 schema %s 日志失败: %v", schemaName, err))
+		return nil, fmt.Errorf("查询日志失败: %v", err)
+	}
+	defer rows.Close()
+	return scanRecords(rows, true, log)
+}
+
+// modulesQuery 生成跨模块查询语句，每个子查询都带上过滤条件，并以参数形式附加模块名
+func modulesQuery(schemaName string, modules []string, q LogQuery, limit int) (string, []any) {
+	where, whereArgs := q.whereClause()
+	if where != "" {
+		where = " WHERE " + where
+	}
+	parts := make([]string, len(modules))
+	var args []any
+	for i, module := range modules {
+		parts[i] = fmt.Sprintf("SELECT %s, ? AS module FROM %s%s", recordColumns, tableIdent(schemaName, module), where)
+		args = append(args, module)
+		args = append(args, whereArgs...)
+	}
+	query := fmt.Sprintf("SELECT %s, module FROM (%s) ORDER BY %s, module LIMIT %d OFFSET %d",
+		recordColumns, strings.Join(parts, " UNION ALL "), recordOrder, limit, q.skip())
+	return query, args
+}
+
+// scanRecords 解析 recordColumns 对应的结果集，withModule 为 true 时最后一列为模块名
+func scanRecords(rows *sql.Rows, withModule bool, log *logrus.Logger) ([]model.LogRecord, error) {
+	var logs []model.LogRecord
+	for rows.Next() {
+		var entry model.LogRecord
+		dest := []any{&entry.Output, &entry.Detail, &entry.ErrorInfo, &entry.Service,
+			&entry.ClientIP, &entry.ClientAddr, &entry.LogLevel, &entry.OperatorID, &entry.Operator,
+			&entry.OperatorIP, &entry.OperatorEquipment, &entry.OperatorCompany, &entry.OperatorProject,
+			&entry.OperationTime, &entry.PushType, &entry.Attributes, &entry.ReceiveTime, &entry.ClientIdentity}
+		if withModule {
+			dest = append(dest, &entry.Module)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			log.Error(fmt.Sprintf("日志解析失败: %v", err))
+			return nil, fmt.Errorf("日志解析失败: %v", err)
+		}
+		logs = append(logs, entry)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error(fmt.Sprintf("读取查询结果失败: %v", err))
+		return nil, fmt.Errorf("读取查询结果失败: %v", err)
+	}
+	return logs, nil
+}
@@ -0,0 +1,194 @@
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/syslog"
+)
+
+// defaultLevel GELF 规范中 level 缺省时的值（ALERT）
+const defaultLevel = 1
+
+// Message 解析后的 GELF 消息
+type Message struct {
+	Version      string
+	Host         string
+	ShortMessage string
+	FullMessage  string
+	Timestamp    time.Time         // 消息中没有时为零值
+	Level        int               // syslog severity
+	Fields       map[string]string // 附加字段，键去掉了前缀下划线
+}
+
+// Parse 解析一条 GELF 1.1 消息（解压和分片重组之后的 JSON）。
+// 附加字段的值统一转换为字符串；GELF 1.0 中已废弃的 facility、file、line 也作为附加字段
+func Parse(data []byte) (*Message, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("GELF 消息不是 JSON 对象: %v", err)
+	}
+
+	msg := &Message{Level: defaultLevel, Fields: make(map[string]string)}
+	for key, value := range raw {
+		var err error
+		switch key {
+		case "version":
+			msg.Version, err = stringField(key, value)
+		case "host":
+			msg.Host, err = stringField(key, value)
+		case "short_message":
+			msg.ShortMessage, err = stringField(key, value)
+		case "full_message":
+			msg.FullMessage, err = stringField(key, value)
+		case "timestamp":
+			msg.Timestamp, err = timestampField(value)
+		case "level":
+			msg.Level, err = levelField(value)
+		case "facility", "file", "line":
+			msg.Fields[key] = fieldValue(value)
+		default:
+			// _id 是规范保留的字段名，忽略
+			if name, ok := strings.CutPrefix(key, "_"); ok && name != "id" && value != nil {
+				msg.Fields[name] = fieldValue(value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if msg.Version != "" && msg.Version != "1.0" && msg.Version != "1.1" {
+		return nil, fmt.Errorf("不支持的 GELF 版本: %s", msg.Version)
+	}
+	if msg.Host == "" {
+		return nil, fmt.Errorf("GELF 消息缺少 host 字段")
+	}
+	if msg.ShortMessage == "" {
+		return nil, fmt.Errorf("GELF 消息缺少 short_message 字段")
+	}
+	return msg, nil
+}
+
+func stringField(key string, value any) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("GELF 字段 %s 应为字符串", key)
+	}
+	return s, nil
+}
+
+// timestampField 解析 Unix 秒（小数部分为毫秒等更细的精度）
+func timestampField(value any) (time.Time, error) {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return time.Time{}, fmt.Errorf("GELF 字段 timestamp 应为数字")
+	}
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) {
+		return time.Time{}, fmt.Errorf("GELF 字段 timestamp 无效: %s", s)
+	}
+	return time.UnixMicro(int64(math.Round(seconds * 1e6))), nil
+}
+
+func levelField(value any) (int, error) {
+	if value == nil {
+		return defaultLevel, nil
+	}
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("GELF 字段 level 应为数字")
+	}
+	level, err := strconv.Atoi(n.String())
+	if err != nil || level < 0 || level > 7 {
+		return 0, fmt.Errorf("GELF 字段 level 应为 0 到 7: %s", n)
+	}
+	return level, nil
+}
+
+// fieldValue 把附加字段的值转换为字符串，对象和数组保留 JSON 形式
+func fieldValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// ToLog 转换为 model.Log：short_message 作为 output，full_message 作为 detail，level 按 syslog severity 映射为日志级别。
+// schema 只取附加字段 _schema，由 submit 按 ingest token 或监听配置校验；module 优先取 _module，没有时使用监听配置的默认值；
+// service 依次取 _service、_container_name（Docker GELF 日志驱动）和 host。
+// 其余附加字段和 host 写入 attributes
+func ToLog(msg *Message, l Listener, remoteAddr string) *model.Log {
+	clientIP := "0.0.0.0"
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		clientIP = host
+	}
+
+	attrs := make(map[string]string, len(msg.Fields)+1)
+	for key, value := range msg.Fields {
+		attrs[key] = value
+	}
+	take := func(key, fallback string) string {
+		value, ok := attrs[key]
+		delete(attrs, key)
+		if !ok || value == "" {
+			return fallback
+		}
+		return value
+	}
+	schema := take("schema", "")
+	module := take("module", l.Module)
+	service := take("service", "")
+	if service == "" {
+		service = attrs["container_name"]
+	}
+	if service == "" {
+		service = msg.Host
+	}
+	if _, ok := attrs["host"]; !ok {
+		attrs["host"] = msg.Host
+	}
+
+	return &model.Log{
+		LogBase: model.LogBase{
+			Output:     msg.ShortMessage,
+			Detail:     msg.FullMessage,
+			Service:    service,
+			ClientIP:   clientIP,
+			ClientAddr: remoteAddr,
+			LogLevel:   syslog.LogLevel(msg.Level),
+		},
+		Schema:      model.LogSchema(schema),
+		Module:      model.LogModule(module),
+		PushType:    model.PushTypeGELF,
+		Timestamp:   msg.Timestamp, // 消息没有时间时由流水线使用接收时间
+		ReceiveTime: time.Now(),
+		Attributes:  attrs,
+	}
+}
@@ -0,0 +1,214 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/udp"
+)
+
+func TestParse(t *testing.T) {
+	raw := `{"version":"1.1","host":"docker01","short_message":"request failed","full_message":"stack\ntrace",
+		"timestamp":1740824430.25,"level":3,"_container_name":"order-api","_status":502,"_ok":false,"_id":"x","line":42}`
+	msg, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	want := &Message{
+		Version:      "1.1",
+		Host:         "docker01",
+		ShortMessage: "request failed",
+		FullMessage:  "stack\ntrace",
+		Timestamp:    time.Unix(1740824430, 250000000),
+		Level:        3,
+		Fields:       map[string]string{"container_name": "order-api", "status": "502", "ok": "false", "line": "42"},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("解析结果不符合预期:\n%+v\n%+v", msg, want)
+	}
+
+	msg, err = Parse([]byte(`{"version":"1.1","host":"h","short_message":"m"}`))
+	if err != nil || msg.Level != defaultLevel || !msg.Timestamp.IsZero() {
+		t.Errorf("缺省字段解析有误: %+v, %v", msg, err)
+	}
+
+	for _, bad := range []string{
+		`not json`,
+		`{"version":"2.0","host":"h","short_message":"m"}`,
+		`{"version":"1.1","short_message":"m"}`,
+		`{"version":"1.1","host":"h"}`,
+		`{"version":"1.1","host":"h","short_message":"m","level":9}`,
+		`{"version":"1.1","host":"h","short_message":"m","timestamp":"soon"}`,
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("%s 应解析失败", bad)
+		}
+	}
+}
+
+func TestToLog(t *testing.T) {
+	l := Listener{Network: "udp", Addr: ":12201", Schema: "infra", Module: "docker"}
+	msg := &Message{Host: "docker01", ShortMessage: "hi", Level: 4, Fields: map[string]string{"container_name": "order-api", "module": "nginx"}}
+	entry := ToLog(msg, l, "10.0.0.8:40000")
+	if entry.Schema != "" || entry.Module != "nginx" || entry.Service != "order-api" || entry.LogLevel != "WARN" ||
+		entry.ClientIP != "10.0.0.8" || entry.PushType != model.PushTypeGELF {
+		t.Errorf("转换结果不符合预期: %+v", entry)
+	}
+	if want := map[string]string{"container_name": "order-api", "host": "docker01"}; !reflect.DeepEqual(entry.Attributes, want) {
+		t.Errorf("attributes 不符合预期: %v", entry.Attributes)
+	}
+
+	msg.Fields = map[string]string{"schema": "shop", "service": "billing"}
+	if entry := ToLog(msg, l, ""); entry.Schema != "shop" || entry.Module != "docker" || entry.Service != "billing" {
+		t.Errorf("_schema 应作为请求的 schema，_service 应覆盖默认值: %+v", entry)
+	}
+}
+
+func newTestPipeline(t *testing.T) (*pipeline.Pipeline, *db.MemoryStorage, *logrus.Logger) {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	store := db.NewMemoryStorage()
+	for _, schema := range []string{"shop", "pay"} {
+		if _, err := store.GetOrCreateSchema(schema); err != nil {
+			t.Fatalf("创建 schema 失败: %v", err)
+		}
+	}
+	return pipeline.New(store, pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour}, log), store, log
+}
+
+func TestHandleConnection(t *testing.T) {
+	p, store, log := newTestPipeline(t)
+	l := Listener{Network: "tcp", Addr: ":0", Schema: "shop", Module: "docker"}
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleConnection(l, server, time.Second, make(chan struct{}), p, log)
+	}()
+	client.Write([]byte(`{"version":"1.1","host":"h","short_message":"first"}` + "\x00" +
+		`{"version":"1.1","host":"h"}` + "\x00" +
+		`{"version":"1.1","host":"h","short_message":"last"}`))
+	client.Close()
+	<-done
+
+	p.Flush()
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "docker"})
+	if len(page.Logs) != 2 {
+		t.Errorf("应写入 2 条日志，实际 %d 条", len(page.Logs))
+	}
+	if dropped := p.Stats().Transports[model.PushTypeGELF].Dropped[dropInvalidMessage]; dropped != 1 {
+		t.Errorf("无效消息应计入丢弃统计，实际 %d", dropped)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	// 消息长度超过读缓冲区时分多次读取，按 max 限制整条消息
+	long := strings.Repeat("a", 40)
+	r := bufio.NewReaderSize(strings.NewReader(long+"\x00"+long+"b\x00"), 16)
+	if frame, err := readMessage(r, 40); err != nil || string(frame) != long+"\x00" {
+		t.Errorf("读取消息失败: %q %v", frame, err)
+	}
+	if _, err := readMessage(r, 40); !errors.Is(err, udp.ErrMessageTooLarge) {
+		t.Errorf("超过大小限制应返回 ErrMessageTooLarge，实际 %v", err)
+	}
+}
+
+func TestHandleConnectionIdleTimeout(t *testing.T) {
+	p, _, log := newTestPipeline(t)
+	l := Listener{Network: "tcp", Addr: ":0", Schema: "shop", Module: "docker"}
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleConnection(l, server, 50*time.Millisecond, make(chan struct{}), p, log)
+	}()
+
+	// 只发送半条消息的慢速客户端也会在超时后断开
+	client.Write([]byte(`{"version":"1.1",`))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("空闲超时后应断开连接")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	p, store, log := newTestPipeline(t)
+	_, token, err := store.CreateAPIKey("shop", "gelf")
+	if err != nil {
+		t.Fatalf("创建 ingest token 失败: %v", err)
+	}
+	pinned := Handler(Listener{Network: "http", Addr: ":0", Schema: "shop", Module: "docker"}, p, log)
+	tokenOnly := Handler(Listener{Network: "http", Addr: ":0", Module: "docker"}, p, log)
+
+	var body bytes.Buffer
+	w := gzip.NewWriter(&body)
+	w.Write([]byte(`{"version":"1.1","host":"h","short_message":"compressed"}`))
+	w.Close()
+	for _, tc := range []struct {
+		name   string
+		h      http.Handler
+		method string
+		body   []byte
+		apiKey string
+		want   int
+	}{
+		{"监听配置的 schema", pinned, http.MethodPost, body.Bytes(), "", http.StatusAccepted},
+		{"没有 token 不能写入其他 schema", pinned, http.MethodPost, []byte(`{"version":"1.1","host":"h","short_message":"m","_schema":"pay"}`), "", http.StatusForbidden},
+		{"监听未配置 schema 时需要 token", tokenOnly, http.MethodPost, []byte(`{"version":"1.1","host":"h","short_message":"m","_schema":"shop"}`), "", http.StatusBadRequest},
+		{"请求头携带 token", tokenOnly, http.MethodPost, []byte(`{"version":"1.1","host":"h","short_message":"header"}`), token, http.StatusAccepted},
+		{"_token 附加字段", tokenOnly, http.MethodPost, []byte(`{"version":"1.1","host":"h","short_message":"field","_token":"` + token + `"}`), "", http.StatusAccepted},
+		{"token 不能写入其他 schema", tokenOnly, http.MethodPost, []byte(`{"version":"1.1","host":"h","short_message":"m","_schema":"pay"}`), token, http.StatusForbidden},
+		{"无效的 token", pinned, http.MethodPost, []byte(`{"version":"1.1","host":"h","short_message":"m"}`), db.APIKeyPrefix + "bad", http.StatusUnauthorized},
+		{"无效的消息", pinned, http.MethodPost, []byte(`{"host":`), "", http.StatusBadRequest},
+		{"只支持 POST", pinned, http.MethodGet, nil, "", http.StatusMethodNotAllowed},
+	} {
+		req := httptest.NewRequest(tc.method, HTTPPath, bytes.NewReader(tc.body))
+		if tc.apiKey != "" {
+			req.Header.Set(APIKeyHeader, tc.apiKey)
+		}
+		rec := httptest.NewRecorder()
+		tc.h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: 应返回 %d，实际 %d: %s", tc.name, tc.want, rec.Code, rec.Body)
+		}
+	}
+
+	p.Flush()
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "docker"})
+	if len(page.Logs) != 3 {
+		t.Fatalf("应写入 3 条日志，实际 %+v", page.Logs)
+	}
+	for _, entry := range page.Logs {
+		if _, ok := entry.Attributes["token"]; ok {
+			t.Errorf("_token 不应写入 attributes: %v", entry.Attributes)
+		}
+	}
+	if page, _ := store.QueryLogs(db.LogQuery{Schema: "pay", Module: "docker"}); len(page.Logs) != 0 {
+		t.Errorf("不应写入其他 schema，实际 %+v", page.Logs)
+	}
+
+	// 开启 require_api_key 后没有 token 的消息被拒绝
+	p.Reconfigure(pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour, RequireAPIKey: true})
+	rec := httptest.NewRecorder()
+	pinned.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, HTTPPath, bytes.NewReader(body.Bytes())))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("缺少 token 应返回 401，实际 %d", rec.Code)
+	}
+}
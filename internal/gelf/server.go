@@ -0,0 +1,345 @@
+package gelf
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/udp"
+)
+
+// maxMessageSize 解压和分片重组后单条消息的最大长度
+const maxMessageSize = udp.DefaultMaxMessageSize
+
+// idleTimeout TCP 连接在该时间内没有收到数据时断开，避免空闲或慢速客户端一直占用连接
+const idleTimeout = 5 * time.Minute
+
+// HTTPPath HTTP 监听接收 GELF 消息的路径
+const HTTPPath = "/gelf"
+
+// APIKeyHeader HTTP 监听携带 ingest token 的请求头，与 HTTP 接口一致
+const APIKeyHeader = "X-API-Key"
+
+// dropInvalidMessage 无法解析的 GELF 消息计入丢弃统计的原因
+const dropInvalidMessage = "invalid_message"
+
+// Listener 一个 GELF 监听。消息携带 ingest token 时写入 token 所属的 schema，否则只能写入 Schema；
+// Module 是默认值，消息中带 _module 附加字段时以附加字段为准
+type Listener struct {
+	Network string `yaml:"network"` // udp、tcp 或 http
+	Addr    string `yaml:"addr"`
+	Schema  string `yaml:"schema"`
+	Module  string `yaml:"module"`
+}
+
+func (l Listener) String() string {
+	return fmt.Sprintf("gelf %s://%s -> %s.%s", l.Network, l.Addr, l.Schema, l.Module)
+}
+
+// Validate 校验监听配置，schema 为空时只接收携带 ingest token 的消息，module 可以为空
+func (l Listener) Validate() error {
+	if l.Network != "udp" && l.Network != "tcp" && l.Network != "http" {
+		return fmt.Errorf("GELF 监听协议只支持 udp、tcp 和 http: %s", l.Network)
+	}
+	if l.Addr == "" {
+		return fmt.Errorf("GELF 监听 %s 缺少 addr", l)
+	}
+	if l.Schema != "" {
+		if err := db.ValidateSchemaName(l.Schema); err != nil {
+			return fmt.Errorf("GELF 监听 %s: %v", l, err)
+		}
+	}
+	if l.Module != "" {
+		if err := db.ValidateModuleName(l.Module); err != nil {
+			return fmt.Errorf("GELF 监听 %s: %v", l, err)
+		}
+	}
+	return nil
+}
+
+// StartGELFServer 按 l.Network 启动监听，阻塞直到 stopChan 关闭
+func StartGELFServer(l Listener, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	switch l.Network {
+	case "udp":
+		serveUDP(l, stopChan, p, log)
+	case "tcp":
+		serveTCP(l, stopChan, p, log)
+	case "http":
+		serveHTTP(l, stopChan, p, log)
+	default:
+		log.Error(fmt.Sprintf("不支持的 GELF 协议: %s", l.Network))
+	}
+}
+
+// serveUDP 接收 GELF UDP 数据包，支持分片和 gzip / zlib 压缩
+func serveUDP(l Listener, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	conn, err := net.ListenPacket("udp", l.Addr)
+	if err != nil {
+		log.Error(fmt.Sprintf("GELF UDP 监听 %s 失败: %v", l.Addr, err))
+		return
+	}
+	defer conn.Close()
+	log.Info(fmt.Sprintf("GELF 服务跑起来了: %s", l))
+
+	go func() {
+		<-stopChan
+		conn.Close()
+	}()
+
+	asm := udp.NewAssembler(udp.DefaultChunkTimeout, maxMessageSize, udp.DefaultMaxPendingBytes)
+	buf := make([]byte, udp.MaxDatagramSize)
+	lastExpire := time.Now()
+	for {
+		if time.Since(lastExpire) > time.Second {
+			for n := asm.Expire(time.Now()); n > 0; n-- {
+				p.Drop(model.PushTypeGELF, udp.DropIncompleteMessage)
+			}
+			lastExpire = time.Now()
+		}
+		// 定期超时返回，使没有新数据包时也能清理超时未收齐的分片
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			select {
+			case <-stopChan:
+				log.Info(fmt.Sprintf("收到停止信号，关闭 GELF 服务: %s", l))
+				return
+			default:
+			}
+			log.Error(fmt.Sprintf("读取 GELF UDP 数据失败: %v", err))
+			continue
+		}
+		data := buf[:n]
+		if udp.IsChunk(data) {
+			message, reason := asm.Add(addr.String(), append([]byte(nil), data...), time.Now())
+			if reason != "" {
+				p.Drop(model.PushTypeGELF, reason)
+				log.Error(fmt.Sprintf("丢弃来自 %s 的 GELF 分片: %s", addr, reason))
+			}
+			if message == nil {
+				continue
+			}
+			data = message
+		}
+		submit(l, data, addr.String(), "", p, log)
+	}
+}
+
+func serveTCP(l Listener, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	listener, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		log.Error(fmt.Sprintf("GELF TCP 监听 %s 失败: %v", l.Addr, err))
+		return
+	}
+	defer listener.Close()
+	log.Info(fmt.Sprintf("GELF 服务跑起来了: %s", l))
+
+	go func() {
+		<-stopChan
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stopChan:
+				log.Info(fmt.Sprintf("收到停止信号，关闭 GELF 服务: %s", l))
+				return
+			default:
+			}
+			log.Error(fmt.Sprintf("接受 GELF TCP 连接失败: %v", err))
+			continue
+		}
+		go handleConnection(l, conn, idleTimeout, stopChan, p, log)
+	}
+}
+
+// handleConnection 读取以 \0 分隔的 GELF 消息，单条消息超过 maxMessageSize 或超过 idleTimeout 没有数据时断开连接
+func handleConnection(l Listener, conn net.Conn, idleTimeout time.Duration, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+	go func() {
+		select {
+		case <-stopChan:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	remoteAddr := conn.RemoteAddr().String()
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		frame, err := readMessage(reader, maxMessageSize)
+		if errors.Is(err, udp.ErrMessageTooLarge) {
+			p.Drop(model.PushTypeGELF, udp.DropMessageTooLarge)
+			log.Error(fmt.Sprintf("GELF TCP 消息超过 %d 字节，断开来自 %s 的连接", maxMessageSize, remoteAddr))
+			return
+		}
+		if len(frame) > 0 && frame[len(frame)-1] == 0 {
+			frame = frame[:len(frame)-1]
+		}
+		// 连接关闭前最后一条消息可能没有 \0 结尾
+		if len(frame) > 0 && (err == nil || errors.Is(err, io.EOF)) {
+			submit(l, frame, remoteAddr, "", p, log)
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Info(fmt.Sprintf("GELF TCP 连接 %s 超过 %s 没有数据，断开连接", remoteAddr, idleTimeout))
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Error(fmt.Sprintf("读取 GELF TCP 数据失败: %v，来自 %s", err, remoteAddr))
+			}
+			return
+		}
+	}
+}
+
+// readMessage 读取一条以 \0 结尾的消息（含 \0），超过 max 字节时返回 udp.ErrMessageTooLarge。
+// 出错时返回已读取的部分，连接关闭前最后一条消息可能没有 \0 结尾
+func readMessage(r *bufio.Reader, max int) ([]byte, error) {
+	var frame []byte
+	for {
+		chunk, err := r.ReadSlice(0)
+		if len(frame)+len(chunk) > max+1 {
+			return nil, udp.ErrMessageTooLarge
+		}
+		frame = append(frame, chunk...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return frame, err
+		}
+	}
+}
+
+func serveHTTP(l Listener, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	listener, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		log.Error(fmt.Sprintf("GELF HTTP 监听 %s 失败: %v", l.Addr, err))
+		return
+	}
+	server := &http.Server{Handler: Handler(l, p, log), ReadHeaderTimeout: 10 * time.Second}
+	log.Info(fmt.Sprintf("GELF 服务跑起来了: %s", l))
+
+	go func() {
+		<-stopChan
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error(fmt.Sprintf("GELF HTTP 服务异常退出: %v", err))
+		return
+	}
+	log.Info(fmt.Sprintf("收到停止信号，关闭 GELF 服务: %s", l))
+}
+
+// Handler 接收 POST HTTPPath 的 GELF 消息，请求体可以是 gzip / zlib 压缩的 JSON，ingest token 放在 APIKeyHeader 请求头或 _token 附加字段中。
+// 接收后返回 202；消息无效返回 400，token 无效返回 401，写入其他 schema 返回 403，超过大小限制返回 413，
+// 缓冲区满或限流时返回 503 / 429，客户端可稍后重试
+func Handler(l Listener, p *pipeline.Pipeline, log *logrus.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HTTPPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "只支持 POST", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("读取请求失败: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(data) > maxMessageSize {
+			p.Drop(model.PushTypeGELF, udp.DropMessageTooLarge)
+			http.Error(w, fmt.Sprintf("消息超过 %d 字节", maxMessageSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := submit(l, data, r.RemoteAddr, r.Header.Get(APIKeyHeader), p, log); err != nil {
+			http.Error(w, err.Error(), statusOf(err))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return mux
+}
+
+func statusOf(err error) int {
+	if errors.Is(err, udp.ErrMessageTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	var rejectErr *pipeline.RejectError
+	if errors.As(err, &rejectErr) {
+		switch rejectErr.Reason {
+		case pipeline.ReasonBufferFull, pipeline.ReasonSpoolFailed:
+			return http.StatusServiceUnavailable
+		case pipeline.ReasonRateLimited:
+			return http.StatusTooManyRequests
+		case pipeline.ReasonUnauthorized:
+			return http.StatusUnauthorized
+		case pipeline.ReasonForbidden:
+			return http.StatusForbidden
+		}
+	}
+	return http.StatusBadRequest
+}
+
+// submit 解压、解析 GELF 消息，校验 ingest token 后提交到流水线，解压和解析失败时计入丢弃统计。
+// token 为空时取 _token 附加字段；没有 token 时（未开启 require_api_key）只能写入监听配置的 schema
+func submit(l Listener, data []byte, remoteAddr, token string, p *pipeline.Pipeline, log *logrus.Logger) error {
+	data, err := udp.Decompress(data, maxMessageSize)
+	if err != nil {
+		reason := udp.DropDecompressFailed
+		if errors.Is(err, udp.ErrMessageTooLarge) {
+			reason = udp.DropMessageTooLarge
+		}
+		p.Drop(model.PushTypeGELF, reason)
+		log.Error(fmt.Sprintf("GELF 数据解压失败: %v, 来自 %s", err, remoteAddr))
+		return err
+	}
+	msg, err := Parse(data)
+	if err != nil {
+		p.Drop(model.PushTypeGELF, dropInvalidMessage)
+		log.Error(fmt.Sprintf("GELF 数据解析失败: %v, 原始数据: %s", err, string(data)))
+		return err
+	}
+	// _token 不写入 attributes
+	if field, ok := msg.Fields["token"]; ok {
+		delete(msg.Fields, "token")
+		if token == "" {
+			token = field
+		}
+	}
+	entry := ToLog(msg, l, remoteAddr)
+	schema, err := p.Authenticate(model.PushTypeGELF, token, "")
+	if err == nil {
+		if schema == "" {
+			// 没有 token 时不接受 _schema 指定的其他 schema；监听未配置 schema 时按缺少 schema 拒绝
+			schema = l.Schema
+			if schema == "" {
+				entry.Schema = ""
+			}
+		}
+		err = p.SubmitAs(schema, entry)
+	}
+	if err != nil {
+		// 原始数据中可能带有 _token，不写入日志
+		log.Error(fmt.Sprintf("GELF 日志未被接收: %v, 来自 %s", err, remoteAddr))
+		return err
+	}
+	return nil
+}
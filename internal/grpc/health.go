@@ -0,0 +1,57 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/health"
+	"github.com/vkeeps/agera-logs/proto"
+	gg "google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// DefaultHealthInterval gRPC 健康状态的刷新间隔
+const DefaultHealthInterval = 5 * time.Second
+
+// RegisterHealth 在 s 上注册 grpc.health.v1 服务，整体状态（空服务名）和 LogService 的状态
+// 每隔 interval 按 checker 的结果刷新；stop 关闭后置为 NOT_SERVING，便于负载均衡在退出前摘除流量
+func RegisterHealth(s *gg.Server, checker *health.Checker, interval time.Duration, stop <-chan struct{}, log *logrus.Logger) *grpchealth.Server {
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+
+	update := func(last healthpb.HealthCheckResponse_ServingStatus) healthpb.HealthCheckResponse_ServingStatus {
+		report := checker.Check(context.Background())
+		status := healthpb.HealthCheckResponse_SERVING
+		if !report.Ready {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if status != last {
+			log.Info(fmt.Sprintf("gRPC 健康状态变为 %s", status))
+		}
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(proto.LogService_ServiceDesc.ServiceName, status)
+		return status
+	}
+
+	last := update(healthpb.HealthCheckResponse_UNKNOWN)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				hs.Shutdown()
+				return
+			case <-ticker.C:
+				last = update(last)
+			}
+		}
+	}()
+	return hs
+}
@@ -0,0 +1,71 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/health"
+	gg "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRegisterHealth(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	var broken atomic.Bool
+	checker := health.NewChecker(time.Second)
+	checker.Register("clickhouse", func(context.Context) error {
+		if broken.Load() {
+			return errors.New("ping 失败")
+		}
+		return nil
+	})
+
+	lis := bufconn.Listen(1 << 20)
+	server := gg.NewServer()
+	stop := make(chan struct{})
+	RegisterHealth(server, checker, 10*time.Millisecond, stop, log)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := gg.NewClient("passthrough:///bufnet",
+		gg.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		gg.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("连接 gRPC 服务失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	waitFor := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "proto.LogService"})
+			if err == nil && resp.Status == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("健康状态未变为 %s: %v, %v", want, resp, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor(healthpb.HealthCheckResponse_SERVING)
+	broken.Store(true)
+	waitFor(healthpb.HealthCheckResponse_NOT_SERVING)
+	broken.Store(false)
+	waitFor(healthpb.HealthCheckResponse_SERVING)
+	close(stop)
+	waitFor(healthpb.HealthCheckResponse_NOT_SERVING)
+}
@@ -0,0 +1,46 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/metrics"
+	gg "google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcRequests = metrics.NewCounterVec("agera_grpc_requests_total",
+		"gRPC 请求数", "method", "code")
+	grpcDuration = metrics.NewHistogramVec("agera_grpc_request_duration_seconds",
+		"gRPC 请求耗时，流式接口为整个流的持续时间", metrics.DefBuckets, "method")
+)
+
+func init() {
+	metrics.Default.MustRegister(grpcRequests, grpcDuration)
+}
+
+// UnaryServerInterceptor 记录一元调用的次数和耗时
+func UnaryServerInterceptor() gg.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *gg.UnaryServerInfo, handler gg.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observe(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor 记录流式调用的次数和耗时
+func StreamServerInterceptor() gg.StreamServerInterceptor {
+	return func(srv any, ss gg.ServerStream, info *gg.StreamServerInfo, handler gg.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func observe(method string, start time.Time, err error) {
+	grpcRequests.With(method, status.Code(err).String()).Inc()
+	grpcDuration.With(method).Observe(time.Since(start).Seconds())
+}
@@ -2,86 +2,167 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"time"
+	"strings"
 
 	"github.com/sirupsen/logrus"
-	"github.com/vkeeps/agera-logs/internal/db"
 	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/tlsconfig"
 	"github.com/vkeeps/agera-logs/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+// APIKeyMetadata 携带 ingest token 的 metadata 键，也可以使用 authorization: Bearer <token>
+const APIKeyMetadata = "x-api-key"
+
 type LogServer struct {
 	proto.UnimplementedLogServiceServer
-	Logger *logrus.Logger
+	Logger   *logrus.Logger
+	Pipeline *pipeline.Pipeline
 }
 
 func (s *LogServer) SendLog(ctx context.Context, req *proto.LogRequest) (*proto.LogResponse, error) {
-	clientIP, clientAddr := "0.0.0.0", "unknown"
-	if p, ok := peer.FromContext(ctx); ok {
-		clientIP, clientAddr = parseRemoteAddr(p.Addr.String())
+	cl := clientFromContext(ctx)
+	schema, err := s.authenticate(ctx, cl)
+	if err != nil {
+		return nil, err
 	}
 
-	// 检查 service 是否为空
-	if req.Service == "" {
-		s.Logger.Error(fmt.Sprintf("gRPC 日志缺少 service 字段，跳过插入，原始数据: %+v", req))
-		return &proto.LogResponse{Success: false}, fmt.Errorf("service 字段为空")
+	if err := s.Pipeline.SubmitAs(schema, toLog(req, cl)); err != nil {
+		s.Logger.Error(fmt.Sprintf("gRPC 日志未被接收: %v", err))
+		return &proto.LogResponse{Success: false}, rejectStatus(err)
 	}
 
-	schemaID := db.GenerateSchemaID(req.Schema)
-	schemaName, err := db.GetSchemaNameByID(schemaID, s.Logger)
+	return &proto.LogResponse{Success: true}, nil
+}
+
+// SendLogs 接收客户端流，流结束后返回每条日志的接收结果
+func (s *LogServer) SendLogs(stream proto.LogService_SendLogsServer) error {
+	cl := clientFromContext(stream.Context())
+	schema, err := s.authenticate(stream.Context(), cl)
 	if err != nil {
-		s.Logger.Error(fmt.Sprintf("获取 schema_id %s 失败: %v", schemaID, err))
-		return &proto.LogResponse{Success: false}, err
+		return err
 	}
-	if schemaName == "" {
-		s.Logger.Warn(fmt.Sprintf("无效的 schema_id: %s，未在 BoltDB 中注册, schema: %s", schemaID, req.Schema))
-		db.RebuildSchemaCache(schemaID, s.Logger)
-		start := time.Now()
-		timeout := 100 * time.Millisecond
-		for time.Since(start) < timeout {
-			schemaName, err = db.GetSchemaNameByID(schemaID, s.Logger)
-			if err == nil && schemaName != "" {
-				break
-			}
-			time.Sleep(10 * time.Millisecond)
+	resp := &proto.LogBatchResponse{}
+
+	for index := int32(0); ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
 		}
-		if schemaName == "" {
-			s.Logger.Error(fmt.Sprintf("重试后仍无效的 schema_id: %s，跳过插入", schemaID))
-			return &proto.LogResponse{Success: false}, fmt.Errorf("无效的 schema_id: %s，未在 BoltDB 中注册", schemaID)
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("gRPC 流读取失败: %v", err))
+			return err
 		}
+		s.submit(resp, index, schema, req, cl)
+	}
+
+	s.Logger.Info(fmt.Sprintf("gRPC 流推送结束，接收 %d 条，拒绝 %d 条，来自 %s", resp.Accepted, resp.Rejected, cl.addr))
+	return stream.SendAndClose(resp)
+}
+
+// SendLogBatch 一次请求推送多条日志，校验失败的条目单独拒绝，不影响其余条目
+func (s *LogServer) SendLogBatch(ctx context.Context, req *proto.LogBatchRequest) (*proto.LogBatchResponse, error) {
+	cl := clientFromContext(ctx)
+	schema, err := s.authenticate(ctx, cl)
+	if err != nil {
+		return nil, err
 	}
+	resp := &proto.LogBatchResponse{}
 
-	entry := &model.Log{
-		LogBase: model.LogBase{
-			Output:     req.Output,
-			Detail:     req.Detail,
-			ErrorInfo:  req.ErrorInfo,
-			Service:    req.Service,
-			ClientIP:   clientIP,
-			ClientAddr: clientAddr,
-			LogLevel:   req.LogLevel,
-		},
-		Schema:            model.LogSchema(schemaName),
-		Module:            model.LogModule(req.Module),
-		PushType:          model.PushTypeGRPC,
-		Timestamp:         time.Now(),
-		OperatorID:        req.OperatorID,
-		Operator:          req.Operator,
-		OperatorIP:        req.OperatorIP,
-		OperatorEquipment: req.OperatorEquipment,
-		OperatorCompany:   req.OperatorCompany,
-		OperatorProject:   req.OperatorProject,
+	for i, r := range req.Logs {
+		s.submit(resp, int32(i), schema, r, cl)
 	}
+	return resp, nil
+}
 
-	if err := db.InsertLogs([]*model.Log{entry}, s.Logger); err != nil {
-		s.Logger.Error(fmt.Sprintf("gRPC 日志插入失败: %v", err))
-		return &proto.LogResponse{Success: false}, err
+// rejectStatus 把流水线的拒绝原因映射为 gRPC 状态码
+func rejectStatus(err error) error {
+	var rejectErr *pipeline.RejectError
+	if !errors.As(err, &rejectErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	code := codes.InvalidArgument
+	switch rejectErr.Reason {
+	case pipeline.ReasonBufferFull, pipeline.ReasonSpoolFailed:
+		code = codes.Unavailable
+	case pipeline.ReasonRateLimited:
+		code = codes.ResourceExhausted
+	case pipeline.ReasonUnauthorized:
+		code = codes.Unauthenticated
+	case pipeline.ReasonForbidden:
+		code = codes.PermissionDenied
+	case pipeline.ReasonSchemaLookup:
+		code = codes.Internal
+	case pipeline.ReasonUnknownSchema:
+		code = codes.NotFound
 	}
+	return status.Error(code, err.Error())
+}
 
-	return &proto.LogResponse{Success: true}, nil
+// authenticate 校验 metadata 中的 ingest token 或客户端证书，返回 token 所属 schema，未通过时返回 Unauthenticated
+func (s *LogServer) authenticate(ctx context.Context, cl client) (string, error) {
+	schema, err := s.Pipeline.Authenticate(model.PushTypeGRPC, tokenFromContext(ctx), cl.identity)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("gRPC 认证失败: %v", err))
+		return "", status.Error(codes.Unauthenticated, err.Error())
+	}
+	return schema, nil
+}
+
+// tokenFromContext 从 metadata 的 x-api-key 或 authorization: Bearer 中取 ingest token
+func tokenFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(APIKeyMetadata); len(values) > 0 {
+		return values[0]
+	}
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+// submit 提交一条日志并把结果记入 resp，schema 为 token 所属 schema
+func (s *LogServer) submit(resp *proto.LogBatchResponse, index int32, schema string, req *proto.LogRequest, cl client) {
+	if err := s.Pipeline.SubmitAs(schema, toLog(req, cl)); err != nil {
+		resp.Rejected++
+		resp.Rejections = append(resp.Rejections, &proto.LogRejection{Index: index, Reason: err.Error()})
+		return
+	}
+	resp.Accepted++
+}
+
+func toLog(req *proto.LogRequest, cl client) *model.Log {
+	return pipeline.ProtoToLog(req, model.PushTypeGRPC, cl.ip, cl.addr, cl.identity)
+}
+
+// client 调用方的地址和 TLS 客户端证书身份
+type client struct {
+	ip, addr, identity string
+}
+
+func clientFromContext(ctx context.Context) client {
+	cl := client{ip: "0.0.0.0", addr: "unknown"}
+	if p, ok := peer.FromContext(ctx); ok {
+		cl.ip, cl.addr = parseRemoteAddr(p.Addr.String())
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			cl.identity = tlsconfig.Identity(&info.State)
+		}
+	}
+	return cl
 }
 
 func parseRemoteAddr(addr string) (clientIP, clientAddr string) {
@@ -0,0 +1,136 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/proto"
+	gg "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T, store db.Storage) (proto.LogServiceClient, *pipeline.Pipeline) {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	p := pipeline.New(store, pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour}, log)
+
+	lis := bufconn.Listen(1 << 20)
+	server := gg.NewServer()
+	proto.RegisterLogServiceServer(server, &LogServer{Logger: log, Pipeline: p})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := gg.NewClient("passthrough:///bufnet",
+		gg.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		gg.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("连接 gRPC 服务失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewLogServiceClient(conn), p
+}
+
+func TestSendLogsStream(t *testing.T) {
+	store := db.NewMemoryStorage()
+	if _, err := store.GetOrCreateSchema("shop"); err != nil {
+		t.Fatalf("创建 schema 失败: %v", err)
+	}
+	client, p := newTestClient(t, store)
+
+	stream, err := client.SendLogs(context.Background())
+	if err != nil {
+		t.Fatalf("打开流失败: %v", err)
+	}
+	reqs := []*proto.LogRequest{
+		{Schema: "shop", Module: "order", Service: "order-svc", Output: "ok"},
+		{Schema: "shop", Module: "order", Output: "missing service"},
+		{Schema: "shop", Module: "pay", Service: "pay-svc", Output: "ok"},
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("发送日志失败: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("接收结果失败: %v", err)
+	}
+	if resp.Accepted != 2 || resp.Rejected != 1 {
+		t.Fatalf("预期接收 2 条拒绝 1 条，实际接收 %d 条拒绝 %d 条", resp.Accepted, resp.Rejected)
+	}
+	if len(resp.Rejections) != 1 || resp.Rejections[0].Index != 1 || resp.Rejections[0].Reason == "" {
+		t.Errorf("拒绝明细不符合预期: %v", resp.Rejections)
+	}
+
+	p.Flush()
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop"})
+	logs := page.Logs
+	if len(logs) != 2 {
+		t.Errorf("预期写入 2 条日志，实际 %d 条", len(logs))
+	}
+}
+
+func TestSendLogBatchUnknownSchema(t *testing.T) {
+	store := db.NewMemoryStorage()
+	if _, err := store.GetOrCreateSchema("shop"); err != nil {
+		t.Fatalf("创建 schema 失败: %v", err)
+	}
+	client, _ := newTestClient(t, store)
+
+	resp, err := client.SendLogBatch(context.Background(), &proto.LogBatchRequest{Logs: []*proto.LogRequest{
+		{Schema: "unknown", Module: "order", Service: "order-svc", Output: "dropped"},
+		{Schema: "shop", Module: "order", Service: "order-svc", Output: "kept"},
+	}})
+	if err != nil {
+		t.Fatalf("批量推送失败: %v", err)
+	}
+	if resp.Accepted != 1 || resp.Rejected != 1 || resp.Rejections[0].Index != 0 {
+		t.Errorf("结果不符合预期: %+v", resp)
+	}
+}
+
+func TestSendLogInvalidName(t *testing.T) {
+	store := db.NewMemoryStorage()
+	if _, err := store.GetOrCreateSchema("shop"); err != nil {
+		t.Fatalf("创建 schema 失败: %v", err)
+	}
+	client, _ := newTestClient(t, store)
+
+	_, err := client.SendLog(context.Background(), &proto.LogRequest{Schema: "shop", Module: "order;drop", Service: "svc", Output: "x"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("非法 module 预期 InvalidArgument，实际 %v", err)
+	}
+}
+
+func TestSendLogBatchUsesPipelineBuffer(t *testing.T) {
+	store := db.NewMemoryStorage()
+	if _, err := store.GetOrCreateSchema("shop"); err != nil {
+		t.Fatalf("创建 schema 失败: %v", err)
+	}
+	client, p := newTestClient(t, store)
+
+	resp, err := client.SendLogBatch(context.Background(), &proto.LogBatchRequest{Logs: []*proto.LogRequest{
+		{Schema: "shop", Module: "order", Service: "order-svc", Output: "a"},
+		{Schema: "shop", Module: "order", Service: "order-svc", Output: "b"},
+	}})
+	if err != nil || resp.Accepted != 2 {
+		t.Fatalf("批量推送失败: %+v, %v", resp, err)
+	}
+	// 批量接口与其他传输方式共用流水线缓冲区，未攒够一批前不会直接写入存储
+	if page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"}); len(page.Logs) != 0 {
+		t.Errorf("日志应先进入流水线缓冲区，实际已写入 %d 条", len(page.Logs))
+	}
+	if n := p.Flush(); n != 2 {
+		t.Errorf("刷新缓冲区应写入 2 条，实际 %d 条", n)
+	}
+}
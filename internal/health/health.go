@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/spool"
+)
+
+const (
+	// DefaultTimeout 单次检查的超时时间
+	DefaultTimeout = 2 * time.Second
+	// DefaultSaturation 缓冲区或 spool 占用超过该比例时视为未就绪
+	DefaultSaturation = 0.9
+)
+
+// CheckFunc 一项依赖检查，返回 nil 表示正常
+type CheckFunc func(ctx context.Context) error
+
+// Result 单项检查结果
+type Result struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report 就绪检查报告，所有检查都通过时 Ready 为 true
+type Report struct {
+	Ready  bool     `json:"ready"`
+	Checks []Result `json:"checks"`
+}
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Checker 汇总各依赖的检查，供 HTTP /readyz 和 gRPC 健康服务使用
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks []check
+}
+
+// NewChecker 创建检查器，timeout <= 0 时使用 DefaultTimeout
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Register 注册一项检查，按注册顺序输出
+func (c *Checker) Register(name string, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// Check 并发执行所有检查，每项检查受 timeout 限制
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]check(nil), c.checks...)
+	c.mu.RUnlock()
+
+	report := Report{Ready: true, Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, ch := range checks {
+		wg.Add(1)
+		go func(i int, ch check) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx, ch)
+		}(i, ch)
+	}
+	wg.Wait()
+	for _, r := range report.Checks {
+		if !r.OK {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, ch check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- ch.fn(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("检查超时: %v", ctx.Err())
+	}
+	r := Result{Name: ch.name, OK: err == nil, Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// BufferCheck 流水线缓冲区占用达到 saturation 比例时失败，此时继续导流会被拒绝
+func BufferCheck(p *pipeline.Pipeline, saturation float64) CheckFunc {
+	return func(context.Context) error {
+		stats := p.Stats()
+		if stats.Capacity > 0 && float64(stats.Buffered) >= saturation*float64(stats.Capacity) {
+			return fmt.Errorf("缓冲区接近饱和: %d/%d", stats.Buffered, stats.Capacity)
+		}
+		return nil
+	}
+}
+
+// SpoolCheck spool 磁盘占用达到 saturation 比例时失败，积压满后写入会被拒绝
+func SpoolCheck(sp *spool.Spool, saturation float64) CheckFunc {
+	return func(context.Context) error {
+		st := sp.Status()
+		if st.MaxBytes > 0 && float64(st.DiskBytes) >= saturation*float64(st.MaxBytes) {
+			return fmt.Errorf("spool 积压接近上限: %d/%d 字节，待重放 %d 条", st.DiskBytes, st.MaxBytes, st.PendingLogs)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+)
+
+// APIKeyHeader 推送日志时携带 ingest token 的请求头，也可以使用 Authorization: Bearer <token>
+const APIKeyHeader = "X-API-Key"
+
+// ingestToken 从请求头中取 ingest token
+func ingestToken(c *gin.Context) string {
+	if token := c.GetHeader(APIKeyHeader); token != "" {
+		return token
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && strings.HasPrefix(token, db.APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// createAPIKey 为 schema 生成新的 ingest token，token 明文只在这里返回一次
+func createAPIKey(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.BindJSON(&req); err != nil {
+				log.Error("数据格式有误")
+				c.JSON(http.StatusBadRequest, gin.H{"error": "数据格式有误"})
+				return
+			}
+		}
+
+		key, token, err := store.CreateAPIKey(c.Param("name"), req.Name)
+		if err != nil {
+			log.Error(fmt.Sprintf("创建 api key 失败: %v", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"key": key, "token": token})
+	}
+}
+
+func listAPIKeys(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys, err := store.ListAPIKeys(c.Param("name"))
+		if err != nil {
+			log.Error(fmt.Sprintf("获取 api key 列表失败: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取 api key 列表失败"})
+			return
+		}
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+// revokeAPIKey 吊销 token，并清空本节点的 token 缓存使其立即失效
+func revokeAPIKey(store db.Storage, p *pipeline.Pipeline, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := store.RevokeAPIKey(c.Param("name"), c.Param("id"))
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("吊销 api key 失败: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销 api key 失败"})
+			return
+		}
+		p.ForgetAPIKeys()
+		c.JSON(http.StatusOK, gin.H{"message": "api key 已吊销"})
+	}
+}
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/auth"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/tlsconfig"
+)
+
+const principalKey = "auth.principal"
+
+// anonymous 未启用认证时的调用方，对所有 schema 拥有全部权限，与启用认证前的行为一致
+var anonymous = &auth.Principal{Subject: "anonymous", Roles: map[string]auth.Role{auth.AllSchemas: auth.RoleAdmin}}
+
+// authenticate 校验 Authorization: Bearer，识别成功后把调用方放入上下文。
+// 没有 token 时尝试按已校验的 TLS 客户端证书识别，仍无法识别时不拦截，由各路由按需要的角色判断；
+// ingest token（agk_ 前缀）只用于 POST /logs，这里跳过。
+func authenticate(authn auth.Authenticator, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authn == nil {
+			c.Set(principalKey, anonymous)
+			return
+		}
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			if certs, ok := authn.(auth.CertAuthenticator); ok {
+				if principal, err := certs.AuthenticateCert(tlsconfig.Identity(c.Request.TLS)); err == nil {
+					c.Set(principalKey, principal)
+				}
+			}
+			return
+		}
+		if strings.HasPrefix(token, db.APIKeyPrefix) {
+			return
+		}
+		principal, err := authn.Authenticate(token)
+		if err != nil {
+			log.Warn(fmt.Sprintf("HTTP 认证失败: %v，来自 %s", err, c.ClientIP()))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "认证失败"})
+			return
+		}
+		c.Set(principalKey, principal)
+	}
+}
+
+func principalFrom(c *gin.Context) *auth.Principal {
+	if v, ok := c.Get(principalKey); ok {
+		return v.(*auth.Principal)
+	}
+	return nil
+}
+
+// allowed 调用方对 schema 至少拥有 role 时返回 true，否则写入 401（未认证）或 403（权限不足）
+func allowed(c *gin.Context, schema string, role auth.Role) bool {
+	principal := principalFrom(c)
+	if principal == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少认证信息"})
+		return false
+	}
+	if !principal.Can(schema, role) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("没有 schema %s 的 %s 权限", schema, role)})
+		return false
+	}
+	return true
+}
+
+// requireRole 要求调用方对 schemaOf 返回的 schema 至少拥有 role
+func requireRole(role auth.Role, schemaOf func(c *gin.Context) (string, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schema, err := schemaOf(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		allowed(c, schema, role)
+	}
+}
+
+// schemaParam 从路径参数取 schema 名称
+func schemaParam(name string) func(c *gin.Context) (string, error) {
+	return func(c *gin.Context) (string, error) {
+		return c.Param(name), nil
+	}
+}
+
+// schemaByID 从路径参数取 schema_id 并解析为名称，未注册的 schema_id 解析为空，只有全局角色可以访问
+func schemaByID(store db.Storage, name string) func(c *gin.Context) (string, error) {
+	return func(c *gin.Context) (string, error) {
+		schemaName, err := store.GetSchemaNameByID(c.Param(name))
+		if err != nil {
+			return "", fmt.Errorf("获取 schema_id %s 对应的数据库名失败", c.Param(name))
+		}
+		return schemaName, nil
+	}
+}
+
+// allSchemas 运行状态类接口要求全局角色
+func allSchemas(*gin.Context) (string, error) {
+	return auth.AllSchemas, nil
+}
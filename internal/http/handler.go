@@ -1,18 +1,27 @@
 package http
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/auth"
 	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/health"
 	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/spool"
+	"github.com/vkeeps/agera-logs/internal/tail"
+	"github.com/vkeeps/agera-logs/internal/tlsconfig"
 )
 
-func SetupRouter(log *logrus.Logger) *gin.Engine {
+// SetupRouter 注册所有路由，写入走 p，查询直接读 store；sp 为 nil 时不提供 spool 状态接口，
+// checker 为 /readyz 使用的依赖检查，authn 为 nil 时不做认证
+func SetupRouter(store db.Storage, p *pipeline.Pipeline, sp *spool.Spool, checker *health.Checker, authn auth.Authenticator, log *logrus.Logger) *gin.Engine {
 	r := gin.New()
 
 	r.Use(gin.LoggerWithConfig(gin.LoggerConfig{
@@ -30,19 +39,50 @@ func SetupRouter(log *logrus.Logger) *gin.Engine {
 	}))
 
 	r.Use(gin.Recovery())
+	r.Use(requestMetrics())
 
-	r.POST("/logs", createLog(log))
-	r.GET("/logs/:schema/:module", getLogs(log))
-	r.POST("/schemas", createSchema(log))
-	r.GET("/schemas/:name", getSchema(log))
-	r.GET("/schemas", getAllSchemas(log))
-	r.GET("/modules/:schemaId", getModulesBySchemaId(log))
-	r.GET("/logs/by-schema/:schemaId", getLogsBySchemaId(log)) // 调整路由避免冲突
+	// 实时 tail 订阅流水线刚接收的日志
+	hub := tail.NewHub()
+	p.AddObserver(hub)
+
+	// /metrics 和健康检查不需要认证，便于采集和编排系统探测
+	r.GET("/metrics", metricsHandler(p, sp, hub))
+	r.GET("/healthz", healthz())
+	r.GET("/readyz", readyz(checker))
+
+	r.Use(authenticate(authn, log))
+	reader := func(schemaOf func(c *gin.Context) (string, error)) gin.HandlerFunc {
+		return requireRole(auth.RoleReader, schemaOf)
+	}
+	admin := func(schemaOf func(c *gin.Context) (string, error)) gin.HandlerFunc {
+		return requireRole(auth.RoleAdmin, schemaOf)
+	}
+
+	r.POST("/logs", createLog(p, log))
+	r.GET("/logs/:schema/:module", reader(schemaParam("schema")), getLogs(store, log))
+	r.GET("/logs/:schema/:module/group", reader(schemaParam("schema")), groupLogs(store, log))
+	r.GET("/logs/:schema/:module/tail", reader(schemaParam("schema")), tailSSE(hub, log))
+	r.GET("/logs/:schema/:module/tail/ws", reader(schemaParam("schema")), tailWebSocket(hub, log))
+	r.POST("/schemas", createSchema(store, log))
+	r.GET("/schemas/:name", reader(schemaParam("name")), getSchema(store, log))
+	r.GET("/schemas", getAllSchemas(store, log))
+	r.GET("/schemas/:name/settings", reader(schemaParam("name")), getSchemaSettings(store, log))
+	r.PUT("/schemas/:name/settings", admin(schemaParam("name")), updateSchemaSettings(store, log))
+	r.POST("/schemas/:name/keys", admin(schemaParam("name")), createAPIKey(store, log))
+	r.GET("/schemas/:name/keys", admin(schemaParam("name")), listAPIKeys(store, log))
+	r.DELETE("/schemas/:name/keys/:id", admin(schemaParam("name")), revokeAPIKey(store, p, log))
+	r.GET("/modules/:schemaId", reader(schemaByID(store, "schemaId")), getModulesBySchemaId(store, log))
+	r.GET("/logs/by-schema/:schemaId", reader(schemaByID(store, "schemaId")), getLogsBySchemaId(store, log)) // 调整路由避免冲突
+	r.GET("/pipeline/stats", reader(allSchemas), getPipelineStats(p))
+	r.GET("/tail/stats", reader(allSchemas), getTailStats(hub))
+	if sp != nil {
+		r.GET("/spool/status", reader(allSchemas), getSpoolStatus(sp))
+	}
 
 	return r
 }
 
-func createSchema(log *logrus.Logger) gin.HandlerFunc {
+func createSchema(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
 			Name string `json:"name" binding:"required"`
@@ -52,8 +92,14 @@ func createSchema(log *logrus.Logger) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "数据格式有误"})
 			return
 		}
+		if !allowed(c, req.Name, auth.RoleAdmin) {
+			return
+		}
 
-		schemaID, err := db.GetOrCreateSchema(req.Name, log)
+		schemaID, err := store.GetOrCreateSchema(req.Name)
+		if invalidName(c, err) {
+			return
+		}
 		if err != nil {
 			log.Error("创建 schema 失败")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建 schema 失败"})
@@ -64,35 +110,76 @@ func createSchema(log *logrus.Logger) gin.HandlerFunc {
 	}
 }
 
-func getSchema(log *logrus.Logger) gin.HandlerFunc {
+// invalidName schema 或 module 名称不合法时返回 400
+func invalidName(c *gin.Context, err error) bool {
+	if errors.Is(err, db.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return true
+	}
+	return false
+}
+
+func getSchema(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		schemaName := c.Param("name")
-		schemaID, err := db.GetOrCreateSchema(schemaName, log)
+		// 只读接口不创建 schema，创建使用 POST /schemas
+		exists, err := store.SchemaExists(schemaName)
+		if invalidName(c, err) {
+			return
+		}
 		if err != nil {
-			log.Error("查询 schema 失败")
+			log.Error(fmt.Sprintf("查询 schema %s 失败: %v", schemaName, err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询 schema 失败"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"schema": schemaName, "id": schemaID})
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("schema %s 不存在", schemaName)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"schema": schemaName, "id": db.GenerateSchemaID(schemaName)})
 	}
 }
 
-func getAllSchemas(log *logrus.Logger) gin.HandlerFunc {
+func getAllSchemas(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		schemas, err := db.GetAllSchemas(log)
+		schemas, err := store.ListSchemas()
 		if err != nil {
 			log.Error("查询所有 schema 失败")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询所有 schema 失败"})
 			return
 		}
-		c.JSON(http.StatusOK, schemas)
+		principal := principalFrom(c)
+		if principal == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证信息"})
+			return
+		}
+		// 只返回调用方有权读取的 schema
+		visible := schemas[:0]
+		for _, schema := range schemas {
+			if principal.Can(schema.Name, auth.RoleReader) {
+				visible = append(visible, schema)
+			}
+		}
+		c.JSON(http.StatusOK, visible)
 	}
 }
 
-func getModulesBySchemaId(log *logrus.Logger) gin.HandlerFunc {
+func getPipelineStats(p *pipeline.Pipeline) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, p.Stats())
+	}
+}
+
+func getSpoolStatus(sp *spool.Spool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, sp.Status())
+	}
+}
+
+func getModulesBySchemaId(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		schemaId := c.Param("schemaId")
-		modules, err := db.GetModulesBySchemaId(schemaId, log)
+		modules, err := store.ListModules(schemaId)
 		if err != nil {
 			log.Error("查询 schema 相关模块失败")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询 schema 相关模块失败"})
@@ -102,23 +189,25 @@ func getModulesBySchemaId(log *logrus.Logger) gin.HandlerFunc {
 	}
 }
 
-func createLog(log *logrus.Logger) gin.HandlerFunc {
+func createLog(p *pipeline.Pipeline, log *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Schema            string `json:"schema" binding:"required"`
-			Module            string `json:"module" binding:"required"`
-			Output            string `json:"output" binding:"required"`
-			Detail            string `json:"detail"`
-			ErrorInfo         string `json:"error_info"`
-			Service           string `json:"service"`
-			ClientIP          string `json:"client_ip"`
-			LogLevel          string `json:"log_level"`
-			OperatorID        string `json:"operator_id"`
-			Operator          string `json:"operator"`
-			OperatorIP        string `json:"operator_ip"`
-			OperatorEquipment string `json:"operator_equipment"`
-			OperatorCompany   string `json:"operator_company"`
-			OperatorProject   string `json:"operator_project"`
+			Schema            string            `json:"schema"` // 携带 ingest token 时可省略
+			Module            string            `json:"module" binding:"required"`
+			Output            string            `json:"output" binding:"required"`
+			Detail            string            `json:"detail"`
+			ErrorInfo         string            `json:"error_info"`
+			Service           string            `json:"service"`
+			ClientIP          string            `json:"client_ip"`
+			LogLevel          string            `json:"log_level"`
+			OperatorID        string            `json:"operator_id"`
+			Operator          string            `json:"operator"`
+			OperatorIP        string            `json:"operator_ip"`
+			OperatorEquipment string            `json:"operator_equipment"`
+			OperatorCompany   string            `json:"operator_company"`
+			OperatorProject   string            `json:"operator_project"`
+			Attributes        map[string]string `json:"attributes"`
+			Timestamp         model.EventTime   `json:"timestamp"` // RFC 3339 或 Unix 纳秒，不填时使用接收时间
 		}
 		if err := c.BindJSON(&req); err != nil {
 			log.Error("数据格式有误")
@@ -126,13 +215,6 @@ func createLog(log *logrus.Logger) gin.HandlerFunc {
 			return
 		}
 
-		// 检查 service 是否为空
-		if req.Service == "" {
-			log.Error(fmt.Sprintf("HTTP 日志缺少 service 字段，跳过插入，原始数据: %+v", req))
-			c.JSON(http.StatusBadRequest, gin.H{"error": "service 字段为空"})
-			return
-		}
-
 		entry := &model.Log{
 			LogBase: model.LogBase{
 				Output:    req.Output,
@@ -145,100 +227,154 @@ func createLog(log *logrus.Logger) gin.HandlerFunc {
 			Schema:            model.LogSchema(req.Schema),
 			Module:            model.LogModule(req.Module),
 			PushType:          model.PushTypeHTTP,
-			Timestamp:         time.Now(),
+			Timestamp:         req.Timestamp.Time,
+			ReceiveTime:       time.Now(),
 			OperatorID:        req.OperatorID,
 			Operator:          req.Operator,
 			OperatorIP:        req.OperatorIP,
 			OperatorEquipment: req.OperatorEquipment,
 			OperatorCompany:   req.OperatorCompany,
 			OperatorProject:   req.OperatorProject,
+			Attributes:        req.Attributes,
+			ClientIdentity:    tlsconfig.Identity(c.Request.TLS),
 		}
 
-		if err := db.InsertLogs([]*model.Log{entry}, log); err != nil {
-			log.Error("日志插入失败")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "日志插入失败"})
+		// 携带 ingest token 或未启用认证时由流水线校验 token；否则要求调用方对 schema 拥有 writer 角色
+		var schema string
+		var err error
+		if token := ingestToken(c); token != "" || principalFrom(c) == anonymous {
+			schema, err = p.Authenticate(model.PushTypeHTTP, token, entry.ClientIdentity)
+		} else if !allowed(c, req.Schema, auth.RoleWriter) {
+			return
+		} else {
+			schema = req.Schema
+		}
+		if err == nil {
+			err = p.SubmitAs(schema, entry)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("HTTP 日志未被接收: %v", err))
+			status := http.StatusBadRequest
+			var rejectErr *pipeline.RejectError
+			if errors.As(err, &rejectErr) {
+				switch rejectErr.Reason {
+				case pipeline.ReasonBufferFull, pipeline.ReasonSpoolFailed:
+					status = http.StatusServiceUnavailable
+				case pipeline.ReasonRateLimited:
+					status = http.StatusTooManyRequests
+				case pipeline.ReasonUnauthorized:
+					status = http.StatusUnauthorized
+				case pipeline.ReasonForbidden:
+					status = http.StatusForbidden
+				}
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"message": "日志已添加"})
 	}
 }
 
-func getLogs(log *logrus.Logger) gin.HandlerFunc {
+// tableFound 检查 schema.module 的表是否存在，查询接口不建表，不存在时返回 404
+func tableFound(c *gin.Context, store db.Storage, schema, module string, log *logrus.Logger) bool {
+	exists, err := store.TableExists(schema, module)
+	if err != nil {
+		log.Error(fmt.Sprintf("检查表 %s.%s 是否存在失败: %v", schema, module, err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "检查表是否存在失败"})
+		return false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("表 %s.%s 不存在", schema, module)})
+		return false
+	}
+	return true
+}
+
+func getLogs(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		schema := c.Param("schema")
 		module := c.Param("module")
-		tableName := fmt.Sprintf("%s.%s%s_%s", schema, db.TablePrefix, schema, module)
 
-		if err := db.EnsureTable(schema, module, log); err != nil {
-			log.Error("表不存在或创建失败")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "表不存在或创建失败"})
+		q, err := parseLogQuery(c)
+		if err != nil {
+			log.Error(fmt.Sprintf("查询参数有误: %v", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		q.Schema, q.Module = schema, module
 
-		query := fmt.Sprintf("SELECT output, detail, error_info, service, client_ip, client_addr, log_level, operator_id, operator, operator_ip, operator_equipment, operator_company, operator_project, operation_time, push_type FROM %s ORDER BY operation_time DESC LIMIT 1000", tableName)
-		rows, err := db.ClickHouseDB.Query(query)
+		if err := db.ValidateNames(schema, module); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !tableFound(c, store, schema, module, log) {
+			return
+		}
+
+		page, err := store.QueryLogs(q)
 		if err != nil {
 			log.Error("查询日志失败")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询日志失败"})
 			return
 		}
-		defer rows.Close()
-
-		var logs []struct {
-			Output            string
-			Detail            string
-			ErrorInfo         string
-			Service           string
-			ClientIP          string
-			ClientAddr        string
-			LogLevel          string
-			OperatorID        string
-			Operator          string
-			OperatorIP        string
-			OperatorEquipment string
-			OperatorCompany   string
-			OperatorProject   string
-			OperationTime     time.Time
-			PushType          string
-		}
-		for rows.Next() {
-			var entry struct {
-				Output            string
-				Detail            string
-				ErrorInfo         string
-				Service           string
-				ClientIP          string
-				ClientAddr        string
-				LogLevel          string
-				OperatorID        string
-				Operator          string
-				OperatorIP        string
-				OperatorEquipment string
-				OperatorCompany   string
-				OperatorProject   string
-				OperationTime     time.Time
-				PushType          string
-			}
-			if err := rows.Scan(&entry.Output, &entry.Detail, &entry.ErrorInfo, &entry.Service,
-				&entry.ClientIP, &entry.ClientAddr, &entry.LogLevel, &entry.OperatorID, &entry.Operator,
-				&entry.OperatorIP, &entry.OperatorEquipment, &entry.OperatorCompany, &entry.OperatorProject,
-				&entry.OperationTime, &entry.PushType); err != nil {
-				log.Error("日志解析失败")
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "日志解析失败"})
-				return
+		if page.NextCursor != "" {
+			c.Header(NextCursorHeader, page.NextCursor)
+		}
+		c.JSON(http.StatusOK, page.Logs)
+	}
+}
+
+// groupLogs 按 by 参数指定的列或 attributes.<key> 统计日志数量，过滤参数与 getLogs 相同，
+// limit 为最多返回的分组数
+func groupLogs(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, err := parseLogFilter(c)
+		if err == nil {
+			_, _, err = db.ParseGroupBy(c.Query("by"))
+		}
+		groupLimit := 0
+		if limit := c.Query("limit"); err == nil && limit != "" {
+			if groupLimit, err = strconv.Atoi(limit); err != nil || groupLimit <= 0 || groupLimit > db.MaxGroupLimit {
+				err = fmt.Errorf("limit 参数无效，取值范围 1-%d", db.MaxGroupLimit)
 			}
-			logs = append(logs, entry)
 		}
-		c.JSON(http.StatusOK, logs)
+		if err != nil {
+			log.Error(fmt.Sprintf("查询参数有误: %v", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		q.Schema, q.Module = c.Param("schema"), c.Param("module")
+		if err := db.ValidateNames(q.Schema, q.Module); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !tableFound(c, store, q.Schema, q.Module, log) {
+			return
+		}
+
+		groups, err := store.GroupLogs(q, c.Query("by"), groupLimit)
+		if err != nil {
+			log.Error(fmt.Sprintf("分组统计日志失败: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "分组统计日志失败"})
+			return
+		}
+		c.JSON(http.StatusOK, groups)
 	}
 }
 
-func getLogsBySchemaId(log *logrus.Logger) gin.HandlerFunc {
+func getLogsBySchemaId(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		schemaId := c.Param("schemaId")
+		q, err := parseLogQuery(c)
+		if err != nil {
+			log.Error(fmt.Sprintf("查询参数有误: %v", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		// 先通过schemaId获取实际的数据库名称
-		schemaName, err := db.GetSchemaNameByID(schemaId, log)
+		schemaName, err := store.GetSchemaNameByID(schemaId)
 		if err != nil {
 			log.Error(fmt.Sprintf("获取 schema_id %s 对应的数据库名失败: %v", schemaId, err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取 schema_id %s 对应的数据库名失败", schemaId)})
@@ -246,102 +382,20 @@ func getLogsBySchemaId(log *logrus.Logger) gin.HandlerFunc {
 		}
 
 		if schemaName == "" {
-			log.Error(fmt.Sprintf("未找到 schema_id %s 对应的数据库名", schemaId))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("未找到 schema_id %s 对应的数据库名", schemaId)})
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("schema_id %s 不存在", schemaId)})
 			return
 		}
 
-		// 使用实际的数据库名称查询表
-		rows, err := db.ClickHouseDB.Query("SELECT name FROM system.tables WHERE database = ?", schemaName)
+		q.Schema = schemaName
+		page, err := store.QueryLogs(q)
 		if err != nil {
-			log.Error(fmt.Sprintf("查询 schema %s 的表失败: %v", schemaName, err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询 schema 相关表失败"})
+			log.Error(fmt.Sprintf("查询 schema %s 的日志失败: %v", schemaName, err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询日志失败"})
 			return
 		}
-		defer rows.Close()
-
-		var tables []string
-		for rows.Next() {
-			var tableName string
-			if err := rows.Scan(&tableName); err != nil {
-				log.Error(fmt.Sprintf("解析表名称失败: %v", err))
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "解析表名称失败"})
-				return
-			}
-			if strings.HasPrefix(tableName, db.TablePrefix) {
-				tables = append(tables, fmt.Sprintf("%s.%s", schemaName, tableName))
-			}
+		if page.NextCursor != "" {
+			c.Header(NextCursorHeader, page.NextCursor)
 		}
-
-		var allLogs []struct {
-			Module            string
-			Output            string
-			Detail            string
-			ErrorInfo         string
-			Service           string
-			ClientIP          string
-			ClientAddr        string
-			LogLevel          string
-			OperatorID        string
-			Operator          string
-			OperatorIP        string
-			OperatorEquipment string
-			OperatorCompany   string
-			OperatorProject   string
-			OperationTime     time.Time
-			PushType          string
-		}
-
-		for _, table := range tables {
-			query := fmt.Sprintf("SELECT output, detail, error_info, service, client_ip, client_addr, log_level, operator_id, operator, operator_ip, operator_equipment, operator_company, operator_project, operation_time, push_type FROM %s ORDER BY operation_time DESC LIMIT 1000", table)
-			rows, err := db.ClickHouseDB.Query(query)
-			if err != nil {
-				log.Error("查询日志失败")
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询日志失败"})
-				return
-			}
-			defer rows.Close()
-
-			for rows.Next() {
-				var entry struct {
-					Module            string
-					Output            string
-					Detail            string
-					ErrorInfo         string
-					Service           string
-					ClientIP          string
-					ClientAddr        string
-					LogLevel          string
-					OperatorID        string
-					Operator          string
-					OperatorIP        string
-					OperatorEquipment string
-					OperatorCompany   string
-					OperatorProject   string
-					OperationTime     time.Time
-					PushType          string
-				}
-				if err := rows.Scan(&entry.Output, &entry.Detail, &entry.ErrorInfo, &entry.Service,
-					&entry.ClientIP, &entry.ClientAddr, &entry.LogLevel, &entry.OperatorID, &entry.Operator,
-					&entry.OperatorIP, &entry.OperatorEquipment, &entry.OperatorCompany, &entry.OperatorProject,
-					&entry.OperationTime, &entry.PushType); err != nil {
-					log.Error("日志解析失败")
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "日志解析失败"})
-					return
-				}
-				entry.Module = table
-				allLogs = append(allLogs, entry)
-			}
-		}
-
-		for i := 0; i < len(allLogs)-1; i++ {
-			for j := i + 1; j < len(allLogs); j++ {
-				if allLogs[i].OperationTime.Before(allLogs[j].OperationTime) {
-					allLogs[i], allLogs[j] = allLogs[j], allLogs[i]
-				}
-			}
-		}
-
-		c.JSON(http.StatusOK, allLogs)
+		c.JSON(http.StatusOK, page.Logs)
 	}
 }
@@ -0,0 +1,550 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/auth"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/health"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+)
+
+func newTestRouter(t *testing.T) (*gin.Engine, *db.MemoryStorage, *pipeline.Pipeline) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	store := db.NewMemoryStorage()
+	p := pipeline.New(store, pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour}, log)
+	checker := health.NewChecker(0)
+	checker.Register("pipeline", health.BufferCheck(p, health.DefaultSaturation))
+	return SetupRouter(store, p, nil, checker, nil, log), store, p
+}
+
+func doJSON(t *testing.T, r http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("请求体序列化失败: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateAndQueryLogs(t *testing.T) {
+	r, _, p := newTestRouter(t)
+
+	w := doJSON(t, r, http.MethodPost, "/schemas", map[string]string{"name": "shop"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("创建 schema 失败，状态码 %d: %s", w.Code, w.Body.String())
+	}
+	var schema struct {
+		Schema string `json:"schema"`
+		ID     string `json:"id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+		t.Fatalf("解析 schema 响应失败: %v", err)
+	}
+
+	for _, output := range []string{"first", "second"} {
+		w = doJSON(t, r, http.MethodPost, "/logs", map[string]string{
+			"schema":    "shop",
+			"module":    "order",
+			"output":    output,
+			"service":   "order-svc",
+			"log_level": "warn",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("写入日志失败，状态码 %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	p.Flush()
+	w = doJSON(t, r, http.MethodGet, "/logs/shop/order", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("查询日志失败，状态码 %d: %s", w.Code, w.Body.String())
+	}
+	var logs []model.LogRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil {
+		t.Fatalf("解析日志响应失败: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("预期 2 条日志，实际 %d 条", len(logs))
+	}
+	if logs[0].LogLevel != "WARN" || logs[0].PushType != string(model.PushTypeHTTP) {
+		t.Errorf("日志字段不符合预期: %+v", logs[0])
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/modules/"+schema.ID, nil)
+	var modules []string
+	if err := json.Unmarshal(w.Body.Bytes(), &modules); err != nil {
+		t.Fatalf("解析模块响应失败: %v", err)
+	}
+	if len(modules) != 1 || modules[0] != "order" {
+		t.Errorf("模块列表不符合预期: %v", modules)
+	}
+}
+
+func TestCreateLogRequiresService(t *testing.T) {
+	r, store, _ := newTestRouter(t)
+
+	w := doJSON(t, r, http.MethodPost, "/logs", map[string]string{
+		"schema": "shop",
+		"module": "order",
+		"output": "no service",
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("预期状态码 400，实际 %d", w.Code)
+	}
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+	logs := page.Logs
+	if len(logs) != 0 {
+		t.Errorf("缺少 service 的日志不应写入，实际写入 %d 条", len(logs))
+	}
+}
+
+func TestGetLogsFiltersAndCursor(t *testing.T) {
+	r, _, p := newTestRouter(t)
+	doJSON(t, r, http.MethodPost, "/schemas", map[string]string{"name": "shop"})
+	for _, level := range []string{"error", "info", "error", "error"} {
+		doJSON(t, r, http.MethodPost, "/logs", map[string]string{
+			"schema": "shop", "module": "order", "output": "payment timeout", "service": "order-svc", "log_level": level,
+		})
+	}
+	p.Flush()
+
+	w := doJSON(t, r, http.MethodGet, "/logs/shop/order?level=ERROR&q=TIMEOUT&limit=2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("查询日志失败，状态码 %d: %s", w.Code, w.Body.String())
+	}
+	var logs []model.LogRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil {
+		t.Fatalf("解析日志响应失败: %v", err)
+	}
+	cursor := w.Header().Get(NextCursorHeader)
+	if len(logs) != 2 || cursor == "" {
+		t.Fatalf("第一页预期 2 条并返回游标，实际 %d 条，游标 %q", len(logs), cursor)
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/logs/shop/order?level=ERROR&q=TIMEOUT&limit=2&cursor="+cursor, nil)
+	logs = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil {
+		t.Fatalf("解析日志响应失败: %v", err)
+	}
+	if len(logs) != 1 || w.Header().Get(NextCursorHeader) != "" {
+		t.Errorf("第二页预期 1 条且没有游标，实际 %d 条", len(logs))
+	}
+
+	for _, query := range []string{"limit=0", "from=yesterday", "match=regex", "cursor=bm90LWEtY3Vyc29y"} {
+		if w := doJSON(t, r, http.MethodGet, "/logs/shop/order?"+query, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s 预期返回 400，实际 %d", query, w.Code)
+		}
+	}
+}
+
+func TestGetLogsBySchemaId(t *testing.T) {
+	r, _, p := newTestRouter(t)
+	w := doJSON(t, r, http.MethodPost, "/schemas", map[string]string{"name": "shop"})
+	var schema struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+		t.Fatalf("解析 schema 响应失败: %v", err)
+	}
+	for _, module := range []string{"order", "pay", "order"} {
+		doJSON(t, r, http.MethodPost, "/logs", map[string]string{
+			"schema": "shop", "module": module, "output": module, "service": "svc", "log_level": "error",
+		})
+	}
+	p.Flush()
+
+	w = doJSON(t, r, http.MethodGet, "/logs/by-schema/"+schema.ID+"?level=error&limit=2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("查询日志失败，状态码 %d: %s", w.Code, w.Body.String())
+	}
+	var logs []model.LogRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil {
+		t.Fatalf("解析日志响应失败: %v", err)
+	}
+	if len(logs) != 2 || w.Header().Get(NextCursorHeader) == "" {
+		t.Fatalf("预期返回 2 条并带游标，实际 %d 条", len(logs))
+	}
+	for _, record := range logs {
+		if record.Module != record.Output {
+			t.Errorf("Module 应为模块名 %s，实际 %s", record.Output, record.Module)
+		}
+	}
+
+	if w := doJSON(t, r, http.MethodGet, "/logs/by-schema/unknown", nil); w.Code != http.StatusNotFound {
+		t.Errorf("未注册的 schema_id 应返回 404，实际 %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTailSSE(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+	doJSON(t, r, http.MethodPost, "/schemas", map[string]string{"name": "shop"})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/logs/shop/order/tail?level=error")
+	if err != nil {
+		t.Fatalf("订阅失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type 不符合预期: %s", ct)
+	}
+
+	for _, level := range []string{"info", "error"} {
+		doJSON(t, r, http.MethodPost, "/logs", map[string]string{
+			"schema": "shop", "module": "order", "output": level + " log", "service": "svc", "log_level": level,
+		})
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var event, data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("读取事件失败: %v", err)
+		}
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	var record model.LogRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		t.Fatalf("解析事件数据失败: %v", err)
+	}
+	if event != "log" || record.Output != "error log" {
+		t.Errorf("预期收到 error 日志事件，实际 %s %+v", event, record)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+
+	doJSON(t, r, http.MethodPost, "/logs", map[string]string{
+		"schema":  "shop",
+		"module":  "order",
+		"output":  "hello",
+		"service": "order-svc",
+	})
+	w := doJSON(t, r, http.MethodGet, "/metrics", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("获取指标失败，状态码 %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`agera_ingest_received_total{transport="http"} 1`,
+		`agera_http_requests_total{method="POST",route="/logs",code="200"}`,
+		"agera_pipeline_buffer_capacity",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("指标中缺少 %q", want)
+		}
+	}
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	r, _, p := newTestRouter(t)
+
+	if w := doJSON(t, r, http.MethodGet, "/healthz", nil); w.Code != http.StatusOK {
+		t.Fatalf("存活检查预期 200，实际 %d", w.Code)
+	}
+	if w := doJSON(t, r, http.MethodGet, "/readyz", nil); w.Code != http.StatusOK {
+		t.Fatalf("就绪检查预期 200，实际 %d: %s", w.Code, w.Body.String())
+	}
+
+	// 缓冲区占满后未就绪
+	doJSON(t, r, http.MethodPost, "/schemas", map[string]string{"name": "shop"})
+	for i := 0; i < p.Stats().Capacity; i++ {
+		doJSON(t, r, http.MethodPost, "/logs", map[string]string{
+			"schema":  "shop",
+			"module":  "order",
+			"output":  "fill",
+			"service": "order-svc",
+		})
+	}
+	w := doJSON(t, r, http.MethodGet, "/readyz", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("缓冲区饱和时预期 503，实际 %d", w.Code)
+	}
+	var report health.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("解析就绪报告失败: %v", err)
+	}
+	if report.Ready || len(report.Checks) != 1 || report.Checks[0].OK || report.Checks[0].Error == "" {
+		t.Errorf("就绪报告不符合预期: %+v", report)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	r, _, p := newTestRouter(t)
+	p.Reconfigure(pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour, RequireAPIKey: true})
+	doJSON(t, r, http.MethodPost, "/schemas", map[string]string{"name": "shop"})
+
+	w := doJSON(t, r, http.MethodPost, "/schemas/shop/keys", map[string]string{"name": "ci"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("创建 api key 失败，状态码 %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Key   db.APIKey `json:"key"`
+		Token string    `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Token == "" {
+		t.Fatalf("解析 api key 响应失败: %v, %s", err, w.Body.String())
+	}
+
+	push := func(headers map[string]string) int {
+		data, _ := json.Marshal(map[string]string{"module": "order", "output": "x", "service": "svc"})
+		req := httptest.NewRequest(http.MethodPost, "/logs", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := push(nil); code != http.StatusUnauthorized {
+		t.Errorf("缺少 token 预期 401，实际 %d", code)
+	}
+	if code := push(map[string]string{APIKeyHeader: created.Token}); code != http.StatusOK {
+		t.Errorf("X-API-Key 推送预期 200，实际 %d", code)
+	}
+	if code := push(map[string]string{"Authorization": "Bearer " + created.Token}); code != http.StatusOK {
+		t.Errorf("Bearer 推送预期 200，实际 %d", code)
+	}
+
+	if w := doJSON(t, r, http.MethodDelete, "/schemas/shop/keys/"+created.Key.ID, nil); w.Code != http.StatusOK {
+		t.Fatalf("吊销 api key 失败，状态码 %d", w.Code)
+	}
+	if code := push(map[string]string{APIKeyHeader: created.Token}); code != http.StatusUnauthorized {
+		t.Errorf("吊销后预期 401，实际 %d", code)
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/schemas/shop/keys", nil)
+	var keys []db.APIKey
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatalf("解析 api key 列表失败: %v", err)
+	}
+	if len(keys) != 1 || keys[0].Active() || strings.Contains(w.Body.String(), created.Token) {
+		t.Errorf("api key 列表不符合预期: %s", w.Body.String())
+	}
+}
+
+func TestAuthRoles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	store := db.NewMemoryStorage()
+	p := pipeline.New(store, pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour}, log)
+	authn, err := auth.New(auth.Config{Enabled: true, Tokens: []auth.StaticToken{
+		{Token: "ops-token", Subject: "ops", Roles: map[string]string{"*": "admin"}},
+		{Token: "shop-token", Subject: "shop", Roles: map[string]string{"shop": "writer"}},
+	}})
+	if err != nil {
+		t.Fatalf("创建认证失败: %v", err)
+	}
+	r := SetupRouter(store, p, nil, health.NewChecker(0), authn, log)
+	// 推送的日志留在缓冲区中，预先建表供查询
+	if err := store.EnsureTable("shop", "order"); err != nil {
+		t.Fatalf("创建表失败: %v", err)
+	}
+
+	do := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	logBody := func(schema string) map[string]string {
+		return map[string]string{"schema": schema, "module": "order", "output": "x", "service": "svc"}
+	}
+
+	for _, tc := range []struct {
+		name, method, path, token string
+		body                      any
+		want                      int
+	}{
+		{"未认证不能创建 schema", http.MethodPost, "/schemas", "", map[string]string{"name": "shop"}, http.StatusUnauthorized},
+		{"错误的 token", http.MethodGet, "/schemas", "bad", nil, http.StatusUnauthorized},
+		{"writer 不能创建 schema", http.MethodPost, "/schemas", "shop-token", map[string]string{"name": "shop"}, http.StatusForbidden},
+		{"admin 创建 schema", http.MethodPost, "/schemas", "ops-token", map[string]string{"name": "shop"}, http.StatusOK},
+		{"admin 创建 schema", http.MethodPost, "/schemas", "ops-token", map[string]string{"name": "pay"}, http.StatusOK},
+		{"未认证不能推送", http.MethodPost, "/logs", "", logBody("shop"), http.StatusUnauthorized},
+		{"writer 推送自己的 schema", http.MethodPost, "/logs", "shop-token", logBody("shop"), http.StatusOK},
+		{"writer 不能推送其他 schema", http.MethodPost, "/logs", "shop-token", logBody("pay"), http.StatusForbidden},
+		{"writer 可以查询", http.MethodGet, "/logs/shop/order", "shop-token", nil, http.StatusOK},
+		{"查询不存在的表", http.MethodGet, "/logs/shop/refund", "shop-token", nil, http.StatusNotFound},
+		{"统计不存在的表", http.MethodGet, "/logs/shop/refund/group?by=service", "shop-token", nil, http.StatusNotFound},
+		{"查询不存在的 schema", http.MethodGet, "/schemas/audit", "ops-token", nil, http.StatusNotFound},
+		{"不能查询其他 schema", http.MethodGet, "/logs/pay/order", "shop-token", nil, http.StatusForbidden},
+		{"不能按 ID 查询其他 schema", http.MethodGet, "/logs/by-schema/" + db.GenerateSchemaID("pay"), "shop-token", nil, http.StatusForbidden},
+		{"writer 不能管理 token", http.MethodGet, "/schemas/shop/keys", "shop-token", nil, http.StatusForbidden},
+		{"运行状态需要全局角色", http.MethodGet, "/pipeline/stats", "shop-token", nil, http.StatusForbidden},
+		{"健康检查不需要认证", http.MethodGet, "/healthz", "", nil, http.StatusOK},
+	} {
+		if w := do(tc.method, tc.path, tc.token, tc.body); w.Code != tc.want {
+			t.Errorf("%s: 预期 %d，实际 %d: %s", tc.name, tc.want, w.Code, w.Body.String())
+		}
+	}
+
+	w := do(http.MethodGet, "/schemas", "shop-token", nil)
+	var schemas []db.SchemaInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &schemas); err != nil {
+		t.Fatalf("解析 schema 列表失败: %v", err)
+	}
+	if len(schemas) != 1 || schemas[0].Name != "shop" {
+		t.Errorf("schema 列表应只包含有权限的 schema: %+v", schemas)
+	}
+	// 只读接口不应创建 schema 或表
+	if exists, _ := store.SchemaExists("audit"); exists {
+		t.Error("查询不存在的 schema 不应创建 schema")
+	}
+	if exists, _ := store.TableExists("shop", "refund"); exists {
+		t.Error("查询不存在的表不应建表")
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+	for _, tc := range []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodPost, "/schemas", map[string]string{"name": "shop; DROP DATABASE x"}},
+		{http.MethodGet, "/schemas/system", nil},
+		{http.MethodGet, "/logs/shop/a.b", nil},
+		{http.MethodGet, "/logs/shop/a.b/tail", nil},
+		{http.MethodPost, "/logs", map[string]string{"schema": "shop", "module": "a`b", "output": "x", "service": "svc"}},
+	} {
+		if w := doJSON(t, r, tc.method, tc.path, tc.body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s 预期 400，实际 %d: %s", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSchemaSettings(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+
+	w := doJSON(t, r, http.MethodGet, "/schemas/shop/settings", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"retention_days":0`) {
+		t.Fatalf("未设置时应返回永久保留: %d %s", w.Code, w.Body.String())
+	}
+
+	settings := db.SchemaSettings{RetentionDays: 30, ModuleRetentionDays: map[string]int{"audit": 365}}
+	if w := doJSON(t, r, http.MethodPut, "/schemas/shop/settings", settings); w.Code != http.StatusOK {
+		t.Fatalf("更新配置失败: %d %s", w.Code, w.Body.String())
+	}
+	w = doJSON(t, r, http.MethodGet, "/schemas/shop/settings", nil)
+	var got db.SchemaSettings
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("解析配置失败: %v", err)
+	}
+	if got.RetentionFor("order") != 30 || got.RetentionFor("audit") != 365 {
+		t.Errorf("配置不符合预期: %+v", got)
+	}
+
+	for _, body := range []any{
+		db.SchemaSettings{RetentionDays: -1},
+		db.SchemaSettings{RetentionDays: db.MaxRetentionDays + 1},
+		db.SchemaSettings{ModuleRetentionDays: map[string]int{"a.b": 7}},
+	} {
+		if w := doJSON(t, r, http.MethodPut, "/schemas/shop/settings", body); w.Code != http.StatusBadRequest {
+			t.Errorf("非法配置 %+v 预期 400，实际 %d", body, w.Code)
+		}
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	r, _, p := newTestRouter(t)
+	doJSON(t, r, http.MethodPost, "/schemas", map[string]string{"name": "shop"})
+
+	for i, attrs := range []map[string]string{
+		{"request_id": "r1", "region": "eu"},
+		{"request_id": "r2", "region": "eu"},
+		{"request_id": "r3", "region": "us"},
+		{},
+	} {
+		w := doJSON(t, r, http.MethodPost, "/logs", map[string]any{
+			"schema": "shop", "module": "order", "output": fmt.Sprintf("log %d", i), "service": "svc", "attributes": attrs,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("写入日志失败: %d %s", w.Code, w.Body.String())
+		}
+	}
+	w := doJSON(t, r, http.MethodPost, "/logs", map[string]any{
+		"schema": "shop", "module": "order", "output": "x", "service": "svc", "attributes": map[string]string{"bad key": "v"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("非法的 attributes 键预期 400，实际 %d", w.Code)
+	}
+	p.Flush()
+
+	w = doJSON(t, r, http.MethodGet, "/logs/shop/order?attr.region=eu", nil)
+	var logs []model.LogRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil {
+		t.Fatalf("解析日志响应失败: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Attributes["region"] != "eu" {
+		t.Errorf("按 attributes 过滤结果不符合预期: %+v", logs)
+	}
+	w = doJSON(t, r, http.MethodGet, "/logs/shop/order?has_attr=request_id", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil || len(logs) != 3 {
+		t.Errorf("has_attr 过滤结果不符合预期: %d 条", len(logs))
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/logs/shop/order/group?by=attributes.region", nil)
+	var groups []db.GroupCount
+	if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+		t.Fatalf("解析分组响应失败: %v %s", err, w.Body.String())
+	}
+	want := []db.GroupCount{{Value: "eu", Count: 2}, {Value: "us", Count: 1}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("分组结果不符合预期: %+v", groups)
+	}
+
+	for _, path := range []string{
+		"/logs/shop/order/group?by=output",
+		"/logs/shop/order/group?by=attributes.a%20b",
+		"/logs/shop/order?attr.a%20b=1",
+	} {
+		if w := doJSON(t, r, http.MethodGet, path, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s 预期 400，实际 %d", path, w.Code)
+		}
+	}
+}
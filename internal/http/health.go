@@ -0,0 +1,27 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vkeeps/agera-logs/internal/health"
+)
+
+// healthz 存活检查，进程能处理请求即返回 200
+func healthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// readyz 就绪检查，任一依赖检查失败时返回 503 和各项检查结果
+func readyz(checker *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := checker.Check(c.Request.Context())
+		code := http.StatusOK
+		if !report.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, report)
+	}
+}
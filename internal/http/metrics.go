@@ -0,0 +1,62 @@
+package http
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vkeeps/agera-logs/internal/metrics"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/spool"
+	"github.com/vkeeps/agera-logs/internal/tail"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("agera_http_requests_total",
+		"HTTP 请求数", "method", "route", "code")
+	httpDuration = metrics.NewHistogramVec("agera_http_request_duration_seconds",
+		"HTTP 请求耗时，实时订阅接口为连接持续时间", metrics.DefBuckets, "method", "route")
+)
+
+func init() {
+	metrics.Default.MustRegister(httpRequests, httpDuration)
+}
+
+// requestMetrics 记录每个请求的次数和耗时，route 使用注册的路由模板以避免标签基数过高
+func requestMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.With(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.With(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricsHandler 输出进程级指标以及流水线、spool 和实时订阅的统计
+func metricsHandler(p *pipeline.Pipeline, sp *spool.Spool, hub *tail.Hub) gin.HandlerFunc {
+	reg := metrics.NewRegistry()
+	reg.MustRegister(p.Collectors()...)
+	reg.MustRegister(
+		metrics.NewGaugeFunc("agera_tail_subscribers", "实时订阅连接数",
+			func() float64 { return float64(hub.Stats().Subscribers) }),
+		metrics.NewFunc("agera_tail_dropped_total", "实时订阅消费过慢被丢弃的日志条数", metrics.CounterType, nil,
+			func() []metrics.Sample { return []metrics.Sample{{Value: float64(hub.Stats().Dropped)}} }),
+	)
+	if sp != nil {
+		reg.MustRegister(
+			metrics.NewGaugeFunc("agera_spool_pending_logs", "spool 中等待重放的日志条数",
+				func() float64 { return float64(sp.Status().PendingLogs) }),
+			metrics.NewGaugeFunc("agera_spool_disk_bytes", "spool 占用的磁盘空间",
+				func() float64 { return float64(sp.Status().DiskBytes) }),
+			metrics.NewFunc("agera_spool_replayed_total", "spool 累计重放的日志条数", metrics.CounterType, nil,
+				func() []metrics.Sample { return []metrics.Sample{{Value: float64(sp.Status().ReplayedLogs)}} }),
+			metrics.NewGaugeFunc("agera_spool_dead_letter_logs", "超过重试次数移入 spool 死信文件的日志条数",
+				func() float64 { return float64(sp.Status().DeadLetterLogs) }),
+		)
+	}
+	return gin.WrapH(metrics.Handler(metrics.Default, reg))
+}
@@ -0,0 +1,123 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vkeeps/agera-logs/internal/db"
+)
+
+// NextCursorHeader 还有下一页时通过该响应头返回游标，响应体仍为日志数组
+const NextCursorHeader = "X-Next-Cursor"
+
+// attrParamPrefix 按 attributes 过滤的查询参数前缀
+const attrParamPrefix = "attr."
+
+// parseLogQuery 解析日志查询参数：
+//
+//	from / to         时间范围，RFC3339 或 Unix 秒/毫秒，[from, to)
+//	level             日志级别，可重复或用逗号分隔
+//	service / operator_id / operator_company / operator_project / push_type / client_identity  精确匹配
+//	q                 在 output、detail、error_info 中搜索
+//	match             substring（默认）或 token
+//	attr.<key>        attributes 中该键的值精确匹配
+//	has_attr          attributes 中包含该键，可重复
+//	limit / cursor    分页，cursor 取自上一页的 X-Next-Cursor 响应头
+func parseLogQuery(c *gin.Context) (db.LogQuery, error) {
+	q, err := parseLogFilter(c)
+	if err != nil {
+		return q, err
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > db.MaxQueryLimit {
+			return q, fmt.Errorf("limit 参数无效，取值范围 1-%d", db.MaxQueryLimit)
+		}
+		q.Limit = limit
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		if q.Cursor, err = db.DecodeCursor(value); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// parseLogFilter 解析 parseLogQuery 中除分页以外的过滤参数
+func parseLogFilter(c *gin.Context) (db.LogQuery, error) {
+	q := db.LogQuery{
+		Service:         c.Query("service"),
+		OperatorID:      c.Query("operator_id"),
+		OperatorCompany: c.Query("operator_company"),
+		OperatorProject: c.Query("operator_project"),
+		PushType:        c.Query("push_type"),
+		ClientIdentity:  c.Query("client_identity"),
+		Search:          c.Query("q"),
+	}
+
+	var err error
+	if q.From, err = parseTimeParam(c.Query("from")); err != nil {
+		return q, fmt.Errorf("from 参数无效: %v", err)
+	}
+	if q.To, err = parseTimeParam(c.Query("to")); err != nil {
+		return q, fmt.Errorf("to 参数无效: %v", err)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("from 必须早于 to")
+	}
+
+	for _, value := range c.QueryArray("level") {
+		for _, level := range strings.Split(value, ",") {
+			if level = strings.TrimSpace(level); level != "" {
+				q.Levels = append(q.Levels, strings.ToUpper(level))
+			}
+		}
+	}
+
+	switch match := db.MatchMode(c.Query("match")); match {
+	case "", db.MatchSubstring, db.MatchToken:
+		q.Match = match
+	default:
+		return q, fmt.Errorf("match 参数无效: %s", match)
+	}
+
+	for param, values := range c.Request.URL.Query() {
+		key, ok := strings.CutPrefix(param, attrParamPrefix)
+		if !ok {
+			continue
+		}
+		if err := db.ValidateAttributeKey(key); err != nil {
+			return q, err
+		}
+		if q.Attributes == nil {
+			q.Attributes = make(map[string]string)
+		}
+		q.Attributes[key] = values[0]
+	}
+	for _, key := range c.QueryArray("has_attr") {
+		if err := db.ValidateAttributeKey(key); err != nil {
+			return q, err
+		}
+		q.HasAttributes = append(q.HasAttributes, key)
+	}
+	return q, nil
+}
+
+// parseTimeParam 解析 RFC3339 时间或 Unix 时间戳（13 位及以上按毫秒处理），空字符串返回零值
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if len(value) >= 13 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
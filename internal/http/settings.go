@@ -0,0 +1,53 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+)
+
+func getSchemaSettings(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schemaName := c.Param("name")
+		if invalidName(c, db.ValidateSchemaName(schemaName)) {
+			return
+		}
+		settings, err := store.GetSchemaSettings(schemaName)
+		if err != nil {
+			log.Error(fmt.Sprintf("查询 schema 配置失败: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询 schema 配置失败"})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// updateSchemaSettings 整体替换 schema 配置，保留期修改会同步到该 schema 下已存在的表
+func updateSchemaSettings(store db.Storage, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schemaName := c.Param("name")
+		if invalidName(c, db.ValidateSchemaName(schemaName)) {
+			return
+		}
+		var settings db.SchemaSettings
+		if err := c.BindJSON(&settings); err != nil {
+			log.Error("数据格式有误")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "数据格式有误"})
+			return
+		}
+		if err := settings.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := store.UpdateSchemaSettings(schemaName, settings); err != nil {
+			log.Error(fmt.Sprintf("更新 schema 配置失败: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("更新 schema 配置失败: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
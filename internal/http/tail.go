@@ -0,0 +1,157 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/tail"
+	"golang.org/x/net/websocket"
+)
+
+// tailHeartbeat 没有新日志时的心跳间隔，避免代理因空闲断开连接
+const tailHeartbeat = 15 * time.Second
+
+// maxTailBuffer 订阅者可申请的最大缓冲条数
+const maxTailBuffer = 4096
+
+// tailMessage WebSocket 推送的消息，Type 为 log 或 dropped
+type tailMessage struct {
+	Type    string           `json:"type"`
+	Log     *model.LogRecord `json:"log,omitempty"`
+	Dropped int64            `json:"dropped,omitempty"`
+}
+
+// subscribeTail 解析过滤参数并订阅，参数有误时直接返回 400
+func subscribeTail(c *gin.Context, hub *tail.Hub, log *logrus.Logger) (*tail.Subscription, bool) {
+	q, err := parseLogQuery(c)
+	if err != nil {
+		log.Error(fmt.Sprintf("查询参数有误: %v", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	q.Schema, q.Module = c.Param("schema"), c.Param("module")
+	if err := db.ValidateNames(q.Schema, q.Module); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	buffer := tail.DefaultBuffer
+	if value := c.Query("buffer"); value != "" {
+		buffer, err = strconv.Atoi(value)
+		if err != nil || buffer <= 0 || buffer > maxTailBuffer {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("buffer 参数无效，取值范围 1-%d", maxTailBuffer)})
+			return nil, false
+		}
+	}
+	log.Info(fmt.Sprintf("%s 开始实时订阅 %s.%s", c.ClientIP(), q.Schema, q.Module))
+	return hub.Subscribe(q, buffer), true
+}
+
+// tailSSE 通过 Server-Sent Events 推送实时日志，事件类型为 log；消费过慢丢弃日志时先推送一条 dropped 事件
+func tailSSE(hub *tail.Hub, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sub, ok := subscribeTail(c, hub, log)
+		if !ok {
+			return
+		}
+		defer sub.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(tailHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case record, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				if n := sub.Dropped(); n > 0 {
+					c.SSEvent("dropped", gin.H{"dropped": n})
+				}
+				c.SSEvent("log", record)
+			case <-heartbeat.C:
+				if n := sub.Dropped(); n > 0 {
+					c.SSEvent("dropped", gin.H{"dropped": n})
+				} else {
+					fmt.Fprint(c.Writer, ": ping\n\n")
+				}
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// tailWebSocket 通过 WebSocket 推送实时日志，每条消息为一个 tailMessage
+func tailWebSocket(hub *tail.Hub, log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sub, ok := subscribeTail(c, hub, log)
+		if !ok {
+			return
+		}
+		defer sub.Close()
+
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			// 客户端不会发送数据，读取只用于感知连接关闭
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			heartbeat := time.NewTicker(tailHeartbeat)
+			defer heartbeat.Stop()
+			for {
+				var err error
+				select {
+				case <-closed:
+					return
+				case record, ok := <-sub.C:
+					if !ok {
+						return
+					}
+					if n := sub.Dropped(); n > 0 {
+						err = websocket.JSON.Send(ws, tailMessage{Type: "dropped", Dropped: n})
+					}
+					if err == nil {
+						err = websocket.JSON.Send(ws, tailMessage{Type: "log", Log: &record})
+					}
+				case <-heartbeat.C:
+					if n := sub.Dropped(); n > 0 {
+						err = websocket.JSON.Send(ws, tailMessage{Type: "dropped", Dropped: n})
+					} else {
+						ws.PayloadType = websocket.PingFrame
+						_, err = ws.Write(nil)
+						ws.PayloadType = websocket.TextFrame
+					}
+				}
+				if err != nil {
+					log.Warn(fmt.Sprintf("实时订阅推送失败，关闭连接: %v", err))
+					return
+				}
+			}
+		}}
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+func getTailStats(hub *tail.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, hub.Stats())
+	}
+}
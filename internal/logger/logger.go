@@ -84,3 +84,13 @@ func InitLogger(mode, logName string) (*logrus.Logger, error) {
 
 	return myLogger, nil
 }
+
+// SetLevel 设置日志级别（debug / info / warn / error），支持运行时调整
+func SetLevel(log *logrus.Logger, level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("无效的日志级别: %s", level)
+	}
+	log.SetLevel(lvl)
+	return nil
+}
@@ -0,0 +1,303 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Type 指标类型
+type Type string
+
+const (
+	CounterType   Type = "counter"
+	GaugeType     Type = "gauge"
+	HistogramType Type = "histogram"
+)
+
+// Collector 可以输出为 Prometheus 文本格式的指标
+type Collector interface {
+	collect(w io.Writer)
+}
+
+// Registry 指标集合
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+// NewRegistry 创建空的指标集合
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default 进程级指标集合，各包的静态指标在初始化时注册到这里
+var Default = NewRegistry()
+
+// MustRegister 注册指标
+func (r *Registry) MustRegister(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, cs...)
+}
+
+// WriteText 按注册顺序输出所有指标
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.collectors {
+		c.collect(w)
+	}
+}
+
+// Handler 输出一个或多个指标集合的 HTTP handler
+func Handler(registries ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, r := range registries {
+			r.WriteText(w)
+		}
+	})
+}
+
+// desc 指标名称、说明和标签名
+type desc struct {
+	name   string
+	help   string
+	typ    Type
+	labels []string
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	desc
+	series sync.Map // 标签值拼接 -> *Counter
+}
+
+// Counter 单个计数器
+type Counter struct {
+	labels []string
+	bits   atomic.Uint64
+}
+
+// NewCounterVec 创建计数器，labels 为标签名
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{desc: desc{name: name, help: help, typ: CounterType, labels: labels}}
+}
+
+// With 按标签值取得计数器，标签值个数必须与标签名一致
+func (v *CounterVec) With(values ...string) *Counter {
+	key := seriesKey(v.name, v.labels, values)
+	if c, ok := v.series.Load(key); ok {
+		return c.(*Counter)
+	}
+	c, _ := v.series.LoadOrStore(key, &Counter{labels: values})
+	return c.(*Counter)
+}
+
+// Inc 加一
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add 增加 delta，delta 必须为非负数
+func (c *Counter) Add(delta float64) {
+	addFloat(&c.bits, delta)
+}
+
+// Value 当前值
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+func (v *CounterVec) collect(w io.Writer) {
+	v.header(w)
+	for _, key := range sortedKeys(&v.series) {
+		c, _ := v.series.Load(key)
+		counter := c.(*Counter)
+		writeSample(w, v.name, v.labels, counter.labels, "", "", counter.Value())
+	}
+}
+
+// DefBuckets 默认的耗时分桶（秒）
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	desc
+	buckets []float64
+	series  sync.Map // 标签值拼接 -> *Histogram
+}
+
+// Histogram 单个直方图
+type Histogram struct {
+	labels []string
+	mu     sync.Mutex
+	counts []uint64 // 与 buckets 一一对应，非累计
+	count  uint64
+	sum    float64
+	bounds []float64
+}
+
+// NewHistogramVec 创建直方图，buckets 需升序
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{desc: desc{name: name, help: help, typ: HistogramType, labels: labels}, buckets: buckets}
+}
+
+// With 按标签值取得直方图
+func (v *HistogramVec) With(values ...string) *Histogram {
+	key := seriesKey(v.name, v.labels, values)
+	if h, ok := v.series.Load(key); ok {
+		return h.(*Histogram)
+	}
+	h, _ := v.series.LoadOrStore(key, &Histogram{labels: values, bounds: v.buckets, counts: make([]uint64, len(v.buckets))})
+	return h.(*Histogram)
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.bounds, value)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+	h.mu.Unlock()
+}
+
+func (v *HistogramVec) collect(w io.Writer) {
+	v.header(w)
+	for _, key := range sortedKeys(&v.series) {
+		value, _ := v.series.Load(key)
+		h := value.(*Histogram)
+		h.mu.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		count, sum := h.count, h.sum
+		h.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += counts[i]
+			writeSample(w, v.name+"_bucket", v.labels, h.labels, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, v.name+"_bucket", v.labels, h.labels, "le", "+Inf", float64(count))
+		writeSample(w, v.name+"_sum", v.labels, h.labels, "", "", sum)
+		writeSample(w, v.name+"_count", v.labels, h.labels, "", "", float64(count))
+	}
+}
+
+// Sample 回调指标的一个采样点
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Func 在输出时通过回调取值的指标，用于导出已有的统计快照
+type Func struct {
+	desc
+	fn func() []Sample
+}
+
+// NewFunc 创建回调指标，typ 为 CounterType 或 GaugeType
+func NewFunc(name, help string, typ Type, labels []string, fn func() []Sample) *Func {
+	return &Func{desc: desc{name: name, help: help, typ: typ, labels: labels}, fn: fn}
+}
+
+// NewGaugeFunc 创建无标签的回调仪表
+func NewGaugeFunc(name, help string, fn func() float64) *Func {
+	return NewFunc(name, help, GaugeType, nil, func() []Sample { return []Sample{{Value: fn()}} })
+}
+
+func (f *Func) collect(w io.Writer) {
+	samples := f.fn()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, "\xff") < strings.Join(samples[j].Labels, "\xff")
+	})
+	f.header(w)
+	for _, s := range samples {
+		writeSample(w, f.name, f.labels, s.Labels, "", "", s.Value)
+	}
+}
+
+func seriesKey(name string, labels, values []string) string {
+	if len(labels) != len(values) {
+		panic(fmt.Sprintf("指标 %s 需要 %d 个标签值，实际 %d 个", name, len(labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys(m *sync.Map) []string {
+	var keys []string
+	m.Range(func(k, _ any) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// writeSample 输出一行采样，extraName 非空时追加一个标签（直方图的 le）
+func writeSample(w io.Writer, name string, labels, values []string, extraName, extraValue string, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, label, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, extraName, extraValue)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	io.WriteString(w, b.String())
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
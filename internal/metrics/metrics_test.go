@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	counter := NewCounterVec("test_requests_total", "请求数", "path")
+	histogram := NewHistogramVec("test_duration_seconds", "耗时", []float64{0.1, 1}, "path")
+	gauge := NewGaugeFunc("test_buffered", "缓冲条数", func() float64 { return 3 })
+	reg.MustRegister(counter, histogram, gauge)
+
+	counter.With(`/a"b`).Inc()
+	counter.With(`/a"b`).Add(2)
+	histogram.With("/").Observe(0.05)
+	histogram.With("/").Observe(0.5)
+	histogram.With("/").Observe(5)
+
+	var b strings.Builder
+	reg.WriteText(&b)
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{path="/a\"b"} 3` + "\n",
+		`test_duration_seconds_bucket{path="/",le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{path="/",le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{path="/",le="+Inf"} 3` + "\n",
+		`test_duration_seconds_sum{path="/"} 5.55` + "\n",
+		`test_duration_seconds_count{path="/"} 3` + "\n",
+		"# TYPE test_buffered gauge\ntest_buffered 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("输出中缺少 %q:\n%s", want, out)
+		}
+	}
+}
@@ -23,10 +23,12 @@ const (
 type LogPushType string
 
 const (
-	PushTypeGRPC LogPushType = "grpc"
-	PushTypeUDP  LogPushType = "udp"
-	PushTypeHTTP LogPushType = "http"
-	PushTypeTCP  LogPushType = "tcp"
+	PushTypeGRPC   LogPushType = "grpc"
+	PushTypeUDP    LogPushType = "udp"
+	PushTypeHTTP   LogPushType = "http"
+	PushTypeTCP    LogPushType = "tcp"
+	PushTypeSyslog LogPushType = "syslog"
+	PushTypeGELF   LogPushType = "gelf"
 )
 
 // LogBase 基础日志字段，供 Log 和 LogEntry 复用
@@ -42,17 +44,20 @@ type LogBase struct {
 
 // Log 推送时的完整日志模型
 type Log struct {
-	LogBase                       // 嵌入基础字段
-	Schema            LogSchema   `json:"schema"`
-	Module            LogModule   `json:"module"`
-	PushType          LogPushType `json:"push_type"`
-	Timestamp         time.Time   `json:"timestamp"`
-	OperatorID        string      `json:"operator_id"`        // 操作人ID
-	Operator          string      `json:"operator"`           // 操作人名称
-	OperatorIP        string      `json:"operator_ip"`        // 扩展字段：操作人ip
-	OperatorEquipment string      `json:"operator_equipment"` // 扩展字段：操作人的设备
-	OperatorCompany   string      `json:"operator_company"`   // 扩展字段：操作人的企业
-	OperatorProject   string      `json:"operator_project"`   // 扩展字段：操作人的项目（一个企业有多个项目这种）
+	LogBase                             // 嵌入基础字段
+	Schema            LogSchema         `json:"schema"`
+	Module            LogModule         `json:"module"`
+	PushType          LogPushType       `json:"push_type"`
+	Timestamp         time.Time         `json:"timestamp"`                 // 事件时间，写入 operation_time；为零值时由流水线设为接收时间
+	ReceiveTime       time.Time         `json:"receive_time"`              // 服务端接收时间
+	OperatorID        string            `json:"operator_id"`               // 操作人ID
+	Operator          string            `json:"operator"`                  // 操作人名称
+	OperatorIP        string            `json:"operator_ip"`               // 扩展字段：操作人ip
+	OperatorEquipment string            `json:"operator_equipment"`        // 扩展字段：操作人的设备
+	OperatorCompany   string            `json:"operator_company"`          // 扩展字段：操作人的企业
+	OperatorProject   string            `json:"operator_project"`          // 扩展字段：操作人的项目（一个企业有多个项目这种）
+	Attributes        map[string]string `json:"attributes,omitempty"`      // 自定义结构化字段，如 request_id、耗时、标签
+	ClientIdentity    string            `json:"client_identity,omitempty"` // TLS 客户端证书的身份（CN，没有时取第一个 SAN）
 }
 
 // LogEntry 表中存储的日志模型
@@ -62,3 +67,26 @@ type LogEntry struct {
 	Operator      string    `json:"operator"`       // 添加 operator
 	OperationTime time.Time `json:"operation_time"` // 操作时间
 }
+
+// LogRecord 查询接口返回的日志记录，字段名与前端约定一致
+type LogRecord struct {
+	Module            string `json:"Module,omitempty"` // 跨模块查询时返回所属模块
+	Output            string
+	Detail            string
+	ErrorInfo         string
+	Service           string
+	ClientIP          string
+	ClientAddr        string
+	LogLevel          string
+	OperatorID        string
+	Operator          string
+	OperatorIP        string
+	OperatorEquipment string
+	OperatorCompany   string
+	OperatorProject   string
+	OperationTime     time.Time
+	ReceiveTime       time.Time
+	PushType          string
+	Attributes        map[string]string `json:"Attributes,omitempty"`
+	ClientIdentity    string            `json:"ClientIdentity,omitempty"`
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EventTime 客户端提供的事件时间，JSON 中可以是 RFC 3339 字符串或 Unix 纳秒整数（数字或字符串），省略或为 null 时为零值
+type EventTime struct {
+	time.Time
+}
+
+// ParseEventTime 解析 RFC 3339 时间或 Unix 纳秒整数，空字符串返回零值
+func ParseEventTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if nanos, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, nanos), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("时间格式无效，应为 RFC 3339 或 Unix 纳秒: %s", value)
+	}
+	return t, nil
+}
+
+func (t *EventTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	value := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+	}
+	parsed, err := ParseEventTime(value)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+func (t EventTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Format(time.RFC3339Nano))
+}
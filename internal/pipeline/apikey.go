@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/auth"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+const (
+	// apiKeyCacheTTL token 查询结果的缓存时间，其他节点吊销的 token 最迟在该时间后失效
+	apiKeyCacheTTL = 30 * time.Second
+	// apiKeyUsageInterval 最后使用时间写回存储的间隔
+	apiKeyUsageInterval = time.Minute
+)
+
+type cachedAPIKey struct {
+	key     *db.APIKey
+	expires time.Time
+}
+
+// Authenticate 校验 ingest token，返回 token 所属的 schema。identity 为 TLS 客户端证书的身份，没有时为空。
+// token 为空时：配置了 RequireAPIKey 且客户端证书身份未授权则拒绝，否则返回空字符串，由调用方按旧方式确定 schema。
+// 未通过时计入 pushType 的拒绝统计。
+func (p *Pipeline) Authenticate(pushType model.LogPushType, token, identity string) (string, error) {
+	schema, err := p.authenticate(token, identity)
+	if err != nil {
+		p.count(pushType).received.Add(1)
+		p.rejected(pushType, err)
+		return "", err
+	}
+	return schema, nil
+}
+
+func (p *Pipeline) authenticate(token, identity string) (string, error) {
+	if token == "" {
+		if p.config().RequireAPIKey && p.clientPrincipal(identity) == nil {
+			return "", reject(ReasonUnauthorized, "缺少 ingest token 或已授权的客户端证书")
+		}
+		return "", nil
+	}
+	if !strings.HasPrefix(token, db.APIKeyPrefix) {
+		return "", reject(ReasonUnauthorized, "ingest token 格式有误")
+	}
+
+	hash := db.HashAPIKey(token)
+	now := time.Now()
+	p.keyMu.Lock()
+	cached, ok := p.keys[hash]
+	p.keyMu.Unlock()
+	if !ok || now.After(cached.expires) {
+		key, err := p.store.LookupAPIKey(hash)
+		if err != nil {
+			p.log.Error(fmt.Sprintf("查询 ingest token 失败: %v", err))
+			return "", reject(ReasonSchemaLookup, "查询 ingest token 失败: %v", err)
+		}
+		if key == nil {
+			// 不缓存不存在的 token，避免随机 token 撑大缓存
+			return "", reject(ReasonUnauthorized, "ingest token 无效或已吊销")
+		}
+		cached = cachedAPIKey{key: key, expires: now.Add(apiKeyCacheTTL)}
+		p.keyMu.Lock()
+		p.keys[hash] = cached
+		p.keyMu.Unlock()
+	}
+
+	if !cached.key.Active() {
+		return "", reject(ReasonUnauthorized, "ingest token 无效或已吊销")
+	}
+	p.keyMu.Lock()
+	p.keyUsage[hash] = now
+	p.keyMu.Unlock()
+	return cached.key.Schema, nil
+}
+
+// ForgetAPIKeys 清空 token 缓存，本节点吊销 token 后调用使其立即失效
+func (p *Pipeline) ForgetAPIKeys() {
+	p.keyMu.Lock()
+	p.keys = make(map[string]cachedAPIKey)
+	p.keyMu.Unlock()
+}
+
+// SetClientCerts 设置按 TLS 客户端证书身份授权的规则，需在接收日志前调用。
+// 身份已配置的客户端只能写入拥有 writer 角色的 schema，未配置的身份不受限制
+func (p *Pipeline) SetClientCerts(certs auth.CertAuthenticator) {
+	p.certs = certs
+}
+
+// clientPrincipal 返回客户端证书身份对应的调用方，未配置时返回 nil
+func (p *Pipeline) clientPrincipal(identity string) *auth.Principal {
+	if p.certs == nil || identity == "" {
+		return nil
+	}
+	principal, err := p.certs.AuthenticateCert(identity)
+	if err != nil {
+		return nil
+	}
+	return principal
+}
+
+// authorizeClient 校验客户端证书身份对日志 schema 的写入权限
+func (p *Pipeline) authorizeClient(entry *model.Log) error {
+	principal := p.clientPrincipal(entry.ClientIdentity)
+	if principal != nil && !principal.Can(string(entry.Schema), auth.RoleWriter) {
+		return reject(ReasonForbidden, "客户端证书 %s 没有 schema %s 的写入权限", entry.ClientIdentity, entry.Schema)
+	}
+	return nil
+}
+
+// SubmitAs 提交已通过 Authenticate 的日志，schema 为 token 所属的 schema 或传输方式固定的 schema；
+// 日志未指定 schema 时使用该 schema，指定了其他 schema 时拒绝
+func (p *Pipeline) SubmitAs(schema string, entry *model.Log) error {
+	if schema != "" {
+		if entry.Schema != "" && string(entry.Schema) != schema {
+			err := reject(ReasonForbidden, "只能写入 schema %s，不能写入 %s", schema, entry.Schema)
+			p.count(entry.PushType).received.Add(1)
+			p.rejected(entry.PushType, err)
+			return err
+		}
+		entry.Schema = model.LogSchema(schema)
+	}
+	return p.Submit(entry)
+}
+
+// flushAPIKeyUsage 把 token 的最后使用时间批量写回存储
+func (p *Pipeline) flushAPIKeyUsage() {
+	p.keyMu.Lock()
+	usage := p.keyUsage
+	p.keyUsage = make(map[string]time.Time)
+	p.keyMu.Unlock()
+	if len(usage) == 0 {
+		return
+	}
+	if err := p.store.TouchAPIKeys(usage); err != nil {
+		p.log.Error(fmt.Sprintf("更新 ingest token 使用时间失败: %v", err))
+	}
+}
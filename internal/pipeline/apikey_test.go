@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/auth"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+func rejectReason(err error) string {
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		return rejectErr.Reason
+	}
+	return ""
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	p, store := newTestPipeline(t, Config{BatchSize: 100, BatchTimeout: time.Hour, RequireAPIKey: true})
+	if _, err := store.GetOrCreateSchema("pay"); err != nil {
+		t.Fatalf("创建 schema 失败: %v", err)
+	}
+	key, token, err := store.CreateAPIKey("shop", "ci")
+	if err != nil {
+		t.Fatalf("创建 api key 失败: %v", err)
+	}
+	// 轮换：同一 schema 的第二个 token 同时有效
+	_, token2, err := store.CreateAPIKey("shop", "ci-next")
+	if err != nil {
+		t.Fatalf("创建 api key 失败: %v", err)
+	}
+
+	if _, err := p.Authenticate(model.PushTypeHTTP, "", ""); rejectReason(err) != ReasonUnauthorized {
+		t.Errorf("要求 token 时缺少 token 应被拒绝，实际 %v", err)
+	}
+	if _, err := p.Authenticate(model.PushTypeHTTP, db.APIKeyPrefix+"guess", ""); rejectReason(err) != ReasonUnauthorized {
+		t.Errorf("无效 token 应被拒绝，实际 %v", err)
+	}
+	for _, tk := range []string{token, token2} {
+		schema, err := p.Authenticate(model.PushTypeHTTP, tk, "")
+		if err != nil || schema != "shop" {
+			t.Fatalf("有效 token 应返回 shop，实际 %q, %v", schema, err)
+		}
+	}
+
+	entry := newLog(model.PushTypeHTTP, "order", "svc")
+	entry.Schema = "pay"
+	if err := p.SubmitAs("shop", entry); rejectReason(err) != ReasonForbidden {
+		t.Errorf("token 写入其他 schema 应被拒绝，实际 %v", err)
+	}
+	entry.Schema = ""
+	if err := p.SubmitAs("shop", entry); err != nil || entry.Schema != "shop" {
+		t.Errorf("未指定 schema 时应使用 token 的 schema，实际 %q, %v", entry.Schema, err)
+	}
+	req := &Request{Token: token, SchemaID: db.GenerateSchemaID("pay"), Module: "order", Service: "svc", Output: "x"}
+	if err := p.SubmitRequest(req, model.PushTypeTCP, "127.0.0.1", "127.0.0.1:1", ""); rejectReason(err) != ReasonForbidden {
+		t.Errorf("TCP token 与 schema_id 不一致应被拒绝，实际 %v", err)
+	}
+
+	p.flushAPIKeyUsage()
+	keys, _ := store.ListAPIKeys("shop")
+	if len(keys) != 2 || keys[0].LastUsedAt == nil || keys[1].LastUsedAt == nil {
+		t.Errorf("最后使用时间未写回: %+v", keys)
+	}
+
+	if err := store.RevokeAPIKey("shop", key.ID); err != nil {
+		t.Fatalf("吊销 api key 失败: %v", err)
+	}
+	p.ForgetAPIKeys()
+	if _, err := p.Authenticate(model.PushTypeHTTP, token, ""); rejectReason(err) != ReasonUnauthorized {
+		t.Errorf("已吊销的 token 应被拒绝，实际 %v", err)
+	}
+	if _, err := p.Authenticate(model.PushTypeHTTP, token2, ""); err != nil {
+		t.Errorf("吊销一个 token 不应影响另一个: %v", err)
+	}
+
+	stats := p.Stats().Transports[model.PushTypeHTTP]
+	if stats.Reasons[ReasonUnauthorized] != 3 || stats.Reasons[ReasonForbidden] != 1 {
+		t.Errorf("拒绝统计不符合预期: %+v", stats.Reasons)
+	}
+}
+
+func TestClientCertAuthorization(t *testing.T) {
+	p, store := newTestPipeline(t, Config{BatchSize: 100, BatchTimeout: time.Hour, RequireAPIKey: true})
+	for _, name := range []string{"shop", "pay"} {
+		if _, err := store.GetOrCreateSchema(name); err != nil {
+			t.Fatalf("创建 schema 失败: %v", err)
+		}
+	}
+	certs, err := auth.NewCerts([]auth.ClientCert{{Identity: "shop-gateway", Roles: map[string]string{"shop": "writer"}}})
+	if err != nil {
+		t.Fatalf("创建客户端证书授权失败: %v", err)
+	}
+	p.SetClientCerts(certs)
+
+	if _, err := p.Authenticate(model.PushTypeTCP, "", "unknown-client"); rejectReason(err) != ReasonUnauthorized {
+		t.Errorf("未授权的证书身份缺少 token 时应被拒绝，实际 %v", err)
+	}
+	req := &Request{SchemaID: db.GenerateSchemaID("shop"), Module: "order", Service: "svc", Output: "x"}
+	if err := p.SubmitRequest(req, model.PushTypeTCP, "127.0.0.1", "127.0.0.1:1", "shop-gateway"); err != nil {
+		t.Fatalf("已授权的证书身份应可代替 token: %v", err)
+	}
+	req.SchemaID = db.GenerateSchemaID("pay")
+	if err := p.SubmitRequest(req, model.PushTypeTCP, "127.0.0.1", "127.0.0.1:1", "shop-gateway"); rejectReason(err) != ReasonForbidden {
+		t.Errorf("证书身份写入没有权限的 schema 应被拒绝，实际 %v", err)
+	}
+	if p.Flush() != 1 {
+		t.Fatalf("应写入 1 条日志")
+	}
+	page, err := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order", ClientIdentity: "shop-gateway"})
+	if err != nil || len(page.Logs) != 1 || page.Logs[0].ClientIdentity != "shop-gateway" {
+		t.Errorf("日志应记录客户端证书身份，实际 %+v, %v", page.Logs, err)
+	}
+}
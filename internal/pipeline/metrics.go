@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"github.com/vkeeps/agera-logs/internal/metrics"
+)
+
+var (
+	batchSizeHistogram = metrics.NewHistogramVec("agera_pipeline_batch_size",
+		"每次批量写入的日志条数", []float64{1, 5, 10, 20, 50, 100, 200, 500, 1000, 5000})
+	schemaCacheRequests = metrics.NewCounterVec("agera_schema_cache_requests_total",
+		"schema_id 解析次数，result 为 hit（命中流水线缓存）、miss（查询存储后命中）或 unknown（未注册）", "result")
+	schemaCacheRebuilds = metrics.NewCounterVec("agera_schema_cache_rebuilds_total",
+		"schema_id 未注册时触发的缓存重建次数")
+)
+
+func init() {
+	metrics.Default.MustRegister(batchSizeHistogram, schemaCacheRequests, schemaCacheRebuilds)
+}
+
+// Collectors 以回调方式导出流水线统计：各传输方式的接收、拒绝、丢弃计数和缓冲区占用
+func (p *Pipeline) Collectors() []metrics.Collector {
+	transportCounter := func(name, help string, value func(TransportStats) int64) metrics.Collector {
+		return metrics.NewFunc(name, help, metrics.CounterType, []string{"transport"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for transport, ts := range p.Stats().Transports {
+				samples = append(samples, metrics.Sample{Labels: []string{string(transport)}, Value: float64(value(ts))})
+			}
+			return samples
+		})
+	}
+	reasonCounter := func(name, help string, value func(TransportStats) map[string]int64) metrics.Collector {
+		return metrics.NewFunc(name, help, metrics.CounterType, []string{"transport", "reason"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for transport, ts := range p.Stats().Transports {
+				for reason, n := range value(ts) {
+					samples = append(samples, metrics.Sample{Labels: []string{string(transport), reason}, Value: float64(n)})
+				}
+			}
+			return samples
+		})
+	}
+	return []metrics.Collector{
+		transportCounter("agera_ingest_received_total", "传输层收到的日志条数",
+			func(ts TransportStats) int64 { return ts.Received }),
+		transportCounter("agera_ingest_accepted_total", "进入缓冲区的日志条数",
+			func(ts TransportStats) int64 { return ts.Accepted }),
+		reasonCounter("agera_ingest_rejected_total", "被流水线拒绝的日志条数",
+			func(ts TransportStats) map[string]int64 { return ts.Reasons }),
+		transportCounter("agera_ingest_clamped_total", "事件时间超出允许范围被修正的日志条数",
+			func(ts TransportStats) int64 { return ts.Clamped }),
+		reasonCounter("agera_ingest_dropped_total", "传输层提交前丢弃的日志条数",
+			func(ts TransportStats) map[string]int64 { return ts.Dropped }),
+		metrics.NewGaugeFunc("agera_pipeline_buffered", "缓冲区中等待写入的日志条数",
+			func() float64 { return float64(p.Stats().Buffered) }),
+		metrics.NewGaugeFunc("agera_pipeline_buffer_capacity", "缓冲区容量",
+			func() float64 { return float64(p.Stats().Capacity) }),
+		metrics.NewFunc("agera_pipeline_written_total", "批量写入存储的日志条数，result 为 ok、error（已丢失）或 spooled（保留在 spool 中由后台重放）",
+			metrics.CounterType, []string{"result"}, func() []metrics.Sample {
+				s := p.Stats()
+				return []metrics.Sample{
+					{Labels: []string{"ok"}, Value: float64(s.Inserted)},
+					{Labels: []string{"error"}, Value: float64(s.Failed)},
+					{Labels: []string{"spooled"}, Value: float64(s.Spooled)},
+				}
+			}),
+	}
+}
@@ -0,0 +1,441 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/auth"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/spool"
+)
+
+// Config 批量写入参数，均可通过 Reconfigure 在运行时调整
+type Config struct {
+	BatchSize           int           `yaml:"batch_size"`            // 缓冲区攒够多少条立即写入
+	BatchTimeout        time.Duration `yaml:"batch_timeout"`         // 最长等待多久写入一次
+	BufferCapacity      int           `yaml:"buffer_capacity"`       // 缓冲区容量，满了之后丢弃新日志
+	SchemaLookupTimeout time.Duration `yaml:"schema_lookup_timeout"` // schema 未注册时等待缓存重建的时间
+	RateLimit           float64       `yaml:"rate_limit"`            // 每秒最多接收的日志条数，0 表示不限
+	RateBurst           int           `yaml:"rate_burst"`            // 限流允许的突发条数，默认等于 RateLimit
+	RequireAPIKey       bool          `yaml:"require_api_key"`       // 默认为 true，拒绝没有 ingest token 的日志；false 时接受旧的 schema 名称 / schema_id
+	MaxFutureSkew       time.Duration `yaml:"max_future_skew"`       // 事件时间最多比接收时间晚多少，0 表示不限
+	MaxPastSkew         time.Duration `yaml:"max_past_skew"`         // 事件时间最多比接收时间早多少，0 表示不限
+	SkewPolicy          string        `yaml:"skew_policy"`           // 事件时间超出范围时 clamp（修正到边界）或 reject（拒绝）
+}
+
+// 事件时间超出允许范围时的处理方式
+const (
+	SkewClamp  = "clamp"
+	SkewReject = "reject"
+)
+
+// DefaultConfig 默认参数
+func DefaultConfig() Config {
+	return Config{
+		BatchSize:           20,
+		BatchTimeout:        1 * time.Millisecond,
+		BufferCapacity:      500,
+		SchemaLookupTimeout: 100 * time.Millisecond,
+		MaxFutureSkew:       5 * time.Minute,
+		MaxPastSkew:         7 * 24 * time.Hour,
+		SkewPolicy:          SkewClamp,
+		RequireAPIKey:       true,
+	}
+}
+
+// withDefaults 未设置的字段使用默认值
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	if c.BatchTimeout <= 0 {
+		c.BatchTimeout = d.BatchTimeout
+	}
+	if c.BufferCapacity <= 0 {
+		c.BufferCapacity = d.BufferCapacity
+	}
+	if c.SchemaLookupTimeout <= 0 {
+		c.SchemaLookupTimeout = d.SchemaLookupTimeout
+	}
+	if c.SkewPolicy == "" {
+		c.SkewPolicy = d.SkewPolicy
+	}
+	return c
+}
+
+// 拒绝原因
+const (
+	ReasonMissingService = "missing_service"
+	ReasonMissingModule  = "missing_module"
+	ReasonMissingSchema  = "missing_schema"
+	ReasonUnknownSchema  = "unknown_schema"
+	ReasonSchemaLookup   = "schema_lookup_failed"
+	ReasonBufferFull     = "buffer_full"
+	ReasonRateLimited    = "rate_limited"
+	ReasonUnauthorized   = "unauthorized"
+	ReasonForbidden      = "forbidden"
+	ReasonInvalidName    = "invalid_name"
+	ReasonInvalidAttrs   = "invalid_attributes"
+	ReasonClockSkew      = "clock_skew"
+	ReasonSpoolFailed    = "spool_failed"
+)
+
+// RejectError 日志未被接收的原因，Reason 为上面定义的固定值，便于统计
+type RejectError struct {
+	Reason string
+	Detail string
+}
+
+func (e *RejectError) Error() string {
+	return e.Detail
+}
+
+func reject(reason, format string, args ...any) *RejectError {
+	return &RejectError{Reason: reason, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Observer 在日志被流水线接收后收到通知，实现不得阻塞
+type Observer interface {
+	Observe(entry *model.Log)
+}
+
+// Pipeline 所有传输层共用的接收流水线：校验、schema 解析、批量缓冲和写入
+type Pipeline struct {
+	store db.Storage
+	cfg   Config
+	log   *logrus.Logger
+
+	appendMu sync.Mutex // 串行化 Submit 的 WAL 追加和放入缓冲区，使 records 的顺序与 WAL 一致；追加 WAL 时不持有 mu
+	mu       sync.Mutex // 保护 cfg、buffer、records 和 limiter
+	buffer   []*model.Log
+	records  []spool.Record // 设置了 spool 时 buffer 中每条日志对应的 WAL 记录
+	limiter  *rateLimiter
+	spool    *spool.Spool // 写前日志，为 nil 时 Flush 直接写入 store
+
+	schemaMu sync.RWMutex
+	schemas  map[string]string // schema_id -> schema 名称
+
+	keyMu    sync.Mutex
+	keys     map[string]cachedAPIKey // token 哈希 -> 查询结果
+	keyUsage map[string]time.Time    // 尚未写回的最后使用时间
+	certs    auth.CertAuthenticator  // 客户端证书授权，未配置时为 nil
+
+	flushMu sync.Mutex // 保证同一时间只有一个批次在写入，写入顺序与接收顺序一致
+	kick    chan struct{}
+	reload  chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	statsMu sync.Mutex
+	stats   map[model.LogPushType]*counters
+
+	observerMu sync.RWMutex
+	observers  []Observer
+
+	inserted atomic.Int64
+	failed   atomic.Int64
+	spooled  atomic.Int64 // 写入失败或 spool 有积压时留给 spool 重放的条数
+}
+
+// New 创建流水线，调用 Start 后开始定时写入
+func New(store db.Storage, cfg Config, log *logrus.Logger) *Pipeline {
+	cfg = cfg.withDefaults()
+	return &Pipeline{
+		store:    store,
+		cfg:      cfg,
+		log:      log,
+		buffer:   make([]*model.Log, 0, cfg.BufferCapacity),
+		limiter:  newRateLimiter(cfg.RateLimit, cfg.RateBurst),
+		schemas:  make(map[string]string),
+		keys:     make(map[string]cachedAPIKey),
+		keyUsage: make(map[string]time.Time),
+		kick:     make(chan struct{}, 1),
+		reload:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		stats:    make(map[model.LogPushType]*counters),
+	}
+}
+
+// SetSpool 设置写前日志：Submit 先把日志追加到 WAL 再确认接收，Flush 通过 spool 写入存储，
+// 进程在写入前退出时由 spool 重放。需在 Start 之前调用
+func (p *Pipeline) SetSpool(sp *spool.Spool) {
+	p.mu.Lock()
+	p.spool = sp
+	p.mu.Unlock()
+}
+
+// Reconfigure 在运行时更新批量写入和限流参数，缓冲区中已有的日志不受影响
+func (p *Pipeline) Reconfigure(cfg Config) {
+	cfg = cfg.withDefaults()
+	p.mu.Lock()
+	p.cfg = cfg
+	p.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	p.mu.Unlock()
+	select {
+	case p.reload <- struct{}{}:
+	default:
+	}
+	p.log.Info(fmt.Sprintf("流水线参数已更新: %+v", cfg))
+}
+
+func (p *Pipeline) config() Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cfg
+}
+
+// Start 启动后台写入协程
+func (p *Pipeline) Start() {
+	go p.run()
+}
+
+// Stop 停止后台写入并写入缓冲区中剩余的日志
+func (p *Pipeline) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+func (p *Pipeline) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.config().BatchTimeout)
+	defer ticker.Stop()
+	usageTicker := time.NewTicker(apiKeyUsageInterval)
+	defer usageTicker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Flush()
+		case <-usageTicker.C:
+			p.flushAPIKeyUsage()
+		case <-p.reload:
+			ticker.Reset(p.config().BatchTimeout)
+		case <-p.kick:
+			p.Flush()
+		case <-p.stop:
+			if n := p.Flush(); n > 0 {
+				p.log.Info(fmt.Sprintf("停止服务，插入剩余 %d 条日志", n))
+			}
+			p.flushAPIKeyUsage()
+			return
+		}
+	}
+}
+
+// AddObserver 注册日志接收通知，例如实时 tail
+func (p *Pipeline) AddObserver(o Observer) {
+	p.observerMu.Lock()
+	p.observers = append(p.observers, o)
+	p.observerMu.Unlock()
+}
+
+// Capacity 缓冲区容量，传输层据此设置自身的接收队列长度
+func (p *Pipeline) Capacity() int {
+	return p.config().BufferCapacity
+}
+
+// Submit 校验日志并放入缓冲区，设置了 spool 时先追加到 WAL，返回 nil 后日志不会因进程退出而丢失。
+// 未被接收时返回 *RejectError
+func (p *Pipeline) Submit(entry *model.Log) error {
+	p.count(entry.PushType).received.Add(1)
+	if err := p.validate(entry); err != nil {
+		p.rejected(entry.PushType, err)
+		return err
+	}
+
+	p.appendMu.Lock()
+	p.mu.Lock()
+	if !p.limiter.allow(time.Now()) {
+		p.mu.Unlock()
+		p.appendMu.Unlock()
+		err := reject(ReasonRateLimited, "超过接收速率限制，丢弃日志")
+		p.rejected(entry.PushType, err)
+		return err
+	}
+	if len(p.buffer) >= p.cfg.BufferCapacity {
+		p.mu.Unlock()
+		p.appendMu.Unlock()
+		err := reject(ReasonBufferFull, "缓冲区已满，丢弃日志")
+		p.log.Error(fmt.Sprintf("缓冲区已满，丢弃日志: %v", entry))
+		p.rejected(entry.PushType, err)
+		return err
+	}
+	sp := p.spool
+	p.mu.Unlock()
+
+	// 追加 WAL（always 策略下每次 fsync）时不持有 mu，Flush 和 Stats 不用等待磁盘
+	var record spool.Record
+	if sp != nil {
+		var err error
+		if record, err = sp.Append([]*model.Log{entry}); err != nil {
+			p.appendMu.Unlock()
+			rejectErr := reject(ReasonSpoolFailed, "写入 spool 失败，丢弃日志: %v", err)
+			p.log.Error(rejectErr.Error())
+			p.rejected(entry.PushType, rejectErr)
+			return rejectErr
+		}
+	}
+
+	p.mu.Lock()
+	if sp != nil {
+		p.records = append(p.records, record)
+	}
+	p.buffer = append(p.buffer, entry)
+	full := len(p.buffer) >= p.cfg.BatchSize
+	p.mu.Unlock()
+	p.appendMu.Unlock()
+
+	count := p.count(entry.PushType).accepted.Add(1)
+	p.log.Debug(fmt.Sprintf("%s 收到第 %d 条数据，从 %s", entry.PushType, count, entry.ClientAddr))
+	p.observerMu.RLock()
+	for _, o := range p.observers {
+		o.Observe(entry)
+	}
+	p.observerMu.RUnlock()
+	if full {
+		select {
+		case p.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush 立即写入缓冲区中的日志，返回本次写入的条数
+func (p *Pipeline) Flush() int {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	if len(p.buffer) == 0 {
+		p.mu.Unlock()
+		return 0
+	}
+	entries := make([]*model.Log, len(p.buffer))
+	copy(entries, p.buffer)
+	p.buffer = p.buffer[:0]
+	records := make([]spool.Record, len(p.records))
+	copy(records, p.records)
+	p.records = p.records[:0]
+	sp := p.spool
+	p.mu.Unlock()
+
+	batchSizeHistogram.With().Observe(float64(len(entries)))
+	start := time.Now()
+	var err error
+	deferred := false
+	if sp != nil {
+		deferred, err = sp.Commit(entries, records)
+	} else {
+		err = p.store.InsertLogs(entries)
+	}
+	if err == nil && deferred {
+		p.spooled.Add(int64(len(entries)))
+		p.log.Info(fmt.Sprintf("spool 中有积压，%d 条日志交给后台按顺序重放", len(entries)))
+		return len(entries)
+	}
+	if err != nil {
+		failed := len(entries)
+		var insertErr *db.InsertError
+		if errors.As(err, &insertErr) {
+			failed = 0
+			for _, f := range insertErr.Failures {
+				failed += len(f.Entries)
+			}
+		}
+		p.inserted.Add(int64(len(entries) - failed))
+		// 已追加到 WAL 的日志由 spool 重放，不算丢失
+		if sp != nil {
+			p.spooled.Add(int64(failed))
+			p.log.Warn(fmt.Sprintf("批量插入 %d 条日志失败，%d 条保留在 spool 中等待重放: %v", len(entries), failed, err))
+			return len(entries)
+		}
+		p.failed.Add(int64(failed))
+		p.log.Error(fmt.Sprintf("批量插入 %d 条日志失败: %v", len(entries), err))
+		return len(entries)
+	}
+	total := p.inserted.Add(int64(len(entries)))
+	p.log.Info(fmt.Sprintf("成功插入 %d 条日志，耗时 %v，总计插入 %d 条", len(entries), time.Since(start), total))
+	return len(entries)
+}
+
+// validate 校验必填字段并确认 schema 已注册
+func (p *Pipeline) validate(entry *model.Log) error {
+	if entry.Service == "" {
+		p.log.Error(fmt.Sprintf("%s 日志缺少 service 字段，跳过插入，原始数据: %+v", entry.PushType, entry))
+		return reject(ReasonMissingService, "service 字段为空")
+	}
+	if entry.Module == "" {
+		p.log.Error(fmt.Sprintf("%s 日志缺少 module 字段，跳过插入，原始数据: %+v", entry.PushType, entry))
+		return reject(ReasonMissingModule, "module 字段为空")
+	}
+	if entry.Schema == "" {
+		return reject(ReasonMissingSchema, "schema 为空")
+	}
+	if err := db.ValidateNames(string(entry.Schema), string(entry.Module)); err != nil {
+		return reject(ReasonInvalidName, "%v", err)
+	}
+	if err := p.authorizeClient(entry); err != nil {
+		return err
+	}
+	if err := db.ValidateAttributes(entry.Attributes); err != nil {
+		return reject(ReasonInvalidAttrs, "%v", err)
+	}
+	if err := p.checkEventTime(entry); err != nil {
+		return err
+	}
+	if _, err := p.ResolveSchemaID(db.GenerateSchemaID(string(entry.Schema))); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResolveSchemaID 根据 schema_id 获取 schema 名称；未注册时触发缓存重建并短暂等待
+func (p *Pipeline) ResolveSchemaID(schemaID string) (string, error) {
+	if schemaID == "" {
+		return "", reject(ReasonMissingSchema, "schema_id 为空")
+	}
+	p.schemaMu.RLock()
+	schemaName, ok := p.schemas[schemaID]
+	p.schemaMu.RUnlock()
+	if ok {
+		schemaCacheRequests.With("hit").Inc()
+		return schemaName, nil
+	}
+
+	schemaName, err := p.store.GetSchemaNameByID(schemaID)
+	if err != nil {
+		p.log.Error(fmt.Sprintf("获取 schema_id %s 失败: %v", schemaID, err))
+		return "", reject(ReasonSchemaLookup, "获取 schema_id %s 失败: %v", schemaID, err)
+	}
+	if schemaName == "" {
+		p.log.Warn(fmt.Sprintf("无效的 schema_id: %s，未在 BoltDB 中注册", schemaID))
+		schemaCacheRebuilds.With().Inc()
+		p.store.RebuildSchemaCache(schemaID)
+		start := time.Now()
+		for time.Since(start) < p.config().SchemaLookupTimeout {
+			schemaName, err = p.store.GetSchemaNameByID(schemaID)
+			if err == nil && schemaName != "" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if schemaName == "" {
+			schemaCacheRequests.With("unknown").Inc()
+			p.log.Error(fmt.Sprintf("重试后仍无效的 schema_id: %s，跳过插入", schemaID))
+			return "", reject(ReasonUnknownSchema, "无效的 schema_id: %s，未在 BoltDB 中注册", schemaID)
+		}
+	}
+
+	schemaCacheRequests.With("miss").Inc()
+	p.schemaMu.Lock()
+	p.schemas[schemaID] = schemaName
+	p.schemaMu.Unlock()
+	return schemaName, nil
+}
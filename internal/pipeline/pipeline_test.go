@@ -0,0 +1,290 @@
+package pipeline
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/spool"
+)
+
+func newTestPipeline(t *testing.T, cfg Config) (*Pipeline, *db.MemoryStorage) {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	store := db.NewMemoryStorage()
+	if _, err := store.GetOrCreateSchema("shop"); err != nil {
+		t.Fatalf("创建 schema 失败: %v", err)
+	}
+	cfg.SchemaLookupTimeout = 10 * time.Millisecond
+	return New(store, cfg, log), store
+}
+
+func newLog(pushType model.LogPushType, module, service string) *model.Log {
+	return &model.Log{
+		LogBase:   model.LogBase{Output: "hello", Service: service},
+		Schema:    "shop",
+		Module:    model.LogModule(module),
+		PushType:  pushType,
+		Timestamp: time.Now(),
+	}
+}
+
+func TestSubmitRejections(t *testing.T) {
+	p, _ := newTestPipeline(t, Config{BatchSize: 10, BatchTimeout: time.Hour, BufferCapacity: 1})
+
+	cases := []struct {
+		name   string
+		entry  *model.Log
+		reason string
+	}{
+		{"缺少 service", newLog(model.PushTypeHTTP, "order", ""), ReasonMissingService},
+		{"缺少 module", newLog(model.PushTypeHTTP, "", "svc"), ReasonMissingModule},
+		{"未注册 schema", &model.Log{LogBase: model.LogBase{Service: "svc"}, Schema: "nope", Module: "order", PushType: model.PushTypeHTTP}, ReasonUnknownSchema},
+		{"正常接收", newLog(model.PushTypeHTTP, "order", "svc"), ""},
+		{"缓冲区已满", newLog(model.PushTypeHTTP, "order", "svc"), ReasonBufferFull},
+	}
+	for _, tc := range cases {
+		err := p.Submit(tc.entry)
+		if tc.reason == "" {
+			if err != nil {
+				t.Errorf("%s: 预期接收，实际拒绝: %v", tc.name, err)
+			}
+			continue
+		}
+		var rejectErr *RejectError
+		if !errors.As(err, &rejectErr) || rejectErr.Reason != tc.reason {
+			t.Errorf("%s: 预期拒绝原因 %s，实际 %v", tc.name, tc.reason, err)
+		}
+	}
+
+	stats := p.Stats().Transports[model.PushTypeHTTP]
+	if stats.Received != 5 || stats.Accepted != 1 || stats.Rejected != 4 {
+		t.Errorf("统计不符合预期: %+v", stats)
+	}
+	if stats.Reasons[ReasonBufferFull] != 1 {
+		t.Errorf("buffer_full 计数预期 1，实际 %d", stats.Reasons[ReasonBufferFull])
+	}
+}
+
+func TestFlushOnBatchSize(t *testing.T) {
+	p, store := newTestPipeline(t, Config{BatchSize: 3, BatchTimeout: time.Hour, BufferCapacity: 100})
+	p.Start()
+	defer p.Stop()
+
+	for i := 0; i < 3; i++ {
+		if err := p.Submit(newLog(model.PushTypeTCP, "order", "svc")); err != nil {
+			t.Fatalf("提交失败: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+		logs := page.Logs
+		if len(logs) == 3 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("攒够 BatchSize 后应立即写入")
+}
+
+func TestStopFlushesRemaining(t *testing.T) {
+	p, store := newTestPipeline(t, Config{BatchSize: 100, BatchTimeout: time.Hour, BufferCapacity: 100})
+	p.Start()
+
+	req := &Request{SchemaID: db.GenerateSchemaID("shop"), Module: "pay", Service: "svc", Output: "x"}
+	if err := p.SubmitRequest(req, model.PushTypeUDP, "10.0.0.1", "10.0.0.1:5000", ""); err != nil {
+		t.Fatalf("提交失败: %v", err)
+	}
+	if err := p.Submit(newLog(model.PushTypeGRPC, "order", "svc")); err != nil {
+		t.Fatalf("提交失败: %v", err)
+	}
+	p.Stop()
+
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop"})
+	logs := page.Logs
+	if len(logs) != 2 {
+		t.Fatalf("停止时应写入剩余 2 条日志，实际 %d 条", len(logs))
+	}
+	if p.Stats().Inserted != 2 {
+		t.Errorf("inserted 计数预期 2，实际 %d", p.Stats().Inserted)
+	}
+}
+
+func TestReconfigureRateLimit(t *testing.T) {
+	p, _ := newTestPipeline(t, Config{BatchSize: 10, BatchTimeout: time.Hour, BufferCapacity: 100})
+	for i := 0; i < 5; i++ {
+		if err := p.Submit(newLog(model.PushTypeHTTP, "order", "svc")); err != nil {
+			t.Fatalf("未限流时不应拒绝: %v", err)
+		}
+	}
+
+	p.Reconfigure(Config{BatchSize: 10, BatchTimeout: time.Hour, BufferCapacity: 100, RateLimit: 0.001, RateBurst: 2})
+	var accepted, limited int
+	for i := 0; i < 5; i++ {
+		var rejectErr *RejectError
+		if err := p.Submit(newLog(model.PushTypeHTTP, "order", "svc")); err == nil {
+			accepted++
+		} else if errors.As(err, &rejectErr) && rejectErr.Reason == ReasonRateLimited {
+			limited++
+		}
+	}
+	if accepted != 2 || limited != 3 {
+		t.Errorf("预期突发 2 条后限流，实际接收 %d 条，限流 %d 条", accepted, limited)
+	}
+	if got := p.Stats().Transports[model.PushTypeHTTP].Reasons[ReasonRateLimited]; got != 3 {
+		t.Errorf("限流统计应为 3，实际 %d", got)
+	}
+}
+
+func TestSubmitAppendsToSpoolBeforeAck(t *testing.T) {
+	p, store := newTestPipeline(t, Config{BatchSize: 10, BatchTimeout: time.Hour})
+	opts := spool.Options{Dir: t.TempDir(), Sync: spool.SyncAlways, RetryInterval: 10 * time.Millisecond}
+	sp, err := spool.Open(store, opts, p.log)
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	p.SetSpool(sp)
+
+	if err := p.Submit(newLog(model.PushTypeTCP, "order", "svc")); err != nil {
+		t.Fatalf("提交失败: %v", err)
+	}
+	// 确认接收时日志已在 WAL 中；在后台写入之前不会被重放
+	time.Sleep(50 * time.Millisecond)
+	if st := sp.Status(); st.PendingLogs != 1 || st.ReplayedLogs != 0 {
+		t.Fatalf("确认前应已追加到 spool，实际 %+v", st)
+	}
+
+	// 模拟进程在写入前退出：缓冲区丢失，重启后由 spool 重放
+	sp.Close()
+	sp, err = spool.Open(store, opts, p.log)
+	if err != nil {
+		t.Fatalf("重新打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for sp.Status().PendingLogs != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+	if len(page.Logs) != 1 {
+		t.Errorf("重启后应重放已确认的日志，实际 %d 条", len(page.Logs))
+	}
+
+	// 重启后的流水线正常写入并推进检查点
+	p = New(store, Config{BatchSize: 10, BatchTimeout: time.Hour}, p.log)
+	p.SetSpool(sp)
+	if err := p.Submit(newLog(model.PushTypeTCP, "order", "svc")); err != nil {
+		t.Fatalf("提交失败: %v", err)
+	}
+	if n := p.Flush(); n != 1 || sp.Status().PendingLogs != 0 {
+		t.Errorf("写入后 spool 不应有积压，实际写入 %d 条，状态 %+v", n, sp.Status())
+	}
+}
+
+// slowStorage InsertLogs 阻塞到 release 关闭，模拟响应缓慢的 ClickHouse
+type slowStorage struct {
+	*db.MemoryStorage
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStorage) InsertLogs(entries []*model.Log) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.MemoryStorage.InsertLogs(entries)
+}
+
+func TestSubmitDoesNotWaitForStorage(t *testing.T) {
+	p, store := newTestPipeline(t, Config{BatchSize: 10, BatchTimeout: time.Hour})
+	slow := &slowStorage{MemoryStorage: store, entered: make(chan struct{}, 10), release: make(chan struct{})}
+	sp, err := spool.Open(slow, spool.Options{Dir: t.TempDir(), Sync: spool.SyncAlways, RetryInterval: 10 * time.Millisecond}, p.log)
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+	p.SetSpool(sp)
+
+	if err := p.Submit(newLog(model.PushTypeTCP, "order", "svc")); err != nil {
+		t.Fatalf("提交失败: %v", err)
+	}
+	flushed := make(chan int)
+	go func() { flushed <- p.Flush() }()
+	<-slow.entered
+
+	// ClickHouse 写入阻塞期间仍能接收日志并查询统计
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := p.Submit(newLog(model.PushTypeTCP, "order", "svc")); err != nil {
+			t.Errorf("提交失败: %v", err)
+		}
+		p.Stats()
+		sp.Status()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("写入存储时 Submit 和 Stats 不应阻塞")
+	}
+
+	close(slow.release)
+	if n := <-flushed; n != 1 {
+		t.Errorf("应写入 1 条日志，实际 %d 条", n)
+	}
+	p.Flush()
+	deadline := time.Now().Add(2 * time.Second)
+	for sp.Status().PendingLogs != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+	if len(page.Logs) != 2 {
+		t.Errorf("应写入 2 条日志，实际 %d 条", len(page.Logs))
+	}
+}
+
+// downStorage InsertLogs 总是失败，模拟 ClickHouse 不可用
+type downStorage struct {
+	*db.MemoryStorage
+}
+
+func (downStorage) InsertLogs([]*model.Log) error {
+	return errors.New("clickhouse 不可用")
+}
+
+func TestSpooledFailuresAreNotCountedAsLost(t *testing.T) {
+	p, store := newTestPipeline(t, Config{BatchSize: 10, BatchTimeout: time.Hour})
+	sp, err := spool.Open(downStorage{store}, spool.Options{Dir: t.TempDir(), Sync: spool.SyncNever, RetryInterval: time.Hour}, p.log)
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+	p.SetSpool(sp)
+
+	// 第一批写入失败，第二批遇到积压直接交给 spool，都不算丢失
+	for i := 0; i < 2; i++ {
+		if err := p.Submit(newLog(model.PushTypeTCP, "order", "svc")); err != nil {
+			t.Fatalf("提交失败: %v", err)
+		}
+		p.Flush()
+	}
+	if s := p.Stats(); s.Failed != 0 || s.Spooled != 2 || s.Inserted != 0 {
+		t.Errorf("保留在 spool 中的日志应计入 spooled，实际 %+v", s)
+	}
+
+	// 没有 spool 时写入失败计入 failed
+	p = New(downStorage{store}, Config{BatchSize: 10, BatchTimeout: time.Hour}, p.log)
+	if err := p.Submit(newLog(model.PushTypeTCP, "order", "svc")); err != nil {
+		t.Fatalf("提交失败: %v", err)
+	}
+	p.Flush()
+	if s := p.Stats(); s.Failed != 1 || s.Spooled != 0 {
+		t.Errorf("没有 spool 时写入失败应计入 failed，实际 %+v", s)
+	}
+}
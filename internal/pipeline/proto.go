@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/proto"
+)
+
+// ProtoToLog 把 protobuf 格式的日志转换为 model.Log，gRPC 和 TCP protobuf 分帧共用
+func ProtoToLog(req *proto.LogRequest, pushType model.LogPushType, clientIP, clientAddr, identity string) *model.Log {
+	return &model.Log{
+		LogBase: model.LogBase{
+			Output:     req.Output,
+			Detail:     req.Detail,
+			ErrorInfo:  req.ErrorInfo,
+			Service:    req.Service,
+			ClientIP:   clientIP,
+			ClientAddr: clientAddr,
+			LogLevel:   req.LogLevel,
+		},
+		Schema:            model.LogSchema(req.Schema),
+		Module:            model.LogModule(req.Module),
+		PushType:          pushType,
+		Timestamp:         eventTime(req.TimestampUnixNano),
+		ReceiveTime:       time.Now(),
+		OperatorID:        req.OperatorID,
+		Operator:          req.Operator,
+		OperatorIP:        req.OperatorIP,
+		OperatorEquipment: req.OperatorEquipment,
+		OperatorCompany:   req.OperatorCompany,
+		OperatorProject:   req.OperatorProject,
+		Attributes:        req.Attributes,
+		ClientIdentity:    identity,
+	}
+}
+
+// SubmitProto 校验请求中的 token 后提交 protobuf 格式的日志，schema 取 token 所属 schema 或请求中的 schema 名称
+func (p *Pipeline) SubmitProto(req *proto.LogRequest, pushType model.LogPushType, clientIP, clientAddr, identity string) error {
+	schema, err := p.Authenticate(pushType, req.Token, identity)
+	if err != nil {
+		return err
+	}
+	return p.SubmitAs(schema, ProtoToLog(req, pushType, clientIP, clientAddr, identity))
+}
+
+// eventTime 把 Unix 纳秒转换为事件时间，0 表示未提供
+func eventTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
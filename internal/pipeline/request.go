@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// Request TCP / UDP 推送的 JSON 日志格式，通过 token 或 schema_id 指定 schema
+type Request struct {
+	Token             string            `json:"token,omitempty"` // ingest token，提供时 schema 由 token 决定
+	SchemaID          string            `json:"schema_id,schemaId"`
+	Module            string            `json:"module"`
+	Output            string            `json:"output"`
+	Detail            string            `json:"detail,omitempty"`
+	ErrorInfo         string            `json:"error_info,omitempty"`
+	Service           string            `json:"service,omitempty"`
+	LogLevel          string            `json:"log_level,omitempty"`
+	OperatorID        string            `json:"operator_id,operatorId"`
+	Operator          string            `json:"operator,omitempty"`
+	OperatorIP        string            `json:"operator_ip,omitempty"`
+	OperatorEquipment string            `json:"operator_equipment,omitempty"`
+	OperatorCompany   string            `json:"operator_company,omitempty"`
+	OperatorProject   string            `json:"operator_project,omitempty"`
+	Attributes        map[string]string `json:"attributes,omitempty"`
+	Timestamp         model.EventTime   `json:"timestamp"` // 事件时间，RFC 3339 或 Unix 纳秒，不填时使用接收时间
+}
+
+// ToLog 转换为 model.Log，schemaName 为解析 SchemaID 得到的 schema 名称，identity 为 TLS 客户端证书的身份
+func (r *Request) ToLog(schemaName string, pushType model.LogPushType, clientIP, clientAddr, identity string) *model.Log {
+	return &model.Log{
+		LogBase: model.LogBase{
+			Output:     r.Output,
+			Detail:     r.Detail,
+			ErrorInfo:  r.ErrorInfo,
+			Service:    r.Service,
+			ClientIP:   clientIP,
+			ClientAddr: clientAddr,
+			LogLevel:   r.LogLevel,
+		},
+		Schema:            model.LogSchema(schemaName),
+		Module:            model.LogModule(r.Module),
+		PushType:          pushType,
+		Timestamp:         r.Timestamp.Time,
+		ReceiveTime:       time.Now(),
+		OperatorID:        r.OperatorID,
+		Operator:          r.Operator,
+		OperatorIP:        r.OperatorIP,
+		OperatorEquipment: r.OperatorEquipment,
+		OperatorCompany:   r.OperatorCompany,
+		OperatorProject:   r.OperatorProject,
+		Attributes:        r.Attributes,
+		ClientIdentity:    identity,
+	}
+}
+
+// SubmitRequest 校验 token 或解析 schema_id 后提交 TCP / UDP 请求，identity 为 TLS 客户端证书的身份，没有时为空
+func (p *Pipeline) SubmitRequest(req *Request, pushType model.LogPushType, clientIP, clientAddr, identity string) error {
+	schemaName, err := p.Authenticate(pushType, req.Token, identity)
+	if err != nil {
+		return err
+	}
+	if schemaName != "" {
+		if req.SchemaID != "" && req.SchemaID != db.GenerateSchemaID(schemaName) {
+			err := reject(ReasonForbidden, "ingest token 不能写入 schema_id %s", req.SchemaID)
+			p.count(pushType).received.Add(1)
+			p.rejected(pushType, err)
+			return err
+		}
+		return p.Submit(req.ToLog(schemaName, pushType, clientIP, clientAddr, identity))
+	}
+
+	schemaName, err = p.ResolveSchemaID(req.SchemaID)
+	if err != nil {
+		p.count(pushType).received.Add(1)
+		p.rejected(pushType, err)
+		return err
+	}
+	return p.Submit(req.ToLog(schemaName, pushType, clientIP, clientAddr, identity))
+}
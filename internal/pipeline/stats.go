@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// counters 单个传输方式的计数
+type counters struct {
+	received atomic.Int64
+	accepted atomic.Int64
+	rejected atomic.Int64
+	clamped  atomic.Int64
+	reasons  map[string]*atomic.Int64
+	dropped  map[string]*atomic.Int64
+}
+
+// TransportStats 单个传输方式的统计快照
+type TransportStats struct {
+	Received int64            `json:"received"`
+	Accepted int64            `json:"accepted"`
+	Rejected int64            `json:"rejected"`
+	Clamped  int64            `json:"clamped,omitempty"` // 事件时间超出允许范围被修正的条数
+	Reasons  map[string]int64 `json:"reasons,omitempty"`
+	Dropped  map[string]int64 `json:"dropped,omitempty"` // 进入流水线之前被传输层丢弃的条数
+}
+
+// Stats 流水线统计快照
+type Stats struct {
+	Transports map[model.LogPushType]TransportStats `json:"transports"`
+	Buffered   int                                  `json:"buffered"`
+	Capacity   int                                  `json:"capacity"`
+	Inserted   int64                                `json:"inserted"`
+	Failed     int64                                `json:"failed"`  // 没有 spool 时写入失败、已丢失的条数
+	Spooled    int64                                `json:"spooled"` // 写入失败或有积压，保留在 spool 中由后台重放的条数
+}
+
+func (p *Pipeline) count(pushType model.LogPushType) *counters {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	c, ok := p.stats[pushType]
+	if !ok {
+		c = &counters{reasons: make(map[string]*atomic.Int64), dropped: make(map[string]*atomic.Int64)}
+		p.stats[pushType] = c
+	}
+	return c
+}
+
+func (p *Pipeline) rejected(pushType model.LogPushType, err error) {
+	c := p.count(pushType)
+	c.rejected.Add(1)
+	reason := "unknown"
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		reason = rejectErr.Reason
+	}
+	p.reasonCounter(c.reasons, reason).Add(1)
+}
+
+// Drop 记录传输层在提交之前丢弃的日志，例如接收队列已满
+func (p *Pipeline) Drop(pushType model.LogPushType, reason string) {
+	p.reasonCounter(p.count(pushType).dropped, reason).Add(1)
+}
+
+func (p *Pipeline) reasonCounter(m map[string]*atomic.Int64, reason string) *atomic.Int64 {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	n, ok := m[reason]
+	if !ok {
+		n = new(atomic.Int64)
+		m[reason] = n
+	}
+	return n
+}
+
+// Stats 返回当前统计快照
+func (p *Pipeline) Stats() Stats {
+	p.mu.Lock()
+	buffered, capacity := len(p.buffer), p.cfg.BufferCapacity
+	p.mu.Unlock()
+
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	s := Stats{
+		Transports: make(map[model.LogPushType]TransportStats, len(p.stats)),
+		Buffered:   buffered,
+		Capacity:   capacity,
+		Inserted:   p.inserted.Load(),
+		Failed:     p.failed.Load(),
+		Spooled:    p.spooled.Load(),
+	}
+	for pushType, c := range p.stats {
+		ts := TransportStats{
+			Received: c.received.Load(),
+			Accepted: c.accepted.Load(),
+			Rejected: c.rejected.Load(),
+			Clamped:  c.clamped.Load(),
+		}
+		ts.Reasons = snapshot(c.reasons)
+		ts.Dropped = snapshot(c.dropped)
+		s.Transports[pushType] = ts
+	}
+	return s
+}
+
+func snapshot(m map[string]*atomic.Int64) map[string]int64 {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]int64, len(m))
+	for reason, n := range m {
+		out[reason] = n.Load()
+	}
+	return out
+}
@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// checkEventTime 补全接收时间和事件时间，并检查事件时间与接收时间的偏差：
+// 客户端时钟不准或日志重试过久时，按 SkewPolicy 修正到允许范围的边界或拒绝
+func (p *Pipeline) checkEventTime(entry *model.Log) error {
+	if entry.ReceiveTime.IsZero() {
+		entry.ReceiveTime = time.Now()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = entry.ReceiveTime
+		return nil
+	}
+
+	cfg := p.config()
+	bound := entry.Timestamp
+	if cfg.MaxFutureSkew > 0 {
+		if latest := entry.ReceiveTime.Add(cfg.MaxFutureSkew); entry.Timestamp.After(latest) {
+			bound = latest
+		}
+	}
+	if cfg.MaxPastSkew > 0 {
+		if earliest := entry.ReceiveTime.Add(-cfg.MaxPastSkew); entry.Timestamp.Before(earliest) {
+			bound = earliest
+		}
+	}
+	if bound.Equal(entry.Timestamp) {
+		return nil
+	}
+
+	skew := entry.Timestamp.Sub(entry.ReceiveTime)
+	if cfg.SkewPolicy == SkewReject {
+		return reject(ReasonClockSkew, "事件时间 %s 与接收时间相差 %v，超出允许范围", entry.Timestamp.Format(time.RFC3339Nano), skew)
+	}
+	p.count(entry.PushType).clamped.Add(1)
+	p.log.Debug(fmt.Sprintf("%s 日志事件时间与接收时间相差 %v，修正为 %s", entry.PushType, skew, bound.Format(time.RFC3339Nano)))
+	entry.Timestamp = bound
+	return nil
+}
@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+func TestEventTimeSkew(t *testing.T) {
+	received := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	cfg := Config{BatchSize: 10, BatchTimeout: time.Hour, BufferCapacity: 10, MaxFutureSkew: time.Minute, MaxPastSkew: time.Hour}
+	p, _ := newTestPipeline(t, cfg)
+
+	cases := []struct {
+		name  string
+		event time.Time
+		want  time.Time
+	}{
+		{"未提供时使用接收时间", time.Time{}, received},
+		{"范围内保持不变", received.Add(-30 * time.Minute), received.Add(-30 * time.Minute)},
+		{"过晚修正到上界", received.Add(time.Hour), received.Add(time.Minute)},
+		{"过早修正到下界", received.Add(-48 * time.Hour), received.Add(-time.Hour)},
+	}
+	for _, tc := range cases {
+		entry := newLog(model.PushTypeHTTP, "order", "svc")
+		entry.Timestamp, entry.ReceiveTime = tc.event, received
+		if err := p.Submit(entry); err != nil {
+			t.Fatalf("%s: 提交失败: %v", tc.name, err)
+		}
+		if !entry.Timestamp.Equal(tc.want) {
+			t.Errorf("%s: 事件时间预期 %v，实际 %v", tc.name, tc.want, entry.Timestamp)
+		}
+	}
+	if got := p.Stats().Transports[model.PushTypeHTTP].Clamped; got != 2 {
+		t.Errorf("修正条数预期 2，实际 %d", got)
+	}
+
+	cfg.SkewPolicy = SkewReject
+	p.Reconfigure(cfg)
+	entry := newLog(model.PushTypeHTTP, "order", "svc")
+	entry.Timestamp, entry.ReceiveTime = received.Add(time.Hour), received
+	if reason := rejectReason(p.Submit(entry)); reason != ReasonClockSkew {
+		t.Errorf("reject 策略下预期拒绝原因 %s，实际 %s", ReasonClockSkew, reason)
+	}
+}
+
+func TestRequestTimestamp(t *testing.T) {
+	for _, raw := range []string{
+		`{"timestamp": "2025-06-01T12:00:00.123456Z"}`,
+		`{"timestamp": 1748779200123456000}`,
+		`{"timestamp": "1748779200123456000"}`,
+	} {
+		var req Request
+		if err := json.Unmarshal([]byte(raw), &req); err != nil {
+			t.Fatalf("解析 %s 失败: %v", raw, err)
+		}
+		if want := time.Date(2025, 6, 1, 12, 0, 0, 123456000, time.UTC); !req.Timestamp.Equal(want) {
+			t.Errorf("%s 解析结果 %v", raw, req.Timestamp)
+		}
+	}
+	var req Request
+	if err := json.Unmarshal([]byte(`{"timestamp": "yesterday"}`), &req); err == nil {
+		t.Errorf("无效的时间格式应返回错误")
+	}
+	if err := json.Unmarshal([]byte(`{"output": "x"}`), &req); err != nil || !req.Timestamp.IsZero() {
+		t.Errorf("未提供 timestamp 时应为零值: %v", err)
+	}
+}
@@ -0,0 +1,485 @@
+package spool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// SyncPolicy WAL 落盘策略
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // 每次追加后 fsync，最安全
+	SyncInterval SyncPolicy = "interval" // 按固定间隔 fsync，宕机最多丢失一个间隔的数据
+	SyncNever    SyncPolicy = "never"    // 交给操作系统刷盘
+)
+
+// ErrSpoolFull 积压超过上限时拒绝写入
+var ErrSpoolFull = errors.New("spool 积压已达上限")
+
+// Options spool 配置
+type Options struct {
+	Dir           string        `yaml:"dir"`            // WAL 目录
+	MaxBytes      int64         `yaml:"max_bytes"`      // 磁盘占用上限，超过后拒绝写入
+	SegmentBytes  int64         `yaml:"segment_bytes"`  // 单个段文件大小，超过后切换新段
+	Sync          SyncPolicy    `yaml:"fsync"`          // 落盘策略
+	SyncInterval  time.Duration `yaml:"fsync_interval"` // SyncInterval 策略下的 fsync 间隔
+	RetryInterval time.Duration `yaml:"retry_interval"` // ClickHouse 不可用时的重放间隔
+	MaxRetries    int           `yaml:"max_retries"`    // 同一条记录在 ClickHouse 可用时写入失败多少次后移入死信文件，0 表示一直重试
+}
+
+// DefaultOptions 默认配置，WAL 放在 LOG_BASE_PATH/spool
+func DefaultOptions() Options {
+	base := os.Getenv("LOG_BASE_PATH")
+	if base == "" {
+		base, _ = os.Getwd()
+	}
+	return Options{
+		Dir:           filepath.Join(base, "spool"),
+		MaxBytes:      512 << 20,
+		SegmentBytes:  64 << 20,
+		Sync:          SyncInterval,
+		SyncInterval:  time.Second,
+		RetryInterval: 5 * time.Second,
+		MaxRetries:    10,
+	}
+}
+
+// Validate 校验配置
+func (o Options) Validate() error {
+	if o.Dir == "" {
+		return fmt.Errorf("spool 目录不能为空")
+	}
+	switch o.Sync {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return fmt.Errorf("spool 落盘策略无效: %s", o.Sync)
+	}
+	if o.MaxBytes < 0 || o.SegmentBytes < 0 {
+		return fmt.Errorf("spool 大小限制不能为负数")
+	}
+	if o.MaxRetries < 0 {
+		return fmt.Errorf("spool 重试次数不能为负数")
+	}
+	return nil
+}
+
+// Status spool 当前状态，通过 HTTP 暴露
+type Status struct {
+	Dir            string     `json:"dir"`
+	SyncPolicy     SyncPolicy `json:"sync_policy"`
+	Segments       int        `json:"segments"`
+	DiskBytes      int64      `json:"disk_bytes"`
+	MaxBytes       int64      `json:"max_bytes"`
+	PendingRecords int64      `json:"pending_records"`
+	PendingLogs    int64      `json:"pending_logs"`
+	ReplayedLogs   int64      `json:"replayed_logs"`
+	DeadLetterLogs int64      `json:"dead_letter_logs"` // 超过重试次数移入死信文件的日志条数
+	LastError      string     `json:"last_error,omitempty"`
+	LastErrorAt    *time.Time `json:"last_error_at,omitempty"`
+	LastReplayAt   *time.Time `json:"last_replay_at,omitempty"`
+}
+
+// Spool 写前日志：日志先追加到本地 WAL 再写 ClickHouse，写入失败的部分留在 WAL 中，
+// 由后台按写入顺序重放。它包装一个 db.Storage，除 InsertLogs 外其余方法直接透传。
+type Spool struct {
+	db.Storage
+	opts Options
+	log  *logrus.Logger
+
+	mu             sync.Mutex
+	segments       []int64 // 现存段文件编号，升序
+	w              *os.File
+	wSeg           int64
+	wOff           int64
+	dirty          bool
+	ckpt           position           // 已确认写入 ClickHouse 的位置
+	released       position           // 已交给 Commit 的记录的结束位置，之后的记录由 Append 的调用方负责写入
+	done           map[group]position // 部分分组失败时已写入成功的分组，以及写到了哪条记录为止，重放时跳过
+	inserting      bool               // Commit 或重放正在写入底层存储，写入时不持有 mu，同一时间只有一个写入方推进检查点
+	diskBytes      int64
+	pendingRecords int64
+	pendingLogs    int64
+	replayedLogs   int64
+	deadLetterLogs int64
+	retryPos       position     // 正在重试的记录
+	retries        int          // retryPos 处的记录在 ClickHouse 可用时写入失败的次数
+	probe          func() error // 检查 ClickHouse 是否可用，为 nil 时所有写入失败都计入重试次数
+	lastErr        error
+	lastErrAt      time.Time
+	lastReplayAt   time.Time
+
+	kick    chan struct{}
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// Open 打开（或创建）WAL 目录，加载检查点并启动后台重放
+func Open(store db.Storage, opts Options, log *logrus.Logger) (*Spool, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 64 << 20
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Second
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 5 * time.Second
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建 spool 目录 %s 失败: %v", opts.Dir, err)
+	}
+
+	s := &Spool{
+		Storage: store,
+		opts:    opts,
+		log:     log,
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if s.pendingRecords > 0 {
+		log.Warn(fmt.Sprintf("spool 中有 %d 批（%d 条）日志待重放", s.pendingRecords, s.pendingLogs))
+		s.signal()
+	}
+
+	s.stopped.Add(1)
+	go s.replayLoop()
+	if opts.Sync == SyncInterval {
+		s.stopped.Add(1)
+		go s.syncLoop()
+	}
+	log.Info(fmt.Sprintf("spool 初始化成功，目录: %s，落盘策略: %s", opts.Dir, opts.Sync))
+	return s, nil
+}
+
+// SetProbe 设置 ClickHouse 可用性检查。写入失败且检查也失败时视为故障，不计入 MaxRetries，
+// 避免长时间故障后把正常的日志移入死信文件
+func (s *Spool) SetProbe(probe func() error) {
+	s.mu.Lock()
+	s.probe = probe
+	s.mu.Unlock()
+}
+
+// Record 一条已追加到 WAL 的记录，由 Append 返回，写入存储时交给 Commit
+type Record struct {
+	start, end position
+}
+
+// Append 把日志作为一条记录追加到 WAL，积压超过上限时返回 ErrSpoolFull。
+// 返回后日志即使进程退出也不会丢失；调用方负责随后调用 Commit，在此之前后台不会重放这条记录
+func (s *Spool) Append(entries []*model.Log) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := s.writePos()
+	end, err := s.appendLocked(entries)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{start: start, end: end}, nil
+}
+
+// Commit 写入 Append 追加的记录，records 按追加顺序排列且中间没有其他记录，entries 为这些记录中的全部日志。
+// 之前没有积压时直接写入底层存储并推进检查点，否则留给后台按顺序重放。写入失败的日志留在 WAL 的原位置，
+// 部分分组失败时记录已成功的分组，重放时只写入失败的分组。deferred 为 true 表示日志没有全部写入、留给后台重放，
+// 此时 err 为底层存储的写入错误，有积压时为 nil。写入底层存储时不持有锁，Append 和 Status 不会等待 ClickHouse
+func (s *Spool) Commit(entries []*model.Log, records []Record) (deferred bool, err error) {
+	if len(records) == 0 {
+		return false, nil
+	}
+	last := records[len(records)-1].end
+	s.mu.Lock()
+	if s.released.before(last) {
+		s.released = last
+	}
+	// 有积压时由后台重放；重放正在进行时它会在退出前看到新的 released，继续写入这些记录
+	if s.ckpt != records[0].start || s.inserting {
+		s.mu.Unlock()
+		return true, nil
+	}
+	s.inserting = true
+	s.mu.Unlock()
+
+	err = s.Storage.InsertLogs(entries)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inserting = false
+	if err != nil {
+		s.recordErrorLocked(err)
+		s.log.Warn(fmt.Sprintf("%d 条日志写入失败，已保留在 spool 中等待重放: %v", len(entries), err))
+		s.markDoneLocked(succeededGroups(entries, err), last)
+		return true, err
+	}
+	s.commitLocked(last, int64(len(records)), int64(len(entries)))
+	return false, nil
+}
+
+// InsertLogs 先追加到 WAL 再写入底层存储，见 Commit。只要落盘成功即返回 nil
+func (s *Spool) InsertLogs(entries []*model.Log) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	record, err := s.Append(entries)
+	if err != nil {
+		if errors.Is(err, ErrSpoolFull) {
+			return err
+		}
+		// WAL 不可写时退化为直接写入，避免磁盘故障导致整体不可用
+		s.log.Error(fmt.Sprintf("写入 spool 失败，直接写入存储: %v", err))
+		return s.Storage.InsertLogs(entries)
+	}
+	s.Commit(entries, []Record{record})
+	return nil
+}
+
+// Status 返回当前状态
+func (s *Spool) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{
+		Dir:            s.opts.Dir,
+		SyncPolicy:     s.opts.Sync,
+		Segments:       len(s.segments),
+		DiskBytes:      s.diskBytes,
+		MaxBytes:       s.opts.MaxBytes,
+		PendingRecords: s.pendingRecords,
+		PendingLogs:    s.pendingLogs,
+		ReplayedLogs:   s.replayedLogs,
+		DeadLetterLogs: s.deadLetterLogs,
+	}
+	if s.lastErr != nil {
+		t := s.lastErrAt
+		st.LastError = s.lastErr.Error()
+		st.LastErrorAt = &t
+	}
+	if !s.lastReplayAt.IsZero() {
+		t := s.lastReplayAt
+		st.LastReplayAt = &t
+	}
+	return st
+}
+
+// Close 停止后台任务并落盘
+func (s *Spool) Close() error {
+	close(s.stop)
+	s.stopped.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	if err := s.w.Sync(); err != nil {
+		s.log.Error(fmt.Sprintf("spool 落盘失败: %v", err))
+	}
+	err := s.w.Close()
+	s.w = nil
+	return err
+}
+
+func (s *Spool) signal() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Spool) recordErrorLocked(err error) {
+	s.lastErr = err
+	s.lastErrAt = time.Now()
+}
+
+// group 一张表对应的 schema 和 module
+type group struct {
+	Schema string `json:"schema"`
+	Module string `json:"module"`
+}
+
+func groupOf(entry *model.Log) group {
+	return group{Schema: string(entry.Schema), Module: string(entry.Module)}
+}
+
+// succeededGroups 部分分组写入失败时返回写入成功的分组。
+// 错误不是 *db.InsertError 或所有分组都失败时视为 ClickHouse 不可用，返回 nil
+func succeededGroups(entries []*model.Log, err error) []group {
+	var insertErr *db.InsertError
+	if !errors.As(err, &insertErr) {
+		return nil
+	}
+	failed := make(map[group]bool, len(insertErr.Failures))
+	for _, f := range insertErr.Failures {
+		failed[group{Schema: f.Schema, Module: f.Module}] = true
+	}
+	var succeeded []group
+	seen := make(map[group]bool)
+	for _, entry := range entries {
+		g := groupOf(entry)
+		if !failed[g] && !seen[g] {
+			seen[g] = true
+			succeeded = append(succeeded, g)
+		}
+	}
+	return succeeded
+}
+
+// markDoneLocked 记录 groups 在 through 及之前的记录中已写入成功并持久化，重放时不再重复写入
+func (s *Spool) markDoneLocked(groups []group, through position) {
+	if len(groups) == 0 {
+		return
+	}
+	if s.done == nil {
+		s.done = make(map[group]position)
+	}
+	for _, g := range groups {
+		s.done[g] = through
+	}
+	s.saveCheckpointLocked()
+}
+
+// remainingLocked 去掉结束于 end 的记录中已写入成功的分组
+func (s *Spool) remainingLocked(entries []*model.Log, end position) []*model.Log {
+	if len(s.done) == 0 {
+		return entries
+	}
+	var remaining []*model.Log
+	for _, entry := range entries {
+		if through, ok := s.done[groupOf(entry)]; !ok || through.before(end) {
+			remaining = append(remaining, entry)
+		}
+	}
+	return remaining
+}
+
+func (s *Spool) replayLoop() {
+	defer s.stopped.Done()
+	ticker := time.NewTicker(s.opts.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.kick:
+		case <-ticker.C:
+		}
+		s.replay()
+	}
+}
+
+// replay 从检查点开始按顺序重放已交给 Commit 或重启前遗留的记录。
+// 遇到失败（包括部分分组失败）即停止，记录留在原位置等待下次重试；ClickHouse 可用时同一条记录失败
+// MaxRetries 次后，把失败的分组移入死信文件并继续，避免一张写不进去的表阻塞后面所有的日志。
+// 写入底层存储时释放锁，inserting 在判断没有更多记录的同一临界区内清除，不会漏掉 Commit 新交来的记录
+func (s *Spool) replay() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inserting {
+		return
+	}
+	s.inserting = true
+	defer func() { s.inserting = false }()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		if s.pendingRecords == 0 || s.ckpt == s.released {
+			return
+		}
+		entries, next, err := s.readAt(s.ckpt)
+		if err != nil {
+			s.recordErrorLocked(err)
+			s.log.Error(fmt.Sprintf("读取 spool 记录失败: %v", err))
+			return
+		}
+		if next.segment != s.ckpt.segment && entries == nil {
+			// 当前段已读完，跳到下一段
+			s.commitLocked(next, 0, 0)
+			continue
+		}
+		remaining := s.remainingLocked(entries, next)
+		written := len(remaining)
+		if len(remaining) > 0 {
+			probe := s.probe
+			s.mu.Unlock()
+			err := s.Storage.InsertLogs(remaining)
+			outage := err != nil && probe != nil && probe() != nil
+			s.mu.Lock()
+			if err != nil {
+				s.recordErrorLocked(err)
+				s.markDoneLocked(succeededGroups(remaining, err), next)
+				if outage || !s.retryExhaustedLocked(next) {
+					return
+				}
+				// 已写入成功的分组不进入死信文件
+				failed := s.remainingLocked(remaining, next)
+				if err := s.deadLetterLocked(failed); err != nil {
+					s.log.Error(fmt.Sprintf("写入 spool 死信文件失败: %v", err))
+					return
+				}
+				s.log.Error(fmt.Sprintf("%d 条日志重试 %d 次仍写入失败，已移入死信文件 %s: %v",
+					len(failed), s.retries, s.deadLetterPath(), err))
+				written -= len(failed)
+			}
+		}
+		s.commitLocked(next, 1, int64(len(entries)))
+		s.replayedLogs += int64(written)
+		s.lastReplayAt = time.Now()
+		if s.pendingRecords == 0 {
+			s.log.Info(fmt.Sprintf("spool 积压已全部重放，累计重放 %d 条日志", s.replayedLogs))
+		}
+	}
+}
+
+// retryExhaustedLocked 记录 pos 处的记录又失败了一次，返回是否已达到 MaxRetries
+func (s *Spool) retryExhaustedLocked(pos position) bool {
+	if s.retryPos != pos {
+		s.retryPos = pos
+		s.retries = 0
+	}
+	s.retries++
+	return s.opts.MaxRetries > 0 && s.retries >= s.opts.MaxRetries
+}
+
+func (s *Spool) syncLoop() {
+	defer s.stopped.Done()
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty && s.w != nil {
+				if err := s.w.Sync(); err != nil {
+					s.log.Error(fmt.Sprintf("spool 落盘失败: %v", err))
+				}
+				s.dirty = false
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// encodeEntries 序列化一批日志作为一条 WAL 记录
+func encodeEntries(entries []*model.Log) ([]byte, error) {
+	return json.Marshal(entries)
+}
+
+func decodeEntries(data []byte) ([]*model.Log, error) {
+	var entries []*model.Log
+	err := json.Unmarshal(data, &entries)
+	return entries, err
+}
@@ -0,0 +1,426 @@
+package spool
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// flakyStorage 可切换可用状态的存储，不可用时 InsertLogs 返回错误
+type flakyStorage struct {
+	*db.MemoryStorage
+	mu   sync.Mutex
+	down bool
+}
+
+func (f *flakyStorage) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+func (f *flakyStorage) InsertLogs(entries []*model.Log) error {
+	f.mu.Lock()
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		return errors.New("clickhouse 不可用")
+	}
+	return f.MemoryStorage.InsertLogs(entries)
+}
+
+// insertErrorStorage 像 ClickHouseStorage 一样按分组返回 *db.InsertError，
+// failing 中的 module 写入失败，"*" 表示全部失败；written 按顺序记录写入成功的日志
+type insertErrorStorage struct {
+	*db.MemoryStorage
+	mu      sync.Mutex
+	failing map[string]bool
+	calls   int
+	written []string
+}
+
+func (f *insertErrorStorage) setFailing(modules ...string) {
+	f.mu.Lock()
+	f.failing = make(map[string]bool)
+	for _, m := range modules {
+		f.failing[m] = true
+	}
+	f.mu.Unlock()
+}
+
+func (f *insertErrorStorage) InsertLogs(entries []*model.Log) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	insertErr := &db.InsertError{Total: len(entries)}
+	failures := make(map[string]*db.GroupFailure)
+	var ok []*model.Log
+	for _, entry := range entries {
+		module := string(entry.Module)
+		if !f.failing["*"] && !f.failing[module] {
+			ok = append(ok, entry)
+			f.written = append(f.written, entry.Output)
+			continue
+		}
+		failure, exists := failures[module]
+		if !exists {
+			failure = &db.GroupFailure{Schema: string(entry.Schema), Module: module, Err: errors.New("clickhouse 不可用")}
+			failures[module] = failure
+		}
+		failure.Entries = append(failure.Entries, entry)
+	}
+	for _, failure := range failures {
+		insertErr.Failures = append(insertErr.Failures, *failure)
+	}
+	if err := f.MemoryStorage.InsertLogs(ok); err != nil {
+		return err
+	}
+	if len(insertErr.Failures) > 0 {
+		return insertErr
+	}
+	return nil
+}
+
+func (f *insertErrorStorage) stats() (int, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls, append([]string(nil), f.written...)
+}
+
+// blockingStorage InsertLogs 阻塞到 release 关闭，模拟响应缓慢的 ClickHouse
+type blockingStorage struct {
+	*db.MemoryStorage
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStorage) InsertLogs(entries []*model.Log) error {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.MemoryStorage.InsertLogs(entries)
+}
+
+func testOptions(t *testing.T) Options {
+	return Options{
+		Dir:           t.TempDir(),
+		MaxBytes:      1 << 20,
+		SegmentBytes:  512,
+		Sync:          SyncAlways,
+		RetryInterval: 10 * time.Millisecond,
+	}
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func newEntry(output string, ts time.Time) *model.Log {
+	return &model.Log{
+		LogBase:   model.LogBase{Output: output, Service: "svc"},
+		Schema:    "shop",
+		Module:    "order",
+		Timestamp: ts,
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("等待条件超时")
+}
+
+func TestReplayInOrderAfterRecovery(t *testing.T) {
+	store := &flakyStorage{MemoryStorage: db.NewMemoryStorage()}
+	sp, err := Open(store, testOptions(t), testLogger())
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+
+	store.setDown(true)
+	base := time.Now().Truncate(time.Second)
+	for i := 0; i < 20; i++ {
+		entry := newEntry(string(rune('a'+i)), base.Add(time.Duration(i)*time.Second))
+		if err := sp.InsertLogs([]*model.Log{entry}); err != nil {
+			t.Fatalf("ClickHouse 不可用时写入 spool 应成功: %v", err)
+		}
+	}
+	if st := sp.Status(); st.PendingLogs != 20 || st.LastError == "" {
+		t.Fatalf("状态不符合预期: %+v", st)
+	}
+
+	store.setDown(false)
+	waitFor(t, func() bool { return sp.Status().PendingLogs == 0 })
+
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+	logs := page.Logs
+	if len(logs) != 20 {
+		t.Fatalf("预期重放 20 条日志，实际 %d 条", len(logs))
+	}
+	// QueryLogs 按时间倒序返回，重放顺序正确时输出应为 t..a
+	for i, record := range logs {
+		if want := string(rune('a' + 19 - i)); record.Output != want {
+			t.Fatalf("第 %d 条预期 %s，实际 %s", i, want, record.Output)
+		}
+	}
+	if st := sp.Status(); st.Segments != 1 {
+		t.Errorf("重放完成后应只保留当前段，实际 %d 个", st.Segments)
+	}
+}
+
+func TestPendingSurvivesRestart(t *testing.T) {
+	opts := testOptions(t)
+	store := &flakyStorage{MemoryStorage: db.NewMemoryStorage()}
+	store.setDown(true)
+
+	sp, err := Open(store, opts, testLogger())
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := sp.InsertLogs([]*model.Log{newEntry("x", time.Now())}); err != nil {
+			t.Fatalf("写入 spool 失败: %v", err)
+		}
+	}
+	sp.Close()
+
+	sp, err = Open(store, opts, testLogger())
+	if err != nil {
+		t.Fatalf("重新打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+	if st := sp.Status(); st.PendingLogs != 5 {
+		t.Fatalf("重启后预期积压 5 条，实际 %d 条", st.PendingLogs)
+	}
+
+	store.setDown(false)
+	waitFor(t, func() bool { return sp.Status().PendingLogs == 0 })
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+	logs := page.Logs
+	if len(logs) != 5 {
+		t.Errorf("预期重放 5 条日志，实际 %d 条", len(logs))
+	}
+}
+
+func TestRejectWhenFull(t *testing.T) {
+	opts := testOptions(t)
+	opts.MaxBytes = 300
+	store := &flakyStorage{MemoryStorage: db.NewMemoryStorage()}
+	store.setDown(true)
+
+	sp, err := Open(store, opts, testLogger())
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+
+	var full bool
+	for i := 0; i < 10; i++ {
+		if err := sp.InsertLogs([]*model.Log{newEntry("x", time.Now())}); errors.Is(err, ErrSpoolFull) {
+			full = true
+			break
+		}
+	}
+	if !full {
+		t.Error("超过上限后应返回 ErrSpoolFull")
+	}
+}
+
+func TestOutageWaitsForRetry(t *testing.T) {
+	opts := testOptions(t)
+	opts.RetryInterval = time.Hour
+	store := &insertErrorStorage{MemoryStorage: db.NewMemoryStorage()}
+	store.setFailing("*")
+
+	sp, err := Open(store, opts, testLogger())
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+
+	if err := sp.InsertLogs([]*model.Log{newEntry("a", time.Now()), newEntry("b", time.Now())}); err != nil {
+		t.Fatalf("写入 spool 失败: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	sp.replay()
+	// 所有分组都失败时视为 ClickHouse 不可用：不重新追加，重放失败一次后等待下次重试
+	calls, _ := store.stats()
+	if st := sp.Status(); calls != 2 || st.Segments != 1 || st.PendingRecords != 1 || st.PendingLogs != 2 {
+		t.Fatalf("不可用时不应反复重试或重新追加，实际写入 %d 次，状态 %+v", calls, st)
+	}
+
+	store.setFailing()
+	sp.replay()
+	if _, written := store.stats(); len(written) != 2 || sp.Status().PendingLogs != 0 {
+		t.Errorf("恢复后应重放 2 条日志，实际 %v", written)
+	}
+}
+
+func TestPartialFailureReplaysInPlace(t *testing.T) {
+	opts := testOptions(t)
+	opts.RetryInterval = time.Hour
+	store := &insertErrorStorage{MemoryStorage: db.NewMemoryStorage()}
+	store.setFailing("pay")
+
+	sp, err := Open(store, opts, testLogger())
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	pay := newEntry("p1", time.Now())
+	pay.Module = "pay"
+	if err := sp.InsertLogs([]*model.Log{newEntry("a1", time.Now()), pay}); err != nil {
+		t.Fatalf("写入 spool 失败: %v", err)
+	}
+	if err := sp.InsertLogs([]*model.Log{newEntry("b1", time.Now())}); err != nil {
+		t.Fatalf("写入 spool 失败: %v", err)
+	}
+	sp.replay()
+	if st := sp.Status(); st.PendingRecords != 2 {
+		t.Fatalf("失败的分组应留在原位置，后续记录等待，实际 %+v", st)
+	}
+
+	// 已写入成功的分组在重启后仍然跳过
+	sp.Close()
+	sp, err = Open(store, opts, testLogger())
+	if err != nil {
+		t.Fatalf("重新打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+	store.setFailing()
+	sp.replay()
+
+	_, written := store.stats()
+	if want := []string{"a1", "p1", "b1"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("重放顺序不符合预期: %v", written)
+	}
+	if st := sp.Status(); st.PendingRecords != 0 || st.Segments != 1 {
+		t.Errorf("重放完成后状态不符合预期: %+v", st)
+	}
+}
+
+func TestDeadLetterAfterMaxRetries(t *testing.T) {
+	opts := testOptions(t)
+	opts.RetryInterval = time.Hour
+	opts.MaxRetries = 3
+	store := &insertErrorStorage{MemoryStorage: db.NewMemoryStorage()}
+	store.setFailing("pay")
+
+	sp, err := Open(store, opts, testLogger())
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+	// ClickHouse 不可用时不计入重试次数
+	down := true
+	sp.SetProbe(func() error {
+		if down {
+			return errors.New("clickhouse 不可用")
+		}
+		return nil
+	})
+
+	pay := newEntry("p1", time.Now())
+	pay.Module = "pay"
+	sp.InsertLogs([]*model.Log{newEntry("a1", time.Now()), pay})
+	sp.InsertLogs([]*model.Log{newEntry("b1", time.Now())})
+	for i := 0; i < opts.MaxRetries; i++ {
+		sp.replay()
+	}
+	if st := sp.Status(); st.PendingRecords != 2 || st.DeadLetterLogs != 0 {
+		t.Fatalf("故障期间不应移入死信文件，实际 %+v", st)
+	}
+
+	down = false
+	for i := 0; i < opts.MaxRetries; i++ {
+		sp.replay()
+	}
+	_, written := store.stats()
+	if want := []string{"a1", "b1"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("失败的分组移入死信文件后应继续重放，实际 %v", written)
+	}
+	if st := sp.Status(); st.PendingRecords != 0 || st.DeadLetterLogs != 1 {
+		t.Errorf("重试次数用完后应移入死信文件，实际 %+v", st)
+	}
+
+	f, err := os.Open(filepath.Join(opts.Dir, deadLetterFile))
+	if err != nil {
+		t.Fatalf("打开死信文件失败: %v", err)
+	}
+	defer f.Close()
+	_, _, payload, err := readRecord(f)
+	if err != nil {
+		t.Fatalf("读取死信记录失败: %v", err)
+	}
+	if entries, _ := decodeEntries(payload); len(entries) != 1 || entries[0].Output != "p1" {
+		t.Errorf("死信文件只应包含失败的分组，实际 %+v", entries)
+	}
+}
+
+func TestAppendDoesNotWaitForStorage(t *testing.T) {
+	store := &blockingStorage{MemoryStorage: db.NewMemoryStorage(), entered: make(chan struct{}, 10), release: make(chan struct{})}
+	sp, err := Open(store, testOptions(t), testLogger())
+	if err != nil {
+		t.Fatalf("打开 spool 失败: %v", err)
+	}
+	defer sp.Close()
+
+	first := []*model.Log{newEntry("first", time.Now())}
+	record, err := sp.Append(first)
+	if err != nil {
+		t.Fatalf("追加失败: %v", err)
+	}
+	committed := make(chan error)
+	go func() {
+		_, err := sp.Commit(first, []Record{record})
+		committed <- err
+	}()
+	<-store.entered
+
+	// 写入阻塞期间追加和查询状态不受影响
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		second := []*model.Log{newEntry("second", time.Now())}
+		record, err := sp.Append(second)
+		if err == nil {
+			_, err = sp.Commit(second, []Record{record})
+		}
+		if err != nil {
+			t.Errorf("追加失败: %v", err)
+		}
+		sp.Status()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("写入存储时追加和 Status 不应阻塞")
+	}
+
+	close(store.release)
+	if err := <-committed; err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	// 第二条记录交给 Commit 时第一条还在写入，由后台重放
+	waitFor(t, func() bool { return sp.Status().PendingLogs == 0 })
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+	if len(page.Logs) != 2 {
+		t.Errorf("应写入 2 条日志，实际 %d 条", len(page.Logs))
+	}
+}
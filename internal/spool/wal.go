@@ -0,0 +1,398 @@
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// 记录格式：payload 长度(4) + 日志条数(4) + payload 的 CRC32(4) + payload
+const headerSize = 12
+
+const (
+	segmentSuffix  = ".wal"
+	checkpointFile = "checkpoint"
+	deadLetterFile = "dead-letter.log" // 记录格式与段文件相同，不计入 MaxBytes，也不会被重放
+)
+
+// position WAL 中的位置
+type position struct {
+	segment int64
+	offset  int64
+}
+
+// before p 是否在 q 之前
+func (p position) before(q position) bool {
+	return p.segment < q.segment || (p.segment == q.segment && p.offset < q.offset)
+}
+
+// checkpoint 检查点文件的内容
+type checkpoint struct {
+	Segment int64       `json:"segment"`
+	Offset  int64       `json:"offset"`
+	Done    []doneGroup `json:"done,omitempty"` // 部分分组失败时已写入成功的分组
+}
+
+// doneGroup 分组在 Segment / Offset 及之前的记录中已写入成功
+type doneGroup struct {
+	group
+	Segment int64 `json:"through_segment"`
+	Offset  int64 `json:"through_offset"`
+}
+
+func (s *Spool) segmentPath(id int64) string {
+	return filepath.Join(s.opts.Dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+func (s *Spool) writePos() position {
+	return position{segment: s.wSeg, offset: s.wOff}
+}
+
+// load 扫描段文件、读取检查点、截断末尾不完整的记录并统计积压
+func (s *Spool) load() error {
+	files, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("读取 spool 目录失败: %v", err)
+	}
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, id)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	if len(s.segments) == 0 {
+		s.segments = []int64{1}
+	}
+	s.wSeg = s.segments[len(s.segments)-1]
+
+	// 找到最后一段中最后一条完整记录的位置，截断之后的残缺数据
+	validEnd, err := s.scanValidEnd(s.wSeg)
+	if err != nil {
+		return err
+	}
+	s.w, err = os.OpenFile(s.segmentPath(s.wSeg), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("打开 spool 段文件失败: %v", err)
+	}
+	if err := s.w.Truncate(validEnd); err != nil {
+		return fmt.Errorf("截断 spool 段文件失败: %v", err)
+	}
+	if _, err := s.w.Seek(validEnd, io.SeekStart); err != nil {
+		return fmt.Errorf("定位 spool 段文件失败: %v", err)
+	}
+	s.wOff = validEnd
+
+	for _, id := range s.segments {
+		if info, err := os.Stat(s.segmentPath(id)); err == nil {
+			s.diskBytes += info.Size()
+		}
+	}
+
+	s.ckpt = position{segment: s.segments[0]}
+	if data, err := os.ReadFile(filepath.Join(s.opts.Dir, checkpointFile)); err == nil {
+		var c checkpoint
+		if err := json.Unmarshal(data, &c); err == nil && c.Segment >= s.segments[0] {
+			s.ckpt = position{segment: c.Segment, offset: c.Offset}
+			for _, d := range c.Done {
+				if s.done == nil {
+					s.done = make(map[group]position)
+				}
+				s.done[d.group] = position{segment: d.Segment, offset: d.Offset}
+			}
+		}
+	}
+	if s.ckpt.segment > s.wSeg || (s.ckpt.segment == s.wSeg && s.ckpt.offset > s.wOff) {
+		s.ckpt = s.writePos()
+		s.done = nil
+	}
+	// 重启前追加的记录都由后台重放
+	s.released = s.writePos()
+	s.countDeadLetters()
+
+	return s.countPending()
+}
+
+// scanValidEnd 返回段文件中最后一条完整且校验通过的记录的结束位置
+func (s *Spool) scanValidEnd(id int64) (int64, error) {
+	f, err := os.Open(s.segmentPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("打开 spool 段文件失败: %v", err)
+	}
+	defer f.Close()
+
+	var off int64
+	for {
+		n, _, _, err := readRecord(f)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.log.Warn(fmt.Sprintf("spool 段 %d 在偏移 %d 处有残缺记录，将被截断: %v", id, off, err))
+			}
+			return off, nil
+		}
+		off += n
+	}
+}
+
+// countPending 统计检查点之后的记录数和日志条数
+func (s *Spool) countPending() error {
+	for _, id := range s.segments {
+		if id < s.ckpt.segment {
+			continue
+		}
+		f, err := os.Open(s.segmentPath(id))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("打开 spool 段文件失败: %v", err)
+		}
+		if id == s.ckpt.segment {
+			if _, err := f.Seek(s.ckpt.offset, io.SeekStart); err != nil {
+				f.Close()
+				return fmt.Errorf("定位 spool 段文件失败: %v", err)
+			}
+		}
+		for {
+			_, count, _, err := readRecord(f)
+			if err != nil {
+				break
+			}
+			s.pendingRecords++
+			s.pendingLogs += int64(count)
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// encodeRecord 把一批日志编码为一条完整的记录
+func encodeRecord(entries []*model.Log) ([]byte, error) {
+	payload, err := encodeEntries(entries)
+	if err != nil {
+		return nil, fmt.Errorf("序列化日志失败: %v", err)
+	}
+	buf := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(entries)))
+	binary.BigEndian.PutUint32(buf[8:12], crc32.ChecksumIEEE(payload))
+	copy(buf[headerSize:], payload)
+	return buf, nil
+}
+
+// appendLocked 追加一条记录，返回记录结束的位置
+func (s *Spool) appendLocked(entries []*model.Log) (position, error) {
+	buf, err := encodeRecord(entries)
+	if err != nil {
+		return position{}, err
+	}
+	size := int64(len(buf))
+	if s.opts.MaxBytes > 0 && s.diskBytes+size > s.opts.MaxBytes {
+		return position{}, ErrSpoolFull
+	}
+	if s.wOff > 0 && s.wOff+size > s.opts.SegmentBytes {
+		if err := s.rotateLocked(); err != nil {
+			return position{}, err
+		}
+	}
+
+	if _, err := s.w.Write(buf); err != nil {
+		// 写入失败时回滚到写入前的位置，避免留下残缺记录
+		s.w.Truncate(s.wOff)
+		s.w.Seek(s.wOff, io.SeekStart)
+		return position{}, fmt.Errorf("写入 spool 失败: %v", err)
+	}
+	if s.opts.Sync == SyncAlways {
+		if err := s.w.Sync(); err != nil {
+			return position{}, fmt.Errorf("spool 落盘失败: %v", err)
+		}
+	} else {
+		s.dirty = true
+	}
+
+	s.wOff += size
+	s.diskBytes += size
+	s.pendingRecords++
+	s.pendingLogs += int64(len(entries))
+	return s.writePos(), nil
+}
+
+func (s *Spool) deadLetterPath() string {
+	return filepath.Join(s.opts.Dir, deadLetterFile)
+}
+
+// deadLetterLocked 把多次重试仍写入失败的日志作为一条记录追加到死信文件并落盘
+func (s *Spool) deadLetterLocked(entries []*model.Log) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	buf, err := encodeRecord(entries)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.deadLetterPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开死信文件失败: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf); err != nil {
+		return fmt.Errorf("写入死信文件失败: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("死信文件落盘失败: %v", err)
+	}
+	s.deadLetterLogs += int64(len(entries))
+	return nil
+}
+
+// countDeadLetters 统计死信文件中的日志条数
+func (s *Spool) countDeadLetters() {
+	f, err := os.Open(s.deadLetterPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	for {
+		_, count, _, err := readRecord(f)
+		if err != nil {
+			return
+		}
+		s.deadLetterLogs += int64(count)
+	}
+}
+
+// rotateLocked 关闭当前段并创建下一个段
+func (s *Spool) rotateLocked() error {
+	if err := s.w.Sync(); err != nil {
+		return fmt.Errorf("spool 落盘失败: %v", err)
+	}
+	if err := s.w.Close(); err != nil {
+		return fmt.Errorf("关闭 spool 段文件失败: %v", err)
+	}
+	next := s.wSeg + 1
+	f, err := os.OpenFile(s.segmentPath(next), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("创建 spool 段文件失败: %v", err)
+	}
+	s.w = f
+	s.wSeg = next
+	s.wOff = 0
+	s.dirty = false
+	s.segments = append(s.segments, next)
+	return nil
+}
+
+// readAt 读取 pos 处的记录，返回日志和下一条记录的位置；pos 处于非最后一段的末尾时返回下一段起点和 nil
+func (s *Spool) readAt(pos position) ([]*model.Log, position, error) {
+	f, err := os.Open(s.segmentPath(pos.segment))
+	if err != nil {
+		return nil, pos, fmt.Errorf("打开 spool 段文件失败: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(pos.offset, io.SeekStart); err != nil {
+		return nil, pos, fmt.Errorf("定位 spool 段文件失败: %v", err)
+	}
+
+	n, _, payload, err := readRecord(f)
+	if errors.Is(err, io.EOF) {
+		for _, id := range s.segments {
+			if id > pos.segment {
+				return nil, position{segment: id}, nil
+			}
+		}
+		return nil, pos, fmt.Errorf("spool 段 %d 偏移 %d 之后没有记录", pos.segment, pos.offset)
+	}
+	if err != nil {
+		return nil, pos, err
+	}
+	entries, err := decodeEntries(payload)
+	if err != nil {
+		return nil, pos, fmt.Errorf("解析 spool 记录失败: %v", err)
+	}
+	return entries, position{segment: pos.segment, offset: pos.offset + n}, nil
+}
+
+// commitLocked 将检查点推进到 pos，持久化检查点并删除已完全确认的段
+func (s *Spool) commitLocked(pos position, records, logs int64) {
+	s.ckpt = pos
+	for g, through := range s.done {
+		if !pos.before(through) {
+			delete(s.done, g)
+		}
+	}
+	s.pendingRecords -= records
+	s.pendingLogs -= logs
+	s.saveCheckpointLocked()
+
+	kept := s.segments[:0]
+	for _, id := range s.segments {
+		if id >= pos.segment || id == s.wSeg {
+			kept = append(kept, id)
+			continue
+		}
+		path := s.segmentPath(id)
+		if info, err := os.Stat(path); err == nil {
+			s.diskBytes -= info.Size()
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.log.Error(fmt.Sprintf("删除 spool 段文件 %s 失败: %v", path, err))
+		}
+	}
+	s.segments = kept
+}
+
+// saveCheckpointLocked 先写临时文件再替换，持久化检查点和已写入成功的分组
+func (s *Spool) saveCheckpointLocked() {
+	c := checkpoint{Segment: s.ckpt.segment, Offset: s.ckpt.offset}
+	for g, through := range s.done {
+		c.Done = append(c.Done, doneGroup{group: g, Segment: through.segment, Offset: through.offset})
+	}
+	data, _ := json.Marshal(c)
+	tmp := filepath.Join(s.opts.Dir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		s.log.Error(fmt.Sprintf("写入 spool 检查点失败: %v", err))
+	} else if err := os.Rename(tmp, filepath.Join(s.opts.Dir, checkpointFile)); err != nil {
+		s.log.Error(fmt.Sprintf("替换 spool 检查点失败: %v", err))
+	}
+}
+
+// readRecord 读取一条记录，返回记录总字节数、日志条数和 payload
+func readRecord(r io.Reader) (int64, int, []byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, 0, nil, fmt.Errorf("记录头不完整")
+		}
+		return 0, 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	count := binary.BigEndian.Uint32(header[4:8])
+	sum := binary.BigEndian.Uint32(header[8:12])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, fmt.Errorf("记录内容不完整: %v", err)
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return 0, 0, nil, fmt.Errorf("记录校验失败")
+	}
+	return int64(headerSize) + int64(length), int(count), payload, nil
+}
@@ -0,0 +1,212 @@
+package syslog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message 解析后的 syslog 消息，RFC 3164 消息没有 MsgID 和结构化数据
+type Message struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time // 消息中没有或无法解析时为零值
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        string
+	RFC5424        bool
+}
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// FacilityName 返回 facility 的名称
+func FacilityName(facility int) string {
+	if facility >= 0 && facility < len(facilityNames) {
+		return facilityNames[facility]
+	}
+	return strconv.Itoa(facility)
+}
+
+// LogLevel 把 syslog severity 映射为日志级别
+func LogLevel(severity int) string {
+	switch {
+	case severity <= 2: // emerg / alert / crit
+		return "FATAL"
+	case severity == 3:
+		return "ERROR"
+	case severity == 4:
+		return "WARN"
+	case severity == 7:
+		return "DEBUG"
+	default: // notice / info
+		return "INFO"
+	}
+}
+
+// Parse 解析一条 syslog 消息，自动识别 RFC 5424 和 RFC 3164 格式
+func Parse(data []byte) (*Message, error) {
+	s := strings.TrimRight(string(data), "\r\n\x00")
+	if !strings.HasPrefix(s, "<") {
+		return nil, fmt.Errorf("缺少 PRI 字段")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("PRI 字段格式有误")
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return nil, fmt.Errorf("PRI 字段无效: %s", s[1:end])
+	}
+	msg := &Message{Facility: pri / 8, Severity: pri % 8}
+	rest := s[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		msg.RFC5424 = true
+		return msg, parse5424(msg, rest[2:])
+	}
+	parse3164(msg, rest)
+	return msg, nil
+}
+
+// parse5424 解析 VERSION 之后的部分：TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parse5424(msg *Message, s string) error {
+	fields := make([]string, 5)
+	for i := range fields {
+		var ok bool
+		fields[i], s, ok = strings.Cut(s, " ")
+		if !ok && i < len(fields)-1 {
+			return fmt.Errorf("RFC 5424 消息头不完整")
+		}
+	}
+	if fields[0] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("时间戳格式有误: %s", fields[0])
+		}
+		msg.Timestamp = ts
+	}
+	msg.Hostname = nilValue(fields[1])
+	msg.AppName = nilValue(fields[2])
+	msg.ProcID = nilValue(fields[3])
+	msg.MsgID = nilValue(fields[4])
+
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "[") {
+		sd, rest, err := parseStructuredData(s)
+		if err != nil {
+			return err
+		}
+		msg.StructuredData = sd
+		s = rest
+	} else if s != "" {
+		return fmt.Errorf("结构化数据格式有误")
+	}
+	s = strings.TrimPrefix(s, " ")
+	msg.Message = strings.TrimPrefix(s, "\ufeff") // 去掉 UTF-8 BOM
+	return nil
+}
+
+// parseStructuredData 解析 [id k="v" ...][id2 ...]，返回剩余部分
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	sd := make(map[string]map[string]string)
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		idEnd := strings.IndexAny(s, " ]")
+		if idEnd <= 0 {
+			return nil, "", fmt.Errorf("结构化数据缺少 SD-ID")
+		}
+		id := s[:idEnd]
+		params := make(map[string]string)
+		s = s[idEnd:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return nil, "", fmt.Errorf("结构化数据 %s 参数格式有误", id)
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, "", fmt.Errorf("结构化数据 %s 参数值未闭合", id)
+			}
+			params[name] = value.String()
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("结构化数据 %s 未闭合", id)
+		}
+		s = s[1:]
+		sd[id] = params
+	}
+	return sd, s, nil
+}
+
+// parse3164 解析 TIMESTAMP HOSTNAME TAG[PID]: MSG，格式不规范时整段作为消息内容
+func parse3164(msg *Message, s string) {
+	if len(s) >= 16 && s[15] == ' ' {
+		if ts, err := time.ParseInLocation(time.Stamp, s[:15], time.Local); err == nil {
+			now := time.Now()
+			ts = ts.AddDate(now.Year(), 0, 0)
+			// 跨年时消息时间可能落在未来，回退一年
+			if ts.After(now.Add(24 * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			msg.Timestamp = ts
+			s = s[16:]
+			if host, rest, ok := strings.Cut(s, " "); ok {
+				msg.Hostname = host
+				s = rest
+			}
+		}
+	}
+
+	// TAG 由字母数字组成，最多 32 个字符，后面跟 [PID] 或冒号
+	tagEnd := strings.IndexAny(s, "[: ")
+	if tagEnd > 0 && tagEnd <= 32 {
+		tag, rest := s[:tagEnd], s[tagEnd:]
+		if strings.HasPrefix(rest, "[") {
+			if pidEnd := strings.IndexByte(rest, ']'); pidEnd > 0 {
+				msg.ProcID = rest[1:pidEnd]
+				rest = rest[pidEnd+1:]
+			}
+		}
+		if strings.HasPrefix(rest, ":") {
+			msg.AppName = tag
+			s = strings.TrimPrefix(rest[1:], " ")
+		} else {
+			msg.ProcID = ""
+		}
+	}
+	msg.Message = s
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,189 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+)
+
+func TestParse5424(t *testing.T) {
+	raw := `<165>1 2025-03-01T10:20:30.5Z web01 nginx 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"][meta seq="1"] ` + "\ufeff" + `request failed`
+	msg, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	want := &Message{
+		Facility:  20,
+		Severity:  5,
+		Timestamp: time.Date(2025, 3, 1, 10, 20, 30, 500000000, time.UTC),
+		Hostname:  "web01",
+		AppName:   "nginx",
+		ProcID:    "1234",
+		MsgID:     "ID47",
+		StructuredData: map[string]map[string]string{
+			"exampleSDID@32473": {"iut": "3", "eventSource": `App"lication`},
+			"meta":              {"seq": "1"},
+		},
+		Message: "request failed",
+		RFC5424: true,
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("解析结果不符合预期:\n%+v\n%+v", msg, want)
+	}
+
+	msg, err = Parse([]byte("<11>1 - - - - - -"))
+	if err != nil || msg.AppName != "" || msg.StructuredData != nil || msg.Message != "" || LogLevel(msg.Severity) != "ERROR" {
+		t.Errorf("全部为空值的消息解析有误: %+v, %v", msg, err)
+	}
+
+	for _, bad := range []string{"no pri", "<999>1 - - - - - -", "<13>1 2025", `<13>1 - - - - - [id k="v"`} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("%q 应解析失败", bad)
+		}
+	}
+}
+
+func TestParse3164(t *testing.T) {
+	msg, err := Parse([]byte("<86>Oct  3 04:05:06 cronhost CRON[4242]: (root) CMD (run-parts /etc/cron.hourly)\n"))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if msg.RFC5424 || msg.Facility != 10 || msg.Severity != 6 || msg.Hostname != "cronhost" ||
+		msg.AppName != "CRON" || msg.ProcID != "4242" || msg.Message != "(root) CMD (run-parts /etc/cron.hourly)" {
+		t.Errorf("解析结果不符合预期: %+v", msg)
+	}
+	if msg.Timestamp.Month() != time.October || msg.Timestamp.Day() != 3 || msg.Timestamp.Year() < 2000 {
+		t.Errorf("时间戳不符合预期: %v", msg.Timestamp)
+	}
+
+	msg, err = Parse([]byte("<13>just some text"))
+	if err != nil || msg.AppName != "" || msg.Message != "just some text" {
+		t.Errorf("不规范的消息应整段作为内容: %+v, %v", msg, err)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	input := "10 <13>hello\n13 <13>line\nnext\n<14>plain line\r\n<15>last"
+	r := bufio.NewReader(strings.NewReader(input))
+	var frames []string
+	for {
+		frame, err := readFrame(r)
+		if err != nil {
+			break
+		}
+		frames = append(frames, string(frame))
+	}
+	want := []string{"<13>hello\n", "<13>line\nnext", "", "<14>plain line", "<15>last"}
+	if !reflect.DeepEqual(frames, want) {
+		t.Errorf("分帧结果不符合预期: %q", frames)
+	}
+}
+
+func TestReadFrameInvalidOctetCount(t *testing.T) {
+	for _, input := range []string{
+		strings.Repeat("9", 1<<20),
+		"70000 <13>too large",
+		"12x <13>bad",
+	} {
+		if _, err := readFrame(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("无效的长度前缀应返回错误: %.20q", input)
+		}
+	}
+}
+
+func TestHandleConnectionIdleTimeout(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	p := pipeline.New(db.NewMemoryStorage(), pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour}, log)
+	l := Listener{Network: "tcp", Addr: ":0", Schema: "infra", Module: "nginx"}
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleConnection(l, server, 50*time.Millisecond, make(chan struct{}), p, log)
+	}()
+
+	// 在超时前发送的数据会重置空闲计时
+	time.Sleep(30 * time.Millisecond)
+	client.Write([]byte("<13>still here\n"))
+	select {
+	case <-done:
+		t.Fatal("收到数据后不应断开连接")
+	case <-time.After(30 * time.Millisecond):
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("空闲超时后应断开连接")
+	}
+}
+
+func TestSubmitAuth(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	store := db.NewMemoryStorage()
+	for _, name := range []string{"infra", "pay"} {
+		if _, err := store.GetOrCreateSchema(name); err != nil {
+			t.Fatalf("创建 schema 失败: %v", err)
+		}
+	}
+	_, token, err := store.CreateAPIKey("infra", "syslog")
+	if err != nil {
+		t.Fatalf("创建 ingest token 失败: %v", err)
+	}
+	_, payToken, err := store.CreateAPIKey("pay", "syslog")
+	if err != nil {
+		t.Fatalf("创建 ingest token 失败: %v", err)
+	}
+	p := pipeline.New(store, pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour, RequireAPIKey: true}, log)
+	l := Listener{Network: "udp", Addr: ":0", Schema: "infra", Module: "network"}
+	trusted := l
+	trusted.Token = token
+
+	submit(l, []byte("<13>no token"), "10.0.0.1:514", p, log)
+	submit(l, []byte(`<13>1 - h app - - [agera token="`+payToken+`"] other schema`), "10.0.0.1:514", p, log)
+	submit(l, []byte(`<13>1 - h app - - [agera token="`+token+`"] sd token`), "10.0.0.1:514", p, log)
+	submit(trusted, []byte("<13>listener token"), "10.0.0.1:514", p, log)
+
+	reasons := p.Stats().Transports[model.PushTypeSyslog].Reasons
+	if reasons[pipeline.ReasonUnauthorized] != 1 || reasons[pipeline.ReasonForbidden] != 1 {
+		t.Errorf("没有 token 或 token 属于其他 schema 应拒绝: %v", reasons)
+	}
+	p.Flush()
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "infra", Module: "network"})
+	if len(page.Logs) != 2 {
+		t.Fatalf("携带 token 的日志应写入，实际 %d 条", len(page.Logs))
+	}
+	for _, entry := range page.Logs {
+		if strings.Contains(entry.Detail, "agk_") {
+			t.Errorf("token 不应写入 detail: %s", entry.Detail)
+		}
+	}
+}
+
+func TestParseListeners(t *testing.T) {
+	listeners, err := ParseListeners("udp@:5514=infra/network, tcp@127.0.0.1:6514=infra/nginx#agk_abc")
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	want := []Listener{
+		{Network: "udp", Addr: ":5514", Schema: "infra", Module: "network"},
+		{Network: "tcp", Addr: "127.0.0.1:6514", Schema: "infra", Module: "nginx", Token: "agk_abc"},
+	}
+	if !reflect.DeepEqual(listeners, want) {
+		t.Errorf("解析结果不符合预期: %+v", listeners)
+	}
+	if _, err := ParseListeners("http@:80=a/b"); err == nil {
+		t.Error("不支持的协议应返回错误")
+	}
+}
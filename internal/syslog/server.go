@@ -0,0 +1,312 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+)
+
+// maxMessageSize 单条消息的最大长度，octet-counting 声明的长度超过该值时断开连接
+const maxMessageSize = 64 * 1024
+
+// idleTimeout TCP 连接在该时间内没有收到数据时断开，避免空闲或慢速客户端一直占用连接
+const idleTimeout = 5 * time.Minute
+
+// TokenSDID 携带 ingest token 的结构化数据元素，例如 [agera token="agk_..."]，不写入 detail
+const TokenSDID = "agera"
+
+// Listener 一个 syslog 监听，收到的日志写入固定的 schema / module。
+// 开启 pipeline.require_api_key 时消息需要携带 ingest token（RFC 5424 结构化数据 TokenSDID），
+// 不能修改消息格式的发送方可以在监听上配置 Token；token 所属的 schema 必须与 Schema 一致
+type Listener struct {
+	Network string `yaml:"network"` // udp 或 tcp
+	Addr    string `yaml:"addr"`
+	Schema  string `yaml:"schema"`
+	Module  string `yaml:"module"`
+	Token   string `yaml:"token"` // 消息没有携带 token 时使用
+}
+
+func (l Listener) String() string {
+	return fmt.Sprintf("%s://%s -> %s.%s", l.Network, l.Addr, l.Schema, l.Module)
+}
+
+// ParseListeners 解析 SYSLOG_LISTENERS 环境变量，格式为逗号分隔的 network@addr=schema/module[#token]，
+// 例如 udp@:5514=infra/network,tcp@:5514=infra/nginx#agk_xxx
+func ParseListeners(spec string) ([]Listener, error) {
+	var listeners []Listener
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		network, rest, ok1 := strings.Cut(item, "@")
+		addr, target, ok2 := strings.Cut(rest, "=")
+		target, token, _ := strings.Cut(target, "#")
+		schema, module, ok3 := strings.Cut(target, "/")
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("syslog 监听配置格式有误: %s@%s=%s", network, addr, target)
+		}
+		l := Listener{Network: network, Addr: addr, Schema: schema, Module: module, Token: token}
+		if err := l.Validate(); err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Validate 校验监听配置
+func (l Listener) Validate() error {
+	if l.Network != "udp" && l.Network != "tcp" {
+		return fmt.Errorf("syslog 监听协议只支持 udp 和 tcp: %s", l.Network)
+	}
+	if l.Addr == "" || l.Schema == "" || l.Module == "" {
+		return fmt.Errorf("syslog 监听 %s 缺少 addr、schema 或 module", l)
+	}
+	if err := db.ValidateNames(l.Schema, l.Module); err != nil {
+		return fmt.Errorf("syslog 监听 %s: %v", l, err)
+	}
+	if l.Token != "" && !strings.HasPrefix(l.Token, db.APIKeyPrefix) {
+		return fmt.Errorf("syslog 监听 %s 的 token 格式有误", l)
+	}
+	return nil
+}
+
+// StartSyslogServer 按 l.Network 启动监听，阻塞直到 stopChan 关闭
+func StartSyslogServer(l Listener, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	switch l.Network {
+	case "udp":
+		serveUDP(l, stopChan, p, log)
+	case "tcp":
+		serveTCP(l, stopChan, p, log)
+	default:
+		log.Error(fmt.Sprintf("不支持的 syslog 协议: %s", l.Network))
+	}
+}
+
+func serveUDP(l Listener, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	conn, err := net.ListenPacket("udp", l.Addr)
+	if err != nil {
+		log.Error(fmt.Sprintf("syslog UDP 监听 %s 失败: %v", l.Addr, err))
+		return
+	}
+	defer conn.Close()
+	log.Info(fmt.Sprintf("syslog 服务跑起来了: %s", l))
+
+	go func() {
+		<-stopChan
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-stopChan:
+				log.Info(fmt.Sprintf("收到停止信号，关闭 syslog 服务: %s", l))
+				return
+			default:
+			}
+			log.Error(fmt.Sprintf("读取 syslog UDP 数据失败: %v", err))
+			continue
+		}
+		submit(l, buf[:n], addr.String(), p, log)
+	}
+}
+
+func serveTCP(l Listener, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	listener, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		log.Error(fmt.Sprintf("syslog TCP 监听 %s 失败: %v", l.Addr, err))
+		return
+	}
+	defer listener.Close()
+	log.Info(fmt.Sprintf("syslog 服务跑起来了: %s", l))
+
+	go func() {
+		<-stopChan
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stopChan:
+				log.Info(fmt.Sprintf("收到停止信号，关闭 syslog 服务: %s", l))
+				return
+			default:
+			}
+			log.Error(fmt.Sprintf("接受 syslog TCP 连接失败: %v", err))
+			continue
+		}
+		go handleConnection(l, conn, idleTimeout, stopChan, p, log)
+	}
+}
+
+func handleConnection(l Listener, conn net.Conn, idleTimeout time.Duration, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+	go func() {
+		select {
+		case <-stopChan:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	remoteAddr := conn.RemoteAddr().String()
+	reader := bufio.NewReaderSize(conn, maxMessageSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		frame, err := readFrame(reader)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Info(fmt.Sprintf("syslog TCP 连接 %s 超过 %s 没有数据，断开连接", remoteAddr, idleTimeout))
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Error(fmt.Sprintf("读取 syslog TCP 数据失败: %v，来自 %s", err, remoteAddr))
+			}
+			return
+		}
+		if len(frame) > 0 {
+			submit(l, frame, remoteAddr, p, log)
+		}
+	}
+}
+
+// readFrame 读取一条 TCP 消息（RFC 6587）：以数字开头时按 octet-counting 读取 "长度 空格 消息"，否则按换行分隔
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] >= '1' && first[0] <= '9' {
+		size, err := readOctetCount(r)
+		if err != nil {
+			return nil, err
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("消息超过 %d 字节", maxMessageSize)
+	}
+	if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(line), "\r\n")), nil
+}
+
+// maxOctetCountDigits octet-counting 长度前缀最多的位数
+const maxOctetCountDigits = 10
+
+// readOctetCount 逐字节读取 "长度 空格"，遇到非数字、位数过多或长度超过 maxMessageSize 时返回错误，不会先读入整个前缀
+func readOctetCount(r *bufio.Reader) (int, error) {
+	size := 0
+	for digits := 0; ; digits++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == ' ' && digits > 0 {
+			return size, nil
+		}
+		if c < '0' || c > '9' || digits >= maxOctetCountDigits {
+			return 0, fmt.Errorf("octet-counting 长度前缀无效")
+		}
+		size = size*10 + int(c-'0')
+		if size > maxMessageSize {
+			return 0, fmt.Errorf("octet-counting 长度超过 %d 字节", maxMessageSize)
+		}
+	}
+}
+
+// submit 解析 syslog 消息，校验 ingest token 后提交到流水线。token 优先取结构化数据 TokenSDID，没有时使用监听配置的 token
+func submit(l Listener, data []byte, remoteAddr string, p *pipeline.Pipeline, log *logrus.Logger) {
+	msg, err := Parse(data)
+	if err != nil {
+		log.Error(fmt.Sprintf("syslog 数据解析失败: %v, 来自 %s", err, remoteAddr))
+		return
+	}
+	token := l.Token
+	if params, ok := msg.StructuredData[TokenSDID]; ok {
+		delete(msg.StructuredData, TokenSDID)
+		if params["token"] != "" {
+			token = params["token"]
+		}
+	}
+	entry := ToLog(msg, l, remoteAddr)
+	schema, err := p.Authenticate(model.PushTypeSyslog, token, "")
+	if err == nil {
+		err = p.SubmitAs(schema, entry)
+	}
+	if err != nil {
+		// 原始数据中可能带有 token，不写入日志
+		log.Error(fmt.Sprintf("syslog 日志未被接收: %v, 来自 %s", err, remoteAddr))
+	}
+}
+
+// ToLog 转换为 model.Log：app-name 作为 service（缺失时依次使用 hostname 和 "syslog"），severity 映射为日志级别，
+// facility、hostname、procid、msgid 和结构化数据以 JSON 形式放在 detail 中
+func ToLog(msg *Message, l Listener, remoteAddr string) *model.Log {
+	clientIP := "0.0.0.0"
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		clientIP = host
+	}
+	detail := map[string]any{"facility": FacilityName(msg.Facility)}
+	for key, value := range map[string]string{
+		"hostname": msg.Hostname,
+		"procid":   msg.ProcID,
+		"msgid":    msg.MsgID,
+	} {
+		if value != "" {
+			detail[key] = value
+		}
+	}
+	if len(msg.StructuredData) > 0 {
+		detail["structured_data"] = msg.StructuredData
+	}
+	detailJSON, _ := json.Marshal(detail)
+
+	service := msg.AppName
+	if service == "" {
+		service = msg.Hostname
+	}
+	if service == "" {
+		service = "syslog"
+	}
+
+	return &model.Log{
+		LogBase: model.LogBase{
+			Output:     msg.Message,
+			Detail:     string(detailJSON),
+			Service:    service,
+			ClientIP:   clientIP,
+			ClientAddr: remoteAddr,
+			LogLevel:   LogLevel(msg.Severity),
+		},
+		Schema:      model.LogSchema(l.Schema),
+		Module:      model.LogModule(l.Module),
+		PushType:    model.PushTypeSyslog,
+		Timestamp:   msg.Timestamp, // 报文没有时间时由流水线使用接收时间
+		ReceiveTime: time.Now(),
+	}
+}
@@ -0,0 +1,117 @@
+package tail
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+// DefaultBuffer 每个订阅者默认的缓冲条数
+const DefaultBuffer = 256
+
+// Subscription 一个实时订阅，消费 C 中的日志；消费过慢时新日志被丢弃并计入 Dropped
+type Subscription struct {
+	C <-chan model.LogRecord
+
+	ch      chan model.LogRecord
+	query   db.LogQuery
+	dropped atomic.Int64
+	hub     *Hub
+}
+
+// Dropped 返回并清零自上次调用以来丢弃的条数
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Close 取消订阅，可重复调用
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+// Stats 实时订阅统计
+type Stats struct {
+	Subscribers int   `json:"subscribers"`
+	Delivered   int64 `json:"delivered"`
+	Dropped     int64 `json:"dropped"`
+}
+
+// Hub 把刚被流水线接收的日志分发给匹配的订阅者，发送不阻塞写入路径
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+
+	delivered atomic.Int64
+	dropped   atomic.Int64
+}
+
+// NewHub 创建分发中心
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe 订阅 q.Schema / q.Module 下满足 q 过滤条件的日志，buffer 不大于 0 时使用 DefaultBuffer
+func (h *Hub) Subscribe(q db.LogQuery, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	ch := make(chan model.LogRecord, buffer)
+	sub := &Subscription{C: ch, ch: ch, query: q, hub: h}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Observe 实现 pipeline.Observer，在日志被接收后调用
+func (h *Hub) Observe(entry *model.Log) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.subs) == 0 {
+		return
+	}
+
+	var record model.LogRecord
+	converted := false
+	for sub := range h.subs {
+		if sub.query.Schema != string(entry.Schema) || sub.query.Module != string(entry.Module) {
+			continue
+		}
+		if !converted {
+			record = db.ToRecord(entry)
+			converted = true
+		}
+		if !sub.query.Matches(record) {
+			continue
+		}
+		select {
+		case sub.ch <- record:
+			h.delivered.Add(1)
+		default:
+			sub.dropped.Add(1)
+			h.dropped.Add(1)
+		}
+	}
+}
+
+// Stats 返回当前统计
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return Stats{
+		Subscribers: len(h.subs),
+		Delivered:   h.delivered.Load(),
+		Dropped:     h.dropped.Load(),
+	}
+}
@@ -0,0 +1,55 @@
+package tail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+)
+
+func newEntry(module, level, output string) *model.Log {
+	return &model.Log{
+		LogBase:   model.LogBase{Output: output, Service: "svc", LogLevel: level},
+		Schema:    "shop",
+		Module:    model.LogModule(module),
+		Timestamp: time.Now(),
+	}
+}
+
+func TestHubFiltersAndDrops(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(db.LogQuery{Schema: "shop", Module: "order", Levels: []string{"ERROR"}}, 2)
+	defer sub.Close()
+
+	hub.Observe(newEntry("pay", "error", "other module"))
+	hub.Observe(newEntry("order", "info", "filtered"))
+	for _, output := range []string{"a", "b", "c", "d"} {
+		hub.Observe(newEntry("order", "error", output))
+	}
+
+	for _, want := range []string{"a", "b"} {
+		record := <-sub.C
+		if record.Output != want || record.LogLevel != "ERROR" {
+			t.Errorf("预期收到 %s，实际 %+v", want, record)
+		}
+	}
+	if n := sub.Dropped(); n != 2 {
+		t.Errorf("预期丢弃 2 条，实际 %d 条", n)
+	}
+	if n := sub.Dropped(); n != 0 {
+		t.Errorf("Dropped 读取后应清零，实际 %d", n)
+	}
+	if stats := hub.Stats(); stats.Subscribers != 1 || stats.Delivered != 2 || stats.Dropped != 2 {
+		t.Errorf("统计不符合预期: %+v", stats)
+	}
+
+	sub.Close()
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("取消订阅后通道应关闭")
+	}
+	if stats := hub.Stats(); stats.Subscribers != 0 {
+		t.Errorf("取消订阅后订阅者数量应为 0，实际 %d", stats.Subscribers)
+	}
+}
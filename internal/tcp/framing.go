@@ -0,0 +1,132 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vkeeps/agera-logs/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// 分帧方式
+const (
+	FramingLine     = "line"     // 每行一条 JSON，以 \n 结尾
+	FramingJSON     = "json"     // varint 长度前缀 + JSON
+	FramingProtobuf = "protobuf" // varint 长度前缀 + proto.LogRequest
+)
+
+// DefaultMaxFrameSize 单帧（或单行）默认最大字节数
+const DefaultMaxFrameSize = 1 << 20
+
+// negotiateCommand 行分帧的连接可以先发送一行 "FRAMING <line|json|protobuf> [ACK]" 切换分帧方式并开启确认，
+// 服务端回复 "OK\n"，之后按新方式收发；不合法时回复 "ERR <原因>\n" 并关闭连接
+const negotiateCommand = "FRAMING"
+
+// errFrameTooLarge 帧超过 MaxFrameSize，此时无法可靠地找到下一帧的边界，只能关闭连接
+var errFrameTooLarge = errors.New("帧超过大小限制")
+
+// validFraming 分帧方式是否合法
+func validFraming(framing string) bool {
+	return framing == FramingLine || framing == FramingJSON || framing == FramingProtobuf
+}
+
+// frameCodec 一个连接当前使用的分帧方式
+type frameCodec struct {
+	framing  string
+	ack      bool
+	maxFrame int
+}
+
+// read 读取一帧，返回的切片在下次读取前有效
+func (c *frameCodec) read(r *bufio.Reader) ([]byte, error) {
+	if c.framing == FramingLine {
+		return readLine(r, c.maxFrame)
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(c.maxFrame) {
+		return nil, fmt.Errorf("%w: %d > %d", errFrameTooLarge, size, c.maxFrame)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// readLine 读取一行（不含行尾），超过 max 字节时返回 errFrameTooLarge
+func readLine(r *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > max+1 {
+			return nil, fmt.Errorf("%w: 行长度超过 %d", errFrameTooLarge, max)
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			return bytes.TrimRight(line, "\r\n"), nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+}
+
+// frameAck 行分帧和 JSON 分帧的确认格式，字段与 proto.FrameAck 一致
+type frameAck struct {
+	Sequence uint64 `json:"sequence"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// writeAck 按当前分帧方式写入一帧的确认，err 为 nil 表示已接收
+func (c *frameCodec) writeAck(w *bufio.Writer, seq uint64, err error) error {
+	ack := frameAck{Sequence: seq, Accepted: err == nil}
+	if err != nil {
+		ack.Reason = err.Error()
+	}
+	var data []byte
+	if c.framing == FramingProtobuf {
+		data, err = protobuf.Marshal(&proto.FrameAck{Sequence: ack.Sequence, Accepted: ack.Accepted, Reason: ack.Reason})
+	} else {
+		data, err = json.Marshal(ack)
+	}
+	if err != nil {
+		return err
+	}
+	if c.framing == FramingLine {
+		data = append(data, '\n')
+	} else {
+		w.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// negotiate 解析连接的第一行，不是协商请求时 ok 为 false
+func (c *frameCodec) negotiate(line []byte) (ok bool, err error) {
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 || fields[0] != negotiateCommand {
+		return false, nil
+	}
+	fields = fields[1:]
+	if len(fields) == 0 || len(fields) > 2 || !validFraming(fields[0]) {
+		return true, fmt.Errorf("协商格式应为 %s <%s|%s|%s> [ACK]", negotiateCommand, FramingLine, FramingJSON, FramingProtobuf)
+	}
+	if len(fields) == 2 {
+		if !strings.EqualFold(fields[1], "ACK") {
+			return true, fmt.Errorf("未知的协商选项: %s", fields[1])
+		}
+		c.ack = true
+	}
+	c.framing = fields[0]
+	return true, nil
+}
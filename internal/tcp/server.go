@@ -2,65 +2,68 @@ package tcp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strconv"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"github.com/vkeeps/agera-logs/internal/db"
 	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/internal/tlsconfig"
+	"github.com/vkeeps/agera-logs/proto"
+	protobuf "google.golang.org/protobuf/proto"
 )
 
-type TCPLogRequest struct {
-	SchemaID          string `json:"schema_id,schemaId"`
-	Module            string `json:"module"`
-	Output            string `json:"output"`
-	Detail            string `json:"detail,omitempty"`
-	ErrorInfo         string `json:"error_info,omitempty"`
-	Service           string `json:"service,omitempty"`
-	LogLevel          string `json:"log_level,omitempty"`
-	OperatorID        string `json:"operator_id,operatorId"`
-	Operator          string `json:"operator,omitempty"`
-	OperatorIP        string `json:"operator_ip,omitempty"`
-	OperatorEquipment string `json:"operator_equipment,omitempty"`
-	OperatorCompany   string `json:"operator_company,omitempty"`
-	OperatorProject   string `json:"operator_project,omitempty"`
+// Config TCP 服务配置
+type Config struct {
+	Port         int              `yaml:"port"`           // 起始端口，被占用时依次尝试下一个
+	ReadTimeout  time.Duration    `yaml:"read_timeout"`   // 读超时，用于定期检查停止信号
+	TLS          tlsconfig.Config `yaml:"tls"`            // 配置证书后只接受 TLS 连接
+	Framing      string           `yaml:"framing"`        // 连接默认的分帧方式：line、json 或 protobuf，line 连接可通过 FRAMING 协商切换
+	MaxFrameSize int              `yaml:"max_frame_size"` // 单帧（或单行）最大字节数，超过时关闭连接
+	Ack          bool             `yaml:"ack"`            // 为 true 时每帧回复确认，也可在协商时开启
 }
 
-var (
-	batchSize      = 20
-	batchTimeout   = 1 * time.Millisecond
-	bufferCapacity = 500
-)
-
-var (
-	insertedCount int64
-	receivedCount int64
-)
+// DefaultConfig 默认配置
+func DefaultConfig() Config {
+	return Config{Port: 50053, ReadTimeout: time.Second, Framing: FramingLine, MaxFrameSize: DefaultMaxFrameSize}
+}
 
-func init() {
-	if size, err := strconv.Atoi(os.Getenv("BATCH_SIZE")); err == nil && size > 0 {
-		batchSize = size
+// Validate 校验配置
+func (c Config) Validate() error {
+	if c.Framing != "" && !validFraming(c.Framing) {
+		return fmt.Errorf("tcp.framing 只能是 %s、%s 或 %s: %s", FramingLine, FramingJSON, FramingProtobuf, c.Framing)
 	}
-	if timeout, err := time.ParseDuration(os.Getenv("BATCH_TIMEOUT")); err == nil && timeout > 0 {
-		batchTimeout = timeout
-	}
-	if capacity, err := strconv.Atoi(os.Getenv("BUFFER_CAPACITY")); err == nil && capacity > 0 {
-		bufferCapacity = capacity
+	if c.MaxFrameSize < 0 {
+		return fmt.Errorf("tcp.max_frame_size 不能为负数")
 	}
+	return c.TLS.Validate("tcp.tls")
 }
 
-func validModule(module string) bool {
-	return module != ""
+// codec 新连接的初始分帧方式，未设置的字段使用默认值
+func (c Config) codec() *frameCodec {
+	codec := &frameCodec{framing: c.Framing, ack: c.Ack, maxFrame: c.MaxFrameSize}
+	if codec.framing == "" {
+		codec.framing = FramingLine
+	}
+	if codec.maxFrame <= 0 {
+		codec.maxFrame = DefaultMaxFrameSize
+	}
+	return codec
 }
 
-func StartTCPServer(basePort int, stopChan chan struct{}, log *logrus.Logger) {
-	port := basePort
+func StartTCPServer(cfg Config, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	tlsConfig, err := cfg.TLS.Server()
+	if err != nil {
+		log.Fatal(fmt.Sprintf("TCP TLS 配置加载失败: %v", err))
+	}
+	port := cfg.Port
 	var listener *net.TCPListener
 	for {
 		if port > 65535 {
@@ -83,60 +86,15 @@ func StartTCPServer(basePort int, stopChan chan struct{}, log *logrus.Logger) {
 	defer listener.Close()
 
 	os.Setenv("TCP_PORT", strconv.Itoa(port))
-	log.Info(fmt.Sprintf("TCP 服务跑起来了，端口: %d", port))
-
-	logBuffer := make([]*model.Log, 0, bufferCapacity)
-	var mu sync.Mutex
-	ticker := time.NewTicker(batchTimeout)
-	defer ticker.Stop()
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				mu.Lock()
-				if len(logBuffer) > 0 {
-					entries := make([]*model.Log, len(logBuffer))
-					copy(entries, logBuffer)
-					logBuffer = logBuffer[:0]
-					log.Info(fmt.Sprintf("准备插入 %d 条日志，缓冲区剩余 %d", len(entries), len(logBuffer)))
-					mu.Unlock()
-
-					start := time.Now()
-					if err := db.InsertLogs(entries, log); err != nil {
-						log.Error(fmt.Sprintf("批量插入 %d 条日志失败: %v", len(entries), err))
-					} else {
-						atomic.AddInt64(&insertedCount, int64(len(entries)))
-						log.Info(fmt.Sprintf("成功插入 %d 条日志，耗时 %v，总计插入 %d 条", len(entries), time.Since(start), atomic.LoadInt64(&insertedCount)))
-					}
-				} else {
-					mu.Unlock()
-				}
-			case <-stopChan:
-				mu.Lock()
-				if len(logBuffer) > 0 {
-					entries := make([]*model.Log, len(logBuffer))
-					copy(entries, logBuffer)
-					logBuffer = logBuffer[:0]
-					log.Info(fmt.Sprintf("停止服务，插入剩余 %d 条日志", len(entries)))
-					mu.Unlock()
-					if err := db.InsertLogs(entries, log); err != nil {
-						log.Error(fmt.Sprintf("批量插入 %d 条日志失败: %v", len(entries), err))
-					} else {
-						atomic.AddInt64(&insertedCount, int64(len(entries)))
-						log.Info(fmt.Sprintf("成功插入 %d 条日志，总计插入 %d 条", len(entries), atomic.LoadInt64(&insertedCount)))
-					}
-				} else {
-					mu.Unlock()
-				}
-				return
-			}
-		}
-	}()
+	if tlsConfig != nil {
+		log.Info(fmt.Sprintf("TCP 服务跑起来了，端口: %d（TLS）", port))
+	} else {
+		log.Info(fmt.Sprintf("TCP 服务跑起来了，端口: %d", port))
+	}
 
-	readTimeout := 1 * time.Second
-	if t, err := time.ParseDuration(os.Getenv("READ_TIMEOUT")); err == nil && t > 0 {
-		readTimeout = t
+	readTimeout := cfg.ReadTimeout
+	if readTimeout <= 0 {
+		readTimeout = time.Second
 	}
 
 	for {
@@ -154,133 +112,115 @@ func StartTCPServer(basePort int, stopChan chan struct{}, log *logrus.Logger) {
 				log.Error(fmt.Sprintf("接受 TCP 连接失败: %v", err))
 				continue
 			}
-			go handleConnection(conn, &logBuffer, &mu, stopChan, log)
+			if tlsConfig != nil {
+				conn = tls.Server(conn, tlsConfig)
+			}
+			go handleConnection(conn, cfg, readTimeout, stopChan, p, log)
 		}
 	}
 }
 
-func handleConnection(conn net.Conn, logBuffer *[]*model.Log, mu *sync.Mutex, stopChan chan struct{}, log *logrus.Logger) {
+func handleConnection(conn net.Conn, cfg Config, readTimeout time.Duration, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
 	defer conn.Close()
 
-	reader := bufio.NewReader(conn)
-
 	remoteAddr := conn.RemoteAddr().String()
 	clientIP, clientAddr := parseRemoteAddr(remoteAddr)
 
-	readTimeout := 1 * time.Second
-	if t, err := time.ParseDuration(os.Getenv("READ_TIMEOUT")); err == nil && t > 0 {
-		readTimeout = t
+	identity, err := handshake(conn, readTimeout)
+	if err != nil {
+		log.Error(fmt.Sprintf("TCP TLS 握手失败: %v，来自 %s", err, remoteAddr))
+		return
 	}
 
-	for {
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	codec := cfg.codec()
+
+	for seq := uint64(1); ; {
 		select {
 		case <-stopChan:
 			log.Info(fmt.Sprintf("停止处理连接: %s", remoteAddr))
 			return
 		default:
 			conn.SetReadDeadline(time.Now().Add(readTimeout))
-			line, err := reader.ReadBytes('\n')
+			frame, err := codec.read(reader)
 			if err != nil {
-				if err.Error() != "EOF" && !netErrTimeout(err) {
+				if errors.Is(err, errFrameTooLarge) {
+					log.Error(fmt.Sprintf("TCP 数据超过大小限制，关闭连接 %s: %v", remoteAddr, err))
+					p.Drop(model.PushTypeTCP, "frame_too_large")
+				} else if !errors.Is(err, io.EOF) && !netErrTimeout(err) {
 					log.Error(fmt.Sprintf("读取 TCP 数据失败: %v", err))
 				}
 				return
 			}
 
-			var req TCPLogRequest
-			if err := json.Unmarshal(line, &req); err != nil {
-				log.Error(fmt.Sprintf("TCP 数据解析失败: %v, 原始数据: %s", err, string(line)))
-				continue
-			}
-
-			if req.SchemaID == "" {
-				log.Error(fmt.Sprintf("收到空 schema_id，原始数据: %s", string(line)))
-				continue
-			}
-
-			// 检查 service 是否为空
-			if req.Service == "" {
-				log.Error(fmt.Sprintf("TCP 日志缺少 service 字段，跳过插入，原始数据: %+v", req))
-				continue
+			if seq == 1 && codec.framing == FramingLine {
+				if ok, err := codec.negotiate(frame); ok {
+					reply := "OK\n"
+					if err != nil {
+						reply = fmt.Sprintf("ERR %v\n", err)
+					}
+					conn.SetWriteDeadline(time.Now().Add(readTimeout))
+					if _, werr := conn.Write([]byte(reply)); werr != nil || err != nil {
+						log.Error(fmt.Sprintf("TCP 分帧协商失败，来自 %s: %v", remoteAddr, errors.Join(err, werr)))
+						return
+					}
+					continue
+				}
 			}
 
-			schemaName, err := db.GetSchemaNameByID(req.SchemaID, log)
+			err = submitFrame(codec.framing, frame, p, clientIP, clientAddr, identity)
 			if err != nil {
-				log.Error(fmt.Sprintf("获取 schema_id %s 失败: %v", req.SchemaID, err))
-				continue
+				log.Error(fmt.Sprintf("TCP 日志未被接收: %v, 来自 %s", err, remoteAddr))
 			}
-			if schemaName == "" {
-				log.Warn(fmt.Sprintf("无效的 schema_id: %s，未在 BoltDB 中注册, 原始数据: %s", req.SchemaID, string(line)))
-				db.RebuildSchemaCache(req.SchemaID, log)
-				start := time.Now()
-				timeout := 100 * time.Millisecond
-				for time.Since(start) < timeout {
-					schemaName, err = db.GetSchemaNameByID(req.SchemaID, log)
-					if err == nil && schemaName != "" {
-						break
-					}
-					time.Sleep(10 * time.Millisecond)
+			if codec.ack {
+				conn.SetWriteDeadline(time.Now().Add(readTimeout))
+				if err := codec.writeAck(writer, seq, err); err == nil && reader.Buffered() == 0 {
+					// 客户端连续发送时攒到读缓冲区读空再发送确认，减少小包
+					err = writer.Flush()
 				}
-				if schemaName == "" {
-					log.Error(fmt.Sprintf("重试后仍无效的 schema_id: %s，跳过插入", req.SchemaID))
-					continue
+				if err != nil {
+					log.Error(fmt.Sprintf("发送 TCP 确认失败，关闭连接 %s: %v", remoteAddr, err))
+					return
 				}
 			}
+			seq++
+		}
+	}
+}
 
-			if !validModule(req.Module) {
-				log.Error(fmt.Sprintf("无效的 module: %s", req.Module))
-				continue
-			}
-
-			entry := &model.Log{
-				LogBase: model.LogBase{
-					Output:     req.Output,
-					Detail:     req.Detail,
-					ErrorInfo:  req.ErrorInfo,
-					Service:    req.Service,
-					ClientIP:   clientIP,
-					ClientAddr: clientAddr,
-					LogLevel:   req.LogLevel,
-				},
-				Schema:            model.LogSchema(schemaName),
-				Module:            model.LogModule(req.Module),
-				PushType:          model.PushTypeTCP,
-				Timestamp:         time.Now(),
-				OperatorID:        req.OperatorID,
-				Operator:          req.Operator,
-				OperatorIP:        req.OperatorIP,
-				OperatorEquipment: req.OperatorEquipment,
-				OperatorCompany:   req.OperatorCompany,
-				OperatorProject:   req.OperatorProject,
-			}
+// submitFrame 按分帧方式解析一帧并提交
+func submitFrame(framing string, frame []byte, p *pipeline.Pipeline, clientIP, clientAddr, identity string) error {
+	if framing == FramingProtobuf {
+		var req proto.LogRequest
+		if err := protobuf.Unmarshal(frame, &req); err != nil {
+			return fmt.Errorf("TCP 数据解析失败: %v", err)
+		}
+		return p.SubmitProto(&req, model.PushTypeTCP, clientIP, clientAddr, identity)
+	}
+	var req pipeline.Request
+	if err := json.Unmarshal(frame, &req); err != nil {
+		return fmt.Errorf("TCP 数据解析失败: %v, 原始数据: %s", err, string(frame))
+	}
+	return p.SubmitRequest(&req, model.PushTypeTCP, clientIP, clientAddr, identity)
+}
 
-			mu.Lock()
-			if len(*logBuffer) < bufferCapacity {
-				*logBuffer = append(*logBuffer, entry)
-				count := atomic.AddInt64(&receivedCount, 1)
-				log.Info(fmt.Sprintf("收到第 %d 条数据，从 %s", count, remoteAddr))
-			} else {
-				log.Error(fmt.Sprintf("缓冲区已满，丢弃日志: %v", entry))
-			}
-			if len(*logBuffer) >= batchSize {
-				entries := make([]*model.Log, len(*logBuffer))
-				copy(entries, *logBuffer)
-				*logBuffer = (*logBuffer)[:0]
-				log.Info(fmt.Sprintf("准备插入 %d 条日志，缓冲区剩余 %d", len(entries), len(*logBuffer)))
-				mu.Unlock()
+// handshakeTimeoutFactor TLS 握手最多等待 readTimeout 的倍数，给跨网络的证书交换留出时间
+const handshakeTimeoutFactor = 10
 
-				start := time.Now()
-				if err := db.InsertLogs(entries, log); err != nil {
-					log.Error(fmt.Sprintf("批量插入 %d 条日志失败: %v", len(entries), err))
-				} else {
-					atomic.AddInt64(&insertedCount, int64(len(entries)))
-					log.Info(fmt.Sprintf("成功插入 %d 条日志，耗时 %v，总计插入 %d 条", len(entries), time.Since(start), atomic.LoadInt64(&insertedCount)))
-				}
-			} else {
-				mu.Unlock()
-			}
-		}
+// handshake TLS 连接先完成握手，返回客户端证书的身份；明文连接直接返回空字符串
+func handshake(conn net.Conn, readTimeout time.Duration) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeoutFactor * readTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
 	}
+	conn.SetDeadline(time.Time{})
+	state := tlsConn.ConnectionState()
+	return tlsconfig.Identity(&state), nil
 }
 
 func parseRemoteAddr(addr string) (clientIP, clientAddr string) {
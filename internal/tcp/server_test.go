@@ -0,0 +1,133 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+	"github.com/vkeeps/agera-logs/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+func newTestConn(t *testing.T, cfg Config) (net.Conn, *pipeline.Pipeline, *db.MemoryStorage) {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	store := db.NewMemoryStorage()
+	if _, err := store.GetOrCreateSchema("shop"); err != nil {
+		t.Fatalf("创建 schema 失败: %v", err)
+	}
+	p := pipeline.New(store, pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour}, log)
+	server, client := net.Pipe()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleConnection(server, cfg, time.Second, stop, p, log)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		client.Close()
+		<-done
+	})
+	return client, p, store
+}
+
+// frame 加上 varint 长度前缀；net.Pipe 没有缓冲，测试中在单独的 goroutine 写入
+func frame(data []byte) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(data))), data...)
+}
+
+func readFrame(t *testing.T, r *bufio.Reader) []byte {
+	t.Helper()
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatalf("读取帧长度失败: %v", err)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatalf("读取帧失败: %v", err)
+	}
+	return data
+}
+
+func TestProtobufFramingWithAck(t *testing.T) {
+	conn, p, store := newTestConn(t, DefaultConfig())
+	r := bufio.NewReader(conn)
+
+	go conn.Write([]byte("FRAMING protobuf ACK\n"))
+	if line, _ := r.ReadString('\n'); line != "OK\n" {
+		t.Fatalf("协商应返回 OK，实际 %q", line)
+	}
+
+	// detail 中的换行在长度前缀分帧下不会截断日志
+	for i, req := range []*proto.LogRequest{
+		{Schema: "shop", Module: "order", Service: "svc", Output: "first", Detail: "line1\nline2"},
+		{Schema: "shop", Module: "order", Output: "missing service"},
+	} {
+		data, err := protobuf.Marshal(req)
+		if err != nil {
+			t.Fatalf("编码失败: %v", err)
+		}
+		go conn.Write(frame(data))
+		var ack proto.FrameAck
+		if err := protobuf.Unmarshal(readFrame(t, r), &ack); err != nil {
+			t.Fatalf("解析确认失败: %v", err)
+		}
+		wantAccepted := req.Service != ""
+		if ack.Accepted != wantAccepted || ack.Sequence != uint64(i+1) {
+			t.Errorf("确认不符合预期: %+v", &ack)
+		}
+		if !wantAccepted && ack.Reason == "" {
+			t.Errorf("拒绝时应返回原因")
+		}
+	}
+
+	p.Flush()
+	page, err := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+	if err != nil || len(page.Logs) != 1 || page.Logs[0].Detail != "line1\nline2" {
+		t.Errorf("应完整写入 1 条日志，实际 %+v, %v", page.Logs, err)
+	}
+}
+
+func TestJSONFramingLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Framing = FramingJSON
+	cfg.Ack = true
+	cfg.MaxFrameSize = 256
+	conn, _, _ := newTestConn(t, cfg)
+	r := bufio.NewReader(conn)
+
+	data, _ := json.Marshal(pipeline.Request{SchemaID: db.GenerateSchemaID("shop"), Module: "order", Service: "svc", Output: "x"})
+	go conn.Write(frame(data))
+	var ack frameAck
+	if err := json.Unmarshal(readFrame(t, r), &ack); err != nil || !ack.Accepted || ack.Sequence != 1 {
+		t.Fatalf("JSON 帧应被接收，实际 %+v, %v", ack, err)
+	}
+
+	go conn.Write(frame([]byte(strings.Repeat("x", 257))))
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("超过大小限制的帧应关闭连接，实际 %v", err)
+	}
+}
+
+func TestNegotiateErrors(t *testing.T) {
+	for _, line := range []string{"FRAMING", "FRAMING xml", "FRAMING json NOW", "FRAMING json ACK extra"} {
+		codec := DefaultConfig().codec()
+		if ok, err := codec.negotiate([]byte(line)); !ok || err == nil {
+			t.Errorf("%q 应协商失败，实际 %v, %v", line, ok, err)
+		}
+	}
+	codec := DefaultConfig().codec()
+	if ok, _ := codec.negotiate([]byte(`{"module":"order"}`)); ok || codec.framing != FramingLine {
+		t.Errorf("普通 JSON 行不应被当作协商")
+	}
+}
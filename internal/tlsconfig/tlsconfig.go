@@ -0,0 +1,98 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// 客户端证书的校验方式
+const (
+	ClientAuthRequire  = "require"  // 必须提供由 ClientCAFile 签发的证书
+	ClientAuthOptional = "optional" // 可以不提供证书，提供了则必须通过校验
+)
+
+// Config 监听的 TLS 配置，CertFile 和 KeyFile 都为空时使用明文
+type Config struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"` // 非空时用其中的 CA 校验客户端证书（mTLS）
+	ClientAuth   string `yaml:"client_auth"`    // require（默认）或 optional，只在配置了 client_ca_file 时生效
+}
+
+// Enabled 是否启用 TLS
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Validate 校验配置，name 为配置项前缀，如 grpc.tls
+func (c Config) Validate(name string) error {
+	if !c.Enabled() {
+		if c.ClientCAFile != "" {
+			return fmt.Errorf("%s.client_ca_file 需要同时配置 cert_file 和 key_file", name)
+		}
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("%s.cert_file 和 key_file 需要同时配置", name)
+	}
+	if c.ClientAuth != "" && c.ClientAuth != ClientAuthRequire && c.ClientAuth != ClientAuthOptional {
+		return fmt.Errorf("%s.client_auth 只能是 %s 或 %s: %s", name, ClientAuthRequire, ClientAuthOptional, c.ClientAuth)
+	}
+	return nil
+}
+
+// Server 读取证书并生成服务端 TLS 配置，未启用时返回 nil
+func (c Config) Server() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载证书 %s 失败: %v", c.CertFile, err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取客户端 CA %s 失败: %v", c.ClientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("客户端 CA %s 中没有可用的证书", c.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if c.ClientAuth == ClientAuthOptional {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// Identity 返回已通过校验的客户端证书的身份：优先取 CN，没有时依次取第一个 DNS、邮箱、URI、IP 类型的 SAN；
+// 没有证书或证书未经校验时返回空字符串
+func Identity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.IPAddresses) > 0:
+		return cert.IPAddresses[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issue 签发证书，parent 为 nil 时生成自签名 CA
+func issue(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("签发证书失败: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("解析证书失败: %v", err)
+	}
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("写入 %s 失败: %v", path, err)
+	}
+}
+
+func TestServerAndIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, _ := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test-ca"}}, nil, nil)
+	_, serverKey, serverCert := issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "agera"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	keyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		t.Fatalf("编码密钥失败: %v", err)
+	}
+	cfg := Config{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+	writePEM(t, cfg.CertFile, "CERTIFICATE", serverCert.Certificate[0])
+	writePEM(t, cfg.KeyFile, "EC PRIVATE KEY", keyDER)
+	writePEM(t, cfg.ClientCAFile, "CERTIFICATE", ca.Raw)
+
+	if err := cfg.Validate("tcp.tls"); err != nil {
+		t.Fatalf("配置应合法: %v", err)
+	}
+	for _, bad := range []Config{
+		{CertFile: cfg.CertFile},
+		{ClientCAFile: cfg.ClientCAFile},
+		{CertFile: cfg.CertFile, KeyFile: cfg.KeyFile, ClientAuth: "maybe"},
+	} {
+		if err := bad.Validate("tcp.tls"); err == nil {
+			t.Errorf("配置 %+v 应校验失败", bad)
+		}
+	}
+
+	server, err := cfg.Server()
+	if err != nil {
+		t.Fatalf("生成 TLS 配置失败: %v", err)
+	}
+	if server.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("默认应要求客户端证书，实际 %v", server.ClientAuth)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	for name, tc := range map[string]struct {
+		client *x509.Certificate
+		want   string
+	}{
+		"CN":     {&x509.Certificate{Subject: pkix.Name{CommonName: "shop-gateway"}, DNSNames: []string{"gw.shop"}}, "shop-gateway"},
+		"SAN 兜底": {&x509.Certificate{DNSNames: []string{"gw.shop"}}, "gw.shop"},
+		"无客户端证书": {nil, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clientCfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if tc.client != nil {
+				tc.client.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+				_, _, cert := issue(t, tc.client, ca, caKey)
+				clientCfg.Certificates = []tls.Certificate{cert}
+			}
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("监听失败: %v", err)
+			}
+			defer lis.Close()
+			go func() {
+				if c, err := tls.Dial("tcp", lis.Addr().String(), clientCfg); err == nil {
+					defer c.Close()
+					io.Copy(io.Discard, c)
+				}
+			}()
+			serverConn, err := lis.Accept()
+			if err != nil {
+				t.Fatalf("接受连接失败: %v", err)
+			}
+			defer serverConn.Close()
+
+			conn := tls.Server(serverConn, server)
+			err = conn.Handshake()
+			if tc.client == nil {
+				if err == nil {
+					t.Fatalf("要求客户端证书时，未提供证书的握手应失败")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("握手失败: %v", err)
+			}
+			state := conn.ConnectionState()
+			if got := Identity(&state); got != tc.want {
+				t.Errorf("身份应为 %q，实际 %q", tc.want, got)
+			}
+		})
+	}
+
+	if got := Identity(nil); got != "" {
+		t.Errorf("明文连接的身份应为空，实际 %q", got)
+	}
+}
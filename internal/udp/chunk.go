@@ -0,0 +1,189 @@
+package udp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 大消息相关的默认参数
+const (
+	DefaultMaxMessageSize  = 1 << 20
+	DefaultChunkTimeout    = 5 * time.Second
+	DefaultMaxPendingBytes = 32 << 20
+	// MaxDatagramSize UDP 数据包的最大长度
+	MaxDatagramSize = 65535
+)
+
+// 分片格式与 GELF 一致：2 字节魔数 0x1e 0x0f，8 字节消息 ID，1 字节分片序号（从 0 开始），1 字节分片总数（最多 128），之后是分片数据。
+// 所有分片拼接后的内容可以是 JSON，也可以是 gzip / zlib / zstd 压缩后的 JSON
+const (
+	chunkHeaderSize = 12
+	maxChunks       = 128
+)
+
+var (
+	chunkMagic = []byte{0x1e, 0x0f}
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// 分片和解压失败时计入丢弃统计的原因
+const (
+	DropInvalidChunk      = "invalid_chunk"
+	DropIncompleteMessage = "incomplete_message"
+	DropChunkMemoryFull   = "chunk_memory_full"
+	DropMessageTooLarge   = "message_too_large"
+	DropDecompressFailed  = "decompress_failed"
+)
+
+// IsChunk 数据包是否为分片
+func IsChunk(data []byte) bool {
+	return bytes.HasPrefix(data, chunkMagic)
+}
+
+type chunkKey struct {
+	addr string
+	id   uint64
+}
+
+// partialMessage 尚未收齐分片的消息
+type partialMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	first    time.Time
+}
+
+// Assembler 按来源地址和消息 ID 重组分片，只应在一个读循环中使用，不加锁。
+// 超过 timeout 未收齐的消息被丢弃；等待重组的数据总量超过 maxPending 时丢弃新消息的分片
+type Assembler struct {
+	timeout    time.Duration
+	maxMessage int
+	maxPending int
+	pending    map[chunkKey]*partialMessage
+	bytes      int
+}
+
+func NewAssembler(timeout time.Duration, maxMessage, maxPending int) *Assembler {
+	return &Assembler{
+		timeout:    timeout,
+		maxMessage: maxMessage,
+		maxPending: maxPending,
+		pending:    make(map[chunkKey]*partialMessage),
+	}
+}
+
+// Add 加入一个分片，收齐后返回完整的消息；reason 非空表示分片或消息被丢弃
+func (a *Assembler) Add(addr string, data []byte, now time.Time) (message []byte, reason string) {
+	if len(data) < chunkHeaderSize {
+		return nil, DropInvalidChunk
+	}
+	key := chunkKey{addr: addr, id: binary.BigEndian.Uint64(data[2:10])}
+	seq, count := int(data[10]), int(data[11])
+	if count == 0 || count > maxChunks || seq >= count {
+		return nil, DropInvalidChunk
+	}
+	payload := data[chunkHeaderSize:]
+
+	msg, ok := a.pending[key]
+	if a.bytes+len(payload) > a.maxPending {
+		if ok {
+			a.remove(key, msg)
+		}
+		return nil, DropChunkMemoryFull
+	}
+	if !ok {
+		msg = &partialMessage{chunks: make([][]byte, count), first: now}
+		a.pending[key] = msg
+	}
+	if len(msg.chunks) != count {
+		a.remove(key, msg)
+		return nil, DropInvalidChunk
+	}
+	if msg.chunks[seq] != nil {
+		return nil, "" // 重复的分片
+	}
+	if msg.size+len(payload) > a.maxMessage {
+		a.remove(key, msg)
+		return nil, DropMessageTooLarge
+	}
+	msg.chunks[seq] = payload
+	msg.received++
+	msg.size += len(payload)
+	a.bytes += len(payload)
+	if msg.received < count {
+		return nil, ""
+	}
+
+	a.remove(key, msg)
+	return bytes.Join(msg.chunks, nil), ""
+}
+
+func (a *Assembler) remove(key chunkKey, msg *partialMessage) {
+	a.bytes -= msg.size
+	delete(a.pending, key)
+}
+
+// Expire 丢弃超时未收齐的消息，返回丢弃的数量
+func (a *Assembler) Expire(now time.Time) int {
+	n := 0
+	for key, msg := range a.pending {
+		if now.Sub(msg.first) > a.timeout {
+			a.remove(key, msg)
+			n++
+		}
+	}
+	return n
+}
+
+// ErrMessageTooLarge 解压后的消息超过大小限制
+var ErrMessageTooLarge = errors.New("消息超过大小限制")
+
+// Decompress 按魔数识别 gzip / zlib / zstd 压缩并解压，未压缩的数据原样返回；解压后超过 limit 字节时返回 ErrMessageTooLarge
+func Decompress(data []byte, limit int) ([]byte, error) {
+	var r io.ReadCloser
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip 解压失败: %v", err)
+		}
+		r = gr
+	case isZlib(data):
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("zlib 解压失败: %v", err)
+		}
+		r = zr
+	case bytes.HasPrefix(data, zstdMagic):
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, fmt.Errorf("zstd 解压失败: %v", err)
+		}
+		r = zr.IOReadCloser()
+	default:
+		return data, nil
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("解压失败: %v", err)
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("%w: 解压后超过 %d 字节", ErrMessageTooLarge, limit)
+	}
+	return out, nil
+}
+
+// isZlib zlib 头：CMF 为 deflate（低 4 位为 8），且 CMF、FLG 组成的 16 位整数是 31 的倍数
+func isZlib(data []byte) bool {
+	return len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
@@ -0,0 +1,102 @@
+package udp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// chunks 按 GELF 格式把 data 切成 size 字节的分片
+func chunks(id uint64, data []byte, size int) [][]byte {
+	var parts [][]byte
+	for i := 0; i < len(data); i += size {
+		parts = append(parts, data[i:min(i+size, len(data))])
+	}
+	out := make([][]byte, len(parts))
+	for i, part := range parts {
+		header := append([]byte{}, chunkMagic...)
+		header = binary.BigEndian.AppendUint64(header, id)
+		out[i] = append(append(header, byte(i), byte(len(parts))), part...)
+	}
+	return out
+}
+
+func TestAssembler(t *testing.T) {
+	now := time.Now()
+	a := NewAssembler(time.Second, 100, 150)
+	message := []byte(strings.Repeat("abcdefghij", 9))
+	parts := chunks(7, message, 20)
+
+	// 乱序和重复的分片
+	for _, i := range []int{4, 0, 0, 2, 3} {
+		if got, reason := a.Add("1.2.3.4:5", parts[i], now); got != nil || reason != "" {
+			t.Fatalf("分片 %d 未收齐时不应返回消息: %q %q", i, got, reason)
+		}
+	}
+	// 另一个来源的相同消息 ID 互不影响
+	if _, reason := a.Add("5.6.7.8:9", parts[1], now); reason != "" {
+		t.Fatalf("其他来源的分片不应被丢弃: %s", reason)
+	}
+	got, reason := a.Add("1.2.3.4:5", parts[1], now)
+	if reason != "" || !bytes.Equal(got, message) {
+		t.Fatalf("重组结果不符合预期: %q %q", got, reason)
+	}
+
+	if _, reason := a.Add("1.2.3.4:5", parts[0][:8], now); reason != DropInvalidChunk {
+		t.Errorf("过短的分片应丢弃，实际 %q", reason)
+	}
+	big := chunks(8, []byte(strings.Repeat("x", 120)), 60)
+	a.Add("1.2.3.4:5", big[0], now)
+	if _, reason := a.Add("1.2.3.4:5", big[1], now); reason != DropMessageTooLarge {
+		t.Errorf("超过大小限制的消息应丢弃，实际 %q", reason)
+	}
+	more := chunks(9, []byte(strings.Repeat("y", 100)), 50)
+	a.Add("9.9.9.9:1", more[0], now)
+	a.Add("9.9.9.9:2", more[0], now)
+	if _, reason := a.Add("9.9.9.9:3", more[0], now); reason != DropChunkMemoryFull {
+		t.Errorf("等待重组的数据超过上限时应丢弃，实际 %q", reason)
+	}
+	if n := a.Expire(now.Add(2 * time.Second)); n != 3 || a.bytes != 0 {
+		t.Errorf("超时应丢弃 3 条未收齐的消息并释放内存，实际 %d 条、%d 字节", n, a.bytes)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	message := []byte(`{"module":"order","error_info":"` + strings.Repeat("at frame\\n", 1000) + `"}`)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(message)
+	w.Close()
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write(message)
+	zw.Close()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("创建 zstd 编码器失败: %v", err)
+	}
+	zs := enc.EncodeAll(message, nil)
+
+	for name, data := range map[string][]byte{"plain": message, "gzip": gz.Bytes(), "zlib": zl.Bytes(), "zstd": zs} {
+		got, err := Decompress(data, len(message))
+		if err != nil || !bytes.Equal(got, message) {
+			t.Errorf("%s 解压结果不符合预期: %v", name, err)
+		}
+		if name != "plain" {
+			if _, err := Decompress(data, len(message)-1); !errors.Is(err, ErrMessageTooLarge) {
+				t.Errorf("%s 解压后超过限制应报错，实际 %v", name, err)
+			}
+		}
+	}
+	if _, err := Decompress(append([]byte{}, gzipMagic...), 100); err == nil || errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("损坏的 gzip 数据应解压失败，实际 %v", err)
+	}
+}
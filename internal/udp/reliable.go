@@ -0,0 +1,202 @@
+package udp
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+)
+
+// 可靠模式的默认参数
+const (
+	DefaultAckWindow  = 1024
+	DefaultClientTTL  = 10 * time.Minute
+	DefaultMaxClients = 10000
+	// maxSelectiveAcks 一个确认包中最多携带的选择确认序号，保证确认包不超过常见 MTU
+	maxSelectiveAcks = 128
+)
+
+// reliableRequest 可靠模式的日志，在普通请求的基础上带 client_id 和从 1 开始递增的 seq；
+// 不带 client_id 的日志按旧方式处理
+type reliableRequest struct {
+	pipeline.Request
+	ClientID string `json:"client_id,omitempty"`
+	Seq      uint64 `json:"seq,omitempty"`
+}
+
+// 可靠模式下一个数据包的处理结果
+const (
+	AckAccepted  = "accepted"  // 已接收
+	AckRejected  = "rejected"  // 校验未通过，重发也不会被接收
+	AckDuplicate = "duplicate" // 重复的数据包，之前已处理
+	AckRetry     = "retry"     // 暂时无法处理（缓冲区满、限流、超出确认窗口），稍后重发
+)
+
+// ack 可靠模式的确认包，回复到数据包的来源地址和端口。
+// cumulative 及之前的序号都已处理，selective 为大于 cumulative 的已处理序号（最多 maxSelectiveAcks 个），
+// 客户端据此重发未确认的数据包
+type ack struct {
+	ClientID   string   `json:"client_id"`
+	Seq        uint64   `json:"seq"`
+	Status     string   `json:"status"`
+	Reason     string   `json:"reason,omitempty"`
+	Cumulative uint64   `json:"cumulative"`
+	Selective  []uint64 `json:"selective,omitempty"`
+}
+
+// 序号的登记结果
+const (
+	claimNew = iota
+	claimDuplicate
+	claimInFlight
+	claimOutOfWindow
+	claimTooManyClients
+)
+
+// clientWindow 一个客户端的去重窗口。服务端重启或客户端过期后之前的状态已丢失，窗口从重新出现后的首个序号 start 开始；
+// 之后迟到的 (floor, start) 之间的序号仍然处理（可能与重启前重复，保证至少一次），late 记录其中已处理的序号
+type clientWindow struct {
+	cumulative uint64              // 该序号及之前的都已处理
+	received   map[uint64]struct{} // 大于 cumulative 的已处理序号
+	inflight   map[uint64]struct{} // 已登记、正在提交的序号，处理完之前重发的数据包不确认
+	start      uint64
+	floor      uint64
+	late       map[uint64]struct{}
+	seen       time.Time
+}
+
+func newClientWindow(seq, window uint64) *clientWindow {
+	w := &clientWindow{
+		cumulative: seq - 1,
+		received:   make(map[uint64]struct{}),
+		inflight:   make(map[uint64]struct{}),
+		start:      seq,
+		late:       make(map[uint64]struct{}),
+	}
+	if seq-1 > window {
+		w.floor = seq - 1 - window
+	}
+	return w
+}
+
+// dedupe 按 client_id 记录已处理的序号，在窗口内对重发的数据包去重
+type dedupe struct {
+	mu         sync.Mutex
+	window     uint64
+	ttl        time.Duration
+	maxClients int
+	clients    map[string]*clientWindow
+}
+
+func newDedupe(window int, ttl time.Duration, maxClients int) *dedupe {
+	if window <= 0 {
+		window = DefaultAckWindow
+	}
+	if ttl <= 0 {
+		ttl = DefaultClientTTL
+	}
+	if maxClients <= 0 {
+		maxClients = DefaultMaxClients
+	}
+	return &dedupe{window: uint64(window), ttl: ttl, maxClients: maxClients, clients: make(map[string]*clientWindow)}
+}
+
+// claim 登记序号（seq 从 1 开始）。返回 claimNew 时调用方负责处理该数据包，处理完成后调用 confirm，
+// 暂时失败时调用 release；在此之前同一序号再次登记返回 claimInFlight
+func (d *dedupe) claim(clientID string, seq uint64, now time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.clients[clientID]
+	if !ok {
+		if len(d.clients) >= d.maxClients {
+			d.expireLocked(now)
+			if len(d.clients) >= d.maxClients {
+				return claimTooManyClients
+			}
+		}
+		w = newClientWindow(seq, d.window)
+		d.clients[clientID] = w
+	}
+	w.seen = now
+	if _, busy := w.inflight[seq]; busy {
+		return claimInFlight
+	}
+	if seq <= w.cumulative {
+		if _, done := w.late[seq]; done || seq <= w.floor || seq >= w.start {
+			return claimDuplicate
+		}
+	} else if _, done := w.received[seq]; done {
+		return claimDuplicate
+	} else if seq > w.cumulative+d.window {
+		return claimOutOfWindow
+	}
+	w.inflight[seq] = struct{}{}
+	return claimNew
+}
+
+// confirm 标记 claim 登记的序号已处理（接收或永久拒绝），之后重发的数据包按重复处理
+func (d *dedupe) confirm(clientID string, seq uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.clients[clientID]
+	if !ok {
+		return
+	}
+	delete(w.inflight, seq)
+	if seq <= w.cumulative {
+		w.late[seq] = struct{}{}
+		return
+	}
+	w.received[seq] = struct{}{}
+	for {
+		if _, ok := w.received[w.cumulative+1]; !ok {
+			break
+		}
+		delete(w.received, w.cumulative+1)
+		w.cumulative++
+	}
+}
+
+// release 撤销 claim 登记的序号，使客户端重发时能再次处理
+func (d *dedupe) release(clientID string, seq uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if w, ok := d.clients[clientID]; ok {
+		delete(w.inflight, seq)
+	}
+}
+
+// state 返回客户端当前的累计确认序号和选择确认序号
+func (d *dedupe) state(clientID string) (uint64, []uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.clients[clientID]
+	if !ok {
+		return 0, nil
+	}
+	selective := make([]uint64, 0, len(w.received))
+	for seq := range w.received {
+		selective = append(selective, seq)
+	}
+	sort.Slice(selective, func(i, j int) bool { return selective[i] < selective[j] })
+	if len(selective) > maxSelectiveAcks {
+		selective = selective[:maxSelectiveAcks]
+	}
+	return w.cumulative, selective
+}
+
+// expire 清理超过 ttl 没有数据包的客户端
+func (d *dedupe) expire(now time.Time) {
+	d.mu.Lock()
+	d.expireLocked(now)
+	d.mu.Unlock()
+}
+
+func (d *dedupe) expireLocked(now time.Time) {
+	for id, w := range d.clients {
+		if now.Sub(w.seen) > d.ttl {
+			delete(d.clients, id)
+		}
+	}
+}
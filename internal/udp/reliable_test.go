@@ -0,0 +1,158 @@
+package udp
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vkeeps/agera-logs/internal/db"
+	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
+)
+
+func TestDedupeWindow(t *testing.T) {
+	now := time.Now()
+	d := newDedupe(4, time.Minute, 2)
+	process := func(clientID string, seq uint64) int {
+		got := d.claim(clientID, seq, now)
+		if got == claimNew {
+			d.confirm(clientID, seq)
+		}
+		return got
+	}
+
+	for _, seq := range []uint64{1, 3, 4} {
+		if got := process("a", seq); got != claimNew {
+			t.Fatalf("seq %d 应为新数据包，实际 %d", seq, got)
+		}
+	}
+	if cum, sel := d.state("a"); cum != 1 || !reflect.DeepEqual(sel, []uint64{3, 4}) {
+		t.Errorf("确认状态不符合预期: %d %v", cum, sel)
+	}
+	if got := d.claim("a", 3, now); got != claimDuplicate {
+		t.Errorf("重发的 seq 3 应去重，实际 %d", got)
+	}
+	if got := d.claim("a", 6, now); got != claimOutOfWindow {
+		t.Errorf("超出窗口的 seq 应等待重发，实际 %d", got)
+	}
+	if got := process("a", 2); got != claimNew {
+		t.Errorf("补发的 seq 2 应为新数据包，实际 %d", got)
+	}
+	if cum, sel := d.state("a"); cum != 4 || len(sel) != 0 {
+		t.Errorf("补齐后累计确认应为 4，实际 %d %v", cum, sel)
+	}
+
+	// 正在提交的序号不确认为重复，提交失败撤销后可以再次处理
+	if got := d.claim("a", 5, now); got != claimNew {
+		t.Fatalf("seq 5 应为新数据包，实际 %d", got)
+	}
+	if got := d.claim("a", 5, now); got != claimInFlight {
+		t.Errorf("正在提交的 seq 5 不应确认为重复，实际 %d", got)
+	}
+	if cum, _ := d.state("a"); cum != 4 {
+		t.Errorf("提交完成之前不应确认 seq 5，实际累计确认 %d", cum)
+	}
+	d.release("a", 5)
+	if got := process("a", 5); got != claimNew {
+		t.Errorf("撤销后重发应再次处理，实际 %d", got)
+	}
+	if cum, _ := d.state("a"); cum != 5 {
+		t.Errorf("提交完成后累计确认应为 5，实际 %d", cum)
+	}
+
+	// 首次出现的客户端从首个序号开始建立窗口，迟到的较小序号仍处理一次
+	if got := process("b", 5000); got != claimNew {
+		t.Fatalf("新客户端的首个序号应为新数据包，实际 %d", got)
+	}
+	if got := process("b", 5001); got != claimNew {
+		t.Errorf("新客户端的后续序号不应超出窗口，实际 %d", got)
+	}
+	if got := process("b", 4998); got != claimNew {
+		t.Errorf("迟到的 seq 4998 应处理，实际 %d", got)
+	}
+	if got := d.claim("b", 4998, now); got != claimDuplicate {
+		t.Errorf("已处理的迟到序号应去重，实际 %d", got)
+	}
+	if got := d.claim("b", 4990, now); got != claimDuplicate {
+		t.Errorf("窗口之前的序号应视为已处理，实际 %d", got)
+	}
+	if cum, sel := d.state("b"); cum != 5001 || len(sel) != 0 {
+		t.Errorf("新客户端确认状态不符合预期: %d %v", cum, sel)
+	}
+
+	if got := d.claim("c", 1, now); got != claimTooManyClients {
+		t.Errorf("超过客户端上限应拒绝，实际 %d", got)
+	}
+	if got := d.claim("c", 1, now.Add(2*time.Minute)); got != claimNew {
+		t.Errorf("过期的客户端清理后应可登记新客户端，实际 %d", got)
+	}
+}
+
+func TestReliableAck(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	store := db.NewMemoryStorage()
+	if _, err := store.GetOrCreateSchema("shop"); err != nil {
+		t.Fatalf("创建 schema 失败: %v", err)
+	}
+	p := pipeline.New(store, pipeline.Config{BatchSize: 100, BatchTimeout: time.Hour}, log)
+
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer server.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer client.Close()
+	clientAddr := client.LocalAddr().(*net.UDPAddr)
+	tracker := newDedupe(0, 0, 0)
+
+	send := func(req reliableRequest) ack {
+		t.Helper()
+		data, _ := json.Marshal(req)
+		handlePacket(server, data, clientAddr, DefaultConfig(), tracker, p, log)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 4096)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("未收到确认: %v", err)
+		}
+		var reply ack
+		if err := json.Unmarshal(buf[:n], &reply); err != nil {
+			t.Fatalf("解析确认失败: %v", err)
+		}
+		return reply
+	}
+
+	req := reliableRequest{
+		Request:  pipeline.Request{SchemaID: db.GenerateSchemaID("shop"), Module: "order", Service: "svc", Output: "x"},
+		ClientID: "agent-1",
+		Seq:      1,
+	}
+	if reply := send(req); reply.Status != AckAccepted || reply.Cumulative != 1 {
+		t.Errorf("首个数据包应被接收: %+v", reply)
+	}
+	if reply := send(req); reply.Status != AckDuplicate || reply.Cumulative != 1 {
+		t.Errorf("重发的数据包应去重: %+v", reply)
+	}
+	req.Seq, req.Service = 3, ""
+	if reply := send(req); reply.Status != AckRejected || reply.Reason == "" || !reflect.DeepEqual(reply.Selective, []uint64{3}) {
+		t.Errorf("校验失败的数据包应拒绝并计入选择确认: %+v", reply)
+	}
+
+	p.Flush()
+	page, _ := store.QueryLogs(db.LogQuery{Schema: "shop", Module: "order"})
+	if len(page.Logs) != 1 {
+		t.Errorf("重发的数据包只应写入一次，实际 %d 条", len(page.Logs))
+	}
+	if dropped := p.Stats().Transports[model.PushTypeUDP].Dropped["duplicate"]; dropped != 1 {
+		t.Errorf("重复数据包应计入丢弃统计，实际 %d", dropped)
+	}
+}
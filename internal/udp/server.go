@@ -2,65 +2,67 @@ package udp
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"github.com/vkeeps/agera-logs/internal/db"
 	"github.com/vkeeps/agera-logs/internal/model"
+	"github.com/vkeeps/agera-logs/internal/pipeline"
 )
 
-type UDPLogRequest struct {
-	SchemaID          string `json:"schema_id,schemaId"`
-	Module            string `json:"module"`
-	Output            string `json:"output"`
-	Detail            string `json:"detail,omitempty"`
-	ErrorInfo         string `json:"error_info,omitempty"`
-	Service           string `json:"service,omitempty"`
-	LogLevel          string `json:"log_level,omitempty"`
-	OperatorID        string `json:"operator_id,operatorId"`
-	Operator          string `json:"operator,omitempty"`
-	OperatorIP        string `json:"operator_ip,omitempty"`
-	OperatorEquipment string `json:"operator_equipment,omitempty"`
-	OperatorCompany   string `json:"operator_company,omitempty"`
-	OperatorProject   string `json:"operator_project,omitempty"`
+// Config UDP 服务配置
+type Config struct {
+	Port        int           `yaml:"port"`         // 起始端口，被占用时依次尝试下一个
+	AckPort     int           `yaml:"ack_port"`     // 旧版确认服务端口，只用于不带 client_id 的日志
+	ReadTimeout time.Duration `yaml:"read_timeout"` // 读超时，用于定期检查停止信号
+	AckWindow   int           `yaml:"ack_window"`   // 可靠模式下每个客户端的去重窗口（序号个数），超出窗口的数据包需稍后重发
+	ClientTTL   time.Duration `yaml:"client_ttl"`   // 可靠模式下客户端超过该时间没有数据包后清除其去重状态
+	MaxClients  int           `yaml:"max_clients"`  // 可靠模式下同时记录的客户端数量上限
+
+	MaxMessageSize  int           `yaml:"max_message_size"`  // 分片重组和解压后单条消息的最大字节数
+	ChunkTimeout    time.Duration `yaml:"chunk_timeout"`     // 分片需在该时间内收齐，否则丢弃整条消息
+	MaxPendingBytes int           `yaml:"max_pending_bytes"` // 等待重组的分片总字节数上限
 }
 
-var (
-	batchSize      = 20
-	batchTimeout   = 1 * time.Millisecond
-	bufferCapacity = 500
-	ackPort        = 50054
-)
+// DefaultConfig 默认配置
+func DefaultConfig() Config {
+	return Config{
+		Port:        50052,
+		AckPort:     50054,
+		ReadTimeout: time.Second,
+		AckWindow:   DefaultAckWindow,
+		ClientTTL:   DefaultClientTTL,
+		MaxClients:  DefaultMaxClients,
 
-var (
-	insertedCount int64
-	receivedCount int64
-)
+		MaxMessageSize:  DefaultMaxMessageSize,
+		ChunkTimeout:    DefaultChunkTimeout,
+		MaxPendingBytes: DefaultMaxPendingBytes,
+	}
+}
 
-func init() {
-	if size, err := strconv.Atoi(os.Getenv("BATCH_SIZE")); err == nil && size > 0 {
-		batchSize = size
+// withDefaults 未设置的字段使用默认值
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = d.MaxMessageSize
 	}
-	if timeout, err := time.ParseDuration(os.Getenv("BATCH_TIMEOUT")); err == nil && timeout > 0 {
-		batchTimeout = timeout
+	if c.ChunkTimeout <= 0 {
+		c.ChunkTimeout = d.ChunkTimeout
 	}
-	if capacity, err := strconv.Atoi(os.Getenv("BUFFER_CAPACITY")); err == nil && capacity > 0 {
-		bufferCapacity = capacity
+	if c.MaxPendingBytes <= 0 {
+		c.MaxPendingBytes = d.MaxPendingBytes
 	}
+	return c
 }
 
-func validModule(module string) bool {
-	return module != ""
-}
-
-func StartUDPServer(basePort int, stopChan chan struct{}, log *logrus.Logger) {
-	port := basePort
+func StartUDPServer(cfg Config, stopChan chan struct{}, p *pipeline.Pipeline, log *logrus.Logger) {
+	cfg = cfg.withDefaults()
+	port := cfg.Port
 	var conn *net.UDPConn
 	for {
 		if port > 65535 {
@@ -88,163 +90,25 @@ func StartUDPServer(basePort int, stopChan chan struct{}, log *logrus.Logger) {
 	os.Setenv("UDP_PORT", strconv.Itoa(port))
 	log.Info(fmt.Sprintf("UDP 服务跑起来了，端口: %d", port))
 
-	go startAckServer(ackPort, stopChan, log)
-
-	logBuffer := make([]*model.Log, 0, bufferCapacity)
-	var mu sync.Mutex
-	ticker := time.NewTicker(batchTimeout)
-	defer ticker.Stop()
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				mu.Lock()
-				if len(logBuffer) > 0 {
-					entries := make([]*model.Log, len(logBuffer))
-					copy(entries, logBuffer)
-					logBuffer = logBuffer[:0]
-					log.Info(fmt.Sprintf("准备插入 %d 条日志，缓冲区剩余 %d", len(entries), len(logBuffer)))
-					mu.Unlock()
+	readTimeout := cfg.ReadTimeout
+	if readTimeout <= 0 {
+		readTimeout = time.Second
+	}
 
-					start := time.Now()
-					if err := db.InsertLogs(entries, log); err != nil {
-						log.Error(fmt.Sprintf("批量插入 %d 条日志失败: %v", len(entries), err))
-					} else {
-						atomic.AddInt64(&insertedCount, int64(len(entries)))
-						log.Info(fmt.Sprintf("成功插入 %d 条日志，耗时 %v，总计插入 %d 条", len(entries), time.Since(start), atomic.LoadInt64(&insertedCount)))
-					}
-				} else {
-					mu.Unlock()
-				}
-			case <-stopChan:
-				mu.Lock()
-				if len(logBuffer) > 0 {
-					entries := make([]*model.Log, len(logBuffer))
-					copy(entries, logBuffer)
-					logBuffer = logBuffer[:0]
-					log.Info(fmt.Sprintf("停止服务，插入剩余 %d 条日志", len(entries)))
-					mu.Unlock()
-					if err := db.InsertLogs(entries, log); err != nil {
-						log.Error(fmt.Sprintf("批量插入 %d 条日志失败: %v", len(entries), err))
-					} else {
-						atomic.AddInt64(&insertedCount, int64(len(entries)))
-						log.Info(fmt.Sprintf("成功插入 %d 条日志，总计插入 %d 条", len(entries), atomic.LoadInt64(&insertedCount)))
-					}
-				} else {
-					mu.Unlock()
-				}
-				return
-			}
-		}
-	}()
+	go startAckServer(cfg.AckPort, readTimeout, stopChan, log)
+	tracker := newDedupe(cfg.AckWindow, cfg.ClientTTL, cfg.MaxClients)
 
 	dataChan := make(chan struct {
 		data []byte
 		addr *net.UDPAddr
-	}, bufferCapacity)
+	}, p.Capacity())
 	var wg sync.WaitGroup
 	for i := 0; i < 5; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			// 在 StartUDPServer 的 goroutine 中处理数据时添加检查
 			for pkt := range dataChan {
-				var req UDPLogRequest
-				if err := json.Unmarshal(pkt.data, &req); err != nil {
-					log.Error(fmt.Sprintf("UDP 数据解析失败: %v, 原始数据: %s", err, string(pkt.data)))
-					continue
-				}
-
-				if req.SchemaID == "" {
-					log.Error(fmt.Sprintf("收到空 schema_id，原始数据: %s", string(pkt.data)))
-					continue
-				}
-
-				// 检查 service 是否为空
-				if req.Service == "" {
-					log.Error(fmt.Sprintf("UDP 日志缺少 service 字段，跳过插入，原始数据: %+v", req))
-					continue
-				}
-
-				schemaName, err := db.GetSchemaNameByID(req.SchemaID, log)
-				if err != nil {
-					log.Error(fmt.Sprintf("获取 schema_id %s 失败: %v", req.SchemaID, err))
-					continue
-				}
-				if schemaName == "" {
-					log.Warn(fmt.Sprintf("无效的 schema_id: %s，未在 BoltDB 中注册, 原始数据: %s", req.SchemaID, string(pkt.data)))
-					db.RebuildSchemaCache(req.SchemaID, log)
-					start := time.Now()
-					timeout := 100 * time.Millisecond
-					for time.Since(start) < timeout {
-						schemaName, err = db.GetSchemaNameByID(req.SchemaID, log)
-						if err == nil && schemaName != "" {
-							break
-						}
-						time.Sleep(10 * time.Millisecond)
-					}
-					if schemaName == "" {
-						log.Error(fmt.Sprintf("重试后仍无效的 schema_id: %s，跳过插入", req.SchemaID))
-						continue
-					}
-				}
-
-				if !validModule(req.Module) {
-					log.Error(fmt.Sprintf("无效的 module: %s", req.Module))
-					continue
-				}
-
-				clientIP, clientAddr := parseRemoteAddr(pkt.addr.String())
-
-				entry := &model.Log{
-					LogBase: model.LogBase{
-						Output:     req.Output,
-						Detail:     req.Detail,
-						ErrorInfo:  req.ErrorInfo,
-						Service:    req.Service,
-						ClientIP:   clientIP,
-						ClientAddr: clientAddr,
-						LogLevel:   req.LogLevel,
-					},
-					Schema:            model.LogSchema(schemaName),
-					Module:            model.LogModule(req.Module),
-					PushType:          model.PushTypeUDP,
-					Timestamp:         time.Now(),
-					OperatorID:        req.OperatorID,
-					Operator:          req.Operator,
-					OperatorIP:        req.OperatorIP,
-					OperatorEquipment: req.OperatorEquipment,
-					OperatorCompany:   req.OperatorCompany,
-					OperatorProject:   req.OperatorProject,
-				}
-
-				mu.Lock()
-				if len(logBuffer) < bufferCapacity {
-					logBuffer = append(logBuffer, entry)
-					count := atomic.AddInt64(&receivedCount, 1)
-					log.Info(fmt.Sprintf("收到第 %d 条数据，从 %s", count, pkt.addr.String()))
-					go sendAck(pkt.addr, log)
-				} else {
-					log.Error(fmt.Sprintf("缓冲区已满，丢弃日志: %v", entry))
-				}
-				if len(logBuffer) >= batchSize {
-					entries := make([]*model.Log, len(logBuffer))
-					copy(entries, logBuffer)
-					logBuffer = logBuffer[:0]
-					log.Info(fmt.Sprintf("准备插入 %d 条日志，缓冲区剩余 %d", len(entries), len(logBuffer)))
-					mu.Unlock()
-
-					start := time.Now()
-					if err := db.InsertLogs(entries, log); err != nil {
-						log.Error(fmt.Sprintf("批量插入 %d 条日志失败: %v", len(entries), err))
-					} else {
-						atomic.AddInt64(&insertedCount, int64(len(entries)))
-						log.Info(fmt.Sprintf("成功插入 %d 条日志，耗时 %v，总计插入 %d 条", len(entries), time.Since(start), atomic.LoadInt64(&insertedCount)))
-					}
-				} else {
-					mu.Unlock()
-				}
+				handlePacket(conn, pkt.data, pkt.addr, cfg, tracker, p, log)
 			}
 		}()
 	}
@@ -254,18 +118,22 @@ func StartUDPServer(basePort int, stopChan chan struct{}, log *logrus.Logger) {
 		wg.Wait()
 	}()
 
-	readTimeout := 1 * time.Second
-	if t, err := time.ParseDuration(os.Getenv("READ_TIMEOUT")); err == nil && t > 0 {
-		readTimeout = t
-	}
-
-	buf := make([]byte, 4096)
+	asm := NewAssembler(cfg.ChunkTimeout, cfg.MaxMessageSize, cfg.MaxPendingBytes)
+	buf := make([]byte, MaxDatagramSize)
+	lastExpire := time.Now()
 	for {
 		select {
 		case <-stopChan:
 			log.Info("收到停止信号，关闭 UDP 服务")
 			return
 		default:
+			if time.Since(lastExpire) > readTimeout {
+				tracker.expire(time.Now())
+				for n := asm.Expire(time.Now()); n > 0; n-- {
+					p.Drop(model.PushTypeUDP, DropIncompleteMessage)
+				}
+				lastExpire = time.Now()
+			}
 			conn.SetReadDeadline(time.Now().Add(readTimeout))
 			n, addr, err := conn.ReadFromUDP(buf)
 			if err != nil {
@@ -277,19 +145,120 @@ func StartUDPServer(basePort int, stopChan chan struct{}, log *logrus.Logger) {
 			}
 			data := make([]byte, n)
 			copy(data, buf[:n])
+			if IsChunk(data) {
+				message, reason := asm.Add(addr.String(), data, time.Now())
+				if reason != "" {
+					p.Drop(model.PushTypeUDP, reason)
+					log.Error(fmt.Sprintf("丢弃来自 %s 的 UDP 分片: %s", addr, reason))
+				}
+				if message == nil {
+					continue
+				}
+				data = message
+			}
 			select {
 			case dataChan <- struct {
 				data []byte
 				addr *net.UDPAddr
 			}{data, addr}:
 			default:
+				p.Drop(model.PushTypeUDP, "queue_full")
 				log.Error("数据通道已满，丢弃数据")
 			}
 		}
 	}
 }
 
-func startAckServer(port int, stopChan chan struct{}, log *logrus.Logger) {
+// handlePacket 解析并提交一个数据包：带 client_id 的按可靠模式去重并回复确认，否则按旧方式向 AckPort 发送确认
+func handlePacket(conn *net.UDPConn, data []byte, addr *net.UDPAddr, cfg Config, tracker *dedupe, p *pipeline.Pipeline, log *logrus.Logger) {
+	data, err := Decompress(data, cfg.MaxMessageSize)
+	if err != nil {
+		reason := DropDecompressFailed
+		if errors.Is(err, ErrMessageTooLarge) {
+			reason = DropMessageTooLarge
+		}
+		p.Drop(model.PushTypeUDP, reason)
+		log.Error(fmt.Sprintf("UDP 数据解压失败: %v, 来自 %s", err, addr))
+		return
+	}
+	var req reliableRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		log.Error(fmt.Sprintf("UDP 数据解析失败: %v, 原始数据: %s", err, string(data)))
+		return
+	}
+	clientIP, clientAddr := parseRemoteAddr(addr.String())
+
+	if req.ClientID == "" {
+		if err := p.SubmitRequest(&req.Request, model.PushTypeUDP, clientIP, clientAddr, ""); err != nil {
+			log.Error(fmt.Sprintf("UDP 日志未被接收: %v, 原始数据: %s", err, string(data)))
+			return
+		}
+		go sendAck(addr, cfg.AckPort, log)
+		return
+	}
+
+	reply := ack{ClientID: req.ClientID, Seq: req.Seq}
+	reply.Status, reply.Reason = submitReliable(&req, tracker, p, clientIP, clientAddr, log)
+	reply.Cumulative, reply.Selective = tracker.state(req.ClientID)
+
+	out, err := json.Marshal(reply)
+	if err != nil {
+		log.Error(fmt.Sprintf("编码 UDP 确认失败: %v", err))
+		return
+	}
+	if _, err := conn.WriteToUDP(out, addr); err != nil {
+		log.Error(fmt.Sprintf("发送 UDP 确认到 %s 失败: %v", addr, err))
+	}
+}
+
+// submitReliable 按 client_id 和 seq 去重后提交，返回确认状态和原因
+func submitReliable(req *reliableRequest, tracker *dedupe, p *pipeline.Pipeline, clientIP, clientAddr string, log *logrus.Logger) (string, string) {
+	if req.Seq == 0 {
+		return AckRejected, "seq 必须从 1 开始"
+	}
+	switch tracker.claim(req.ClientID, req.Seq, time.Now()) {
+	case claimDuplicate:
+		p.Drop(model.PushTypeUDP, "duplicate")
+		return AckDuplicate, ""
+	case claimInFlight:
+		// 首次收到的数据包还在提交，提交失败时需要客户端重发，不能确认为重复
+		p.Drop(model.PushTypeUDP, "in_flight")
+		return AckRetry, "数据包正在处理，请稍后重发"
+	case claimOutOfWindow:
+		p.Drop(model.PushTypeUDP, "out_of_window")
+		return AckRetry, "超出确认窗口，请先重发未确认的数据包"
+	case claimTooManyClients:
+		p.Drop(model.PushTypeUDP, "too_many_clients")
+		return AckRetry, "可靠模式的客户端数量已达上限"
+	}
+	err := p.SubmitRequest(&req.Request, model.PushTypeUDP, clientIP, clientAddr, "")
+	if err == nil {
+		tracker.confirm(req.ClientID, req.Seq)
+		return AckAccepted, ""
+	}
+	if retryable(err) {
+		tracker.release(req.ClientID, req.Seq)
+		return AckRetry, err.Error()
+	}
+	tracker.confirm(req.ClientID, req.Seq)
+	log.Error(fmt.Sprintf("UDP 日志未被接收: %v, 来自 %s 的 %s#%d", err, clientAddr, req.ClientID, req.Seq))
+	return AckRejected, err.Error()
+}
+
+// retryable 暂时性的拒绝，客户端重发后可能被接收，不计入已处理的序号
+func retryable(err error) bool {
+	var rejectErr *pipeline.RejectError
+	if !errors.As(err, &rejectErr) {
+		return true
+	}
+	switch rejectErr.Reason {
+	case pipeline.ReasonBufferFull, pipeline.ReasonSpoolFailed, pipeline.ReasonRateLimited, pipeline.ReasonSchemaLookup:
+		return true
+	}
+	return false
+}
+
+func startAckServer(port int, readTimeout time.Duration, stopChan chan struct{}, log *logrus.Logger) {
 	addr, err := net.ResolveUDPAddr("udp", ":"+strconv.Itoa(port))
 	if err != nil {
 		log.Fatal(fmt.Sprintf("解析确认端口 %d 失败: %v", port, err))
@@ -301,11 +270,6 @@ func startAckServer(port int, stopChan chan struct{}, log *logrus.Logger) {
 	defer conn.Close()
 	log.Info(fmt.Sprintf("确认服务器跑起来了，端口: %d", port))
 
-	readTimeout := 1 * time.Second
-	if t, err := time.ParseDuration(os.Getenv("READ_TIMEOUT")); err == nil && t > 0 {
-		readTimeout = t
-	}
-
 	buf := make([]byte, 1024)
 	for {
 		select {
@@ -332,7 +296,7 @@ func startAckServer(port int, stopChan chan struct{}, log *logrus.Logger) {
 	}
 }
 
-func sendAck(addr *net.UDPAddr, log *logrus.Logger) {
+func sendAck(addr *net.UDPAddr, ackPort int, log *logrus.Logger) {
 	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: addr.IP, Port: ackPort})
 	if err != nil {
 		log.Error(fmt.Sprintf("连接确认端口失败: %v", err))
@@ -36,8 +36,14 @@ type LogRequest struct {
 	OperatorCompany   string                 `protobuf:"bytes,11,opt,name=operator_company,json=operatorCompany,proto3" json:"operator_company,omitempty"`
 	OperatorProject   string                 `protobuf:"bytes,12,opt,name=operator_project,json=operatorProject,proto3" json:"operator_project,omitempty"`
 	LogLevel          string                 `protobuf:"bytes,13,opt,name=log_level,json=logLevel,proto3" json:"log_level,omitempty"`
-	unknownFields     protoimpl.UnknownFields
-	sizeCache         protoimpl.SizeCache
+	// attributes 自定义结构化字段，如 request_id、耗时、标签，可在查询接口中过滤和分组
+	Attributes map[string]string `protobuf:"bytes,14,rep,name=attributes,proto3" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// timestamp_unix_nano 事件时间（Unix 纳秒），不填时使用服务端接收时间
+	TimestampUnixNano int64 `protobuf:"varint,15,opt,name=timestamp_unix_nano,json=timestampUnixNano,proto3" json:"timestamp_unix_nano,omitempty"`
+	// token ingest token，仅 TCP 分帧模式使用，gRPC 通过 metadata 传递
+	Token         string `protobuf:"bytes,16,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *LogRequest) Reset() {
@@ -161,6 +167,27 @@ func (x *LogRequest) GetLogLevel() string {
 	return ""
 }
 
+func (x *LogRequest) GetAttributes() map[string]string {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+func (x *LogRequest) GetTimestampUnixNano() int64 {
+	if x != nil {
+		return x.TimestampUnixNano
+	}
+	return 0
+}
+
+func (x *LogRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
 type LogResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -205,11 +232,229 @@ func (x *LogResponse) GetSuccess() bool {
 	return false
 }
 
+type LogBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Logs          []*LogRequest          `protobuf:"bytes,1,rep,name=logs,proto3" json:"logs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogBatchRequest) Reset() {
+	*x = LogBatchRequest{}
+	mi := &file_proto_log_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogBatchRequest) ProtoMessage() {}
+
+func (x *LogBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_log_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogBatchRequest.ProtoReflect.Descriptor instead.
+func (*LogBatchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_log_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *LogBatchRequest) GetLogs() []*LogRequest {
+	if x != nil {
+		return x.Logs
+	}
+	return nil
+}
+
+// LogRejection 被拒绝的日志，index 为其在本次请求（或流）中的序号，从 0 开始
+type LogRejection struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogRejection) Reset() {
+	*x = LogRejection{}
+	mi := &file_proto_log_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogRejection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogRejection) ProtoMessage() {}
+
+func (x *LogRejection) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_log_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogRejection.ProtoReflect.Descriptor instead.
+func (*LogRejection) Descriptor() ([]byte, []int) {
+	return file_proto_log_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *LogRejection) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *LogRejection) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type LogBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      int32                  `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Rejected      int32                  `protobuf:"varint,2,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	Rejections    []*LogRejection        `protobuf:"bytes,3,rep,name=rejections,proto3" json:"rejections,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogBatchResponse) Reset() {
+	*x = LogBatchResponse{}
+	mi := &file_proto_log_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogBatchResponse) ProtoMessage() {}
+
+func (x *LogBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_log_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogBatchResponse.ProtoReflect.Descriptor instead.
+func (*LogBatchResponse) Descriptor() ([]byte, []int) {
+	return file_proto_log_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *LogBatchResponse) GetAccepted() int32 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *LogBatchResponse) GetRejected() int32 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+func (x *LogBatchResponse) GetRejections() []*LogRejection {
+	if x != nil {
+		return x.Rejections
+	}
+	return nil
+}
+
+// FrameAck TCP 分帧模式下每帧的确认，sequence 为该帧在连接中的序号，从 1 开始
+type FrameAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sequence      uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Accepted      bool                   `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FrameAck) Reset() {
+	*x = FrameAck{}
+	mi := &file_proto_log_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FrameAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FrameAck) ProtoMessage() {}
+
+func (x *FrameAck) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_log_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FrameAck.ProtoReflect.Descriptor instead.
+func (*FrameAck) Descriptor() ([]byte, []int) {
+	return file_proto_log_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FrameAck) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *FrameAck) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+func (x *FrameAck) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
 var File_proto_log_proto protoreflect.FileDescriptor
 
 const file_proto_log_proto_rawDesc = "" +
 	"\n" +
-	"\x0fproto/log.proto\x12\x05proto\"\xa3\x03\n" +
+	"\x0fproto/log.proto\x12\x05proto\"\xeb\x04\n" +
 	"\n" +
 	"LogRequest\x12\x16\n" +
 	"\x06schema\x18\x01 \x01(\tR\x06schema\x12\x16\n" +
@@ -230,12 +475,37 @@ const file_proto_log_proto_rawDesc = "" +
 	" \x01(\tR\x11operatorEquipment\x12)\n" +
 	"\x10operator_company\x18\v \x01(\tR\x0foperatorCompany\x12)\n" +
 	"\x10operator_project\x18\f \x01(\tR\x0foperatorProject\x12\x1b\n" +
-	"\tlog_level\x18\r \x01(\tR\blogLevel\"'\n" +
+	"\tlog_level\x18\r \x01(\tR\blogLevel\x12A\n" +
+	"\n" +
+	"attributes\x18\x0e \x03(\v2!.proto.LogRequest.AttributesEntryR\n" +
+	"attributes\x12.\n" +
+	"\x13timestamp_unix_nano\x18\x0f \x01(\x03R\x11timestampUnixNano\x12\x14\n" +
+	"\x05token\x18\x10 \x01(\tR\x05token\x1a=\n" +
+	"\x0fAttributesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"'\n" +
 	"\vLogResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess2>\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"8\n" +
+	"\x0fLogBatchRequest\x12%\n" +
+	"\x04logs\x18\x01 \x03(\v2\x11.proto.LogRequestR\x04logs\"<\n" +
+	"\fLogRejection\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"\x7f\n" +
+	"\x10LogBatchResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\x05R\baccepted\x12\x1a\n" +
+	"\brejected\x18\x02 \x01(\x05R\brejected\x123\n" +
+	"\n" +
+	"rejections\x18\x03 \x03(\v2\x13.proto.LogRejectionR\n" +
+	"rejections\"Z\n" +
+	"\bFrameAck\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x12\x1a\n" +
+	"\baccepted\x18\x02 \x01(\bR\baccepted\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason2\xb9\x01\n" +
 	"\n" +
 	"LogService\x120\n" +
-	"\aSendLog\x12\x11.proto.LogRequest\x1a\x12.proto.LogResponseB$Z\"github.com/vkeeps/agera-logs/protob\x06proto3"
+	"\aSendLog\x12\x11.proto.LogRequest\x1a\x12.proto.LogResponse\x128\n" +
+	"\bSendLogs\x12\x11.proto.LogRequest\x1a\x17.proto.LogBatchResponse(\x01\x12?\n" +
+	"\fSendLogBatch\x12\x16.proto.LogBatchRequest\x1a\x17.proto.LogBatchResponseB$Z\"github.com/vkeeps/agera-logs/protob\x06proto3"
 
 var (
 	file_proto_log_proto_rawDescOnce sync.Once
@@ -249,19 +519,31 @@ func file_proto_log_proto_rawDescGZIP() []byte {
 	return file_proto_log_proto_rawDescData
 }
 
-var file_proto_log_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_log_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_proto_log_proto_goTypes = []any{
-	(*LogRequest)(nil),  // 0: proto.LogRequest
-	(*LogResponse)(nil), // 1: proto.LogResponse
+	(*LogRequest)(nil),       // 0: proto.LogRequest
+	(*LogResponse)(nil),      // 1: proto.LogResponse
+	(*LogBatchRequest)(nil),  // 2: proto.LogBatchRequest
+	(*LogRejection)(nil),     // 3: proto.LogRejection
+	(*LogBatchResponse)(nil), // 4: proto.LogBatchResponse
+	(*FrameAck)(nil),         // 5: proto.FrameAck
+	nil,                      // 6: proto.LogRequest.AttributesEntry
 }
 var file_proto_log_proto_depIdxs = []int32{
-	0, // 0: proto.LogService.SendLog:input_type -> proto.LogRequest
-	1, // 1: proto.LogService.SendLog:output_type -> proto.LogResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	6, // 0: proto.LogRequest.attributes:type_name -> proto.LogRequest.AttributesEntry
+	0, // 1: proto.LogBatchRequest.logs:type_name -> proto.LogRequest
+	3, // 2: proto.LogBatchResponse.rejections:type_name -> proto.LogRejection
+	0, // 3: proto.LogService.SendLog:input_type -> proto.LogRequest
+	0, // 4: proto.LogService.SendLogs:input_type -> proto.LogRequest
+	2, // 5: proto.LogService.SendLogBatch:input_type -> proto.LogBatchRequest
+	1, // 6: proto.LogService.SendLog:output_type -> proto.LogResponse
+	4, // 7: proto.LogService.SendLogs:output_type -> proto.LogBatchResponse
+	4, // 8: proto.LogService.SendLogBatch:output_type -> proto.LogBatchResponse
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proto_log_proto_init() }
@@ -275,7 +557,7 @@ func file_proto_log_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_log_proto_rawDesc), len(file_proto_log_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   7,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,7 +19,9 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	LogService_SendLog_FullMethodName = "/proto.LogService/SendLog"
+	LogService_SendLog_FullMethodName      = "/proto.LogService/SendLog"
+	LogService_SendLogs_FullMethodName     = "/proto.LogService/SendLogs"
+	LogService_SendLogBatch_FullMethodName = "/proto.LogService/SendLogBatch"
 )
 
 // LogServiceClient is the client API for LogService service.
@@ -27,6 +29,10 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LogServiceClient interface {
 	SendLog(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*LogResponse, error)
+	// SendLogs 客户端流式推送，流结束时统一返回接收结果
+	SendLogs(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[LogRequest, LogBatchResponse], error)
+	// SendLogBatch 一次请求推送多条日志
+	SendLogBatch(ctx context.Context, in *LogBatchRequest, opts ...grpc.CallOption) (*LogBatchResponse, error)
 }
 
 type logServiceClient struct {
@@ -47,11 +53,38 @@ func (c *logServiceClient) SendLog(ctx context.Context, in *LogRequest, opts ...
 	return out, nil
 }
 
+func (c *logServiceClient) SendLogs(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[LogRequest, LogBatchResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[0], LogService_SendLogs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[LogRequest, LogBatchResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_SendLogsClient = grpc.ClientStreamingClient[LogRequest, LogBatchResponse]
+
+func (c *logServiceClient) SendLogBatch(ctx context.Context, in *LogBatchRequest, opts ...grpc.CallOption) (*LogBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LogBatchResponse)
+	err := c.cc.Invoke(ctx, LogService_SendLogBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LogServiceServer is the server API for LogService service.
 // All implementations must embed UnimplementedLogServiceServer
 // for forward compatibility.
 type LogServiceServer interface {
 	SendLog(context.Context, *LogRequest) (*LogResponse, error)
+	// SendLogs 客户端流式推送，流结束时统一返回接收结果
+	SendLogs(grpc.ClientStreamingServer[LogRequest, LogBatchResponse]) error
+	// SendLogBatch 一次请求推送多条日志
+	SendLogBatch(context.Context, *LogBatchRequest) (*LogBatchResponse, error)
 	mustEmbedUnimplementedLogServiceServer()
 }
 
@@ -65,6 +98,12 @@ type UnimplementedLogServiceServer struct{}
 func (UnimplementedLogServiceServer) SendLog(context.Context, *LogRequest) (*LogResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendLog not implemented")
 }
+func (UnimplementedLogServiceServer) SendLogs(grpc.ClientStreamingServer[LogRequest, LogBatchResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method SendLogs not implemented")
+}
+func (UnimplementedLogServiceServer) SendLogBatch(context.Context, *LogBatchRequest) (*LogBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendLogBatch not implemented")
+}
 func (UnimplementedLogServiceServer) mustEmbedUnimplementedLogServiceServer() {}
 func (UnimplementedLogServiceServer) testEmbeddedByValue()                    {}
 
@@ -104,6 +143,31 @@ func _LogService_SendLog_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LogService_SendLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogServiceServer).SendLogs(&grpc.GenericServerStream[LogRequest, LogBatchResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_SendLogsServer = grpc.ClientStreamingServer[LogRequest, LogBatchResponse]
+
+func _LogService_SendLogBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServiceServer).SendLogBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LogService_SendLogBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServiceServer).SendLogBatch(ctx, req.(*LogBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // LogService_ServiceDesc is the grpc.ServiceDesc for LogService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,7 +179,17 @@ var LogService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendLog",
 			Handler:    _LogService_SendLog_Handler,
 		},
+		{
+			MethodName: "SendLogBatch",
+			Handler:    _LogService_SendLogBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendLogs",
+			Handler:       _LogService_SendLogs_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/log.proto",
 }